@@ -0,0 +1,328 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+// RulesConfig configures how the operator handles Rules
+type RulesConfig struct {
+	// Selector restricts the Rules handled by the operator to the ones
+	// matching these labels, all Rules are handled when unset
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// NamespaceSelector restricts the Rules handled by the operator to the
+	// namespaces matching these labels, all namespaces are handled when unset
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// DefaultInterval is the evaluation interval of groups that don't set
+	// one, it should match the global evaluation interval of Prometheus.
+	// Defaults to 1m.
+	// +optional
+	DefaultInterval string `json:"defaultInterval,omitempty"`
+
+	// Output, when set, renders every Rule handled by the operator into the
+	// ConfigMaps of this output, in addition to the outputs of the
+	// PrometheusTargets
+	// +optional
+	Output *OutputConfig `json:"output,omitempty"`
+
+	// Labels are injected into every rule rendered by the operator,
+	// overwriting the labels of the same name set by the Rules
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// NamespaceLabel, when set, is the name of a label injected into every
+	// rule rendered by the operator with the namespace of its Rule as value,
+	// overwriting the label of the same name set by the Rules
+	// +optional
+	NamespaceLabel string `json:"namespaceLabel,omitempty"`
+
+	// Prometheus configures the Prometheus server Rules are checked against
+	// +optional
+	Prometheus PrometheusConfig `json:"prometheus,omitempty"`
+
+	// Validation configures how invalid Rules are handled
+	// +optional
+	Validation ValidationConfig `json:"validation,omitempty"`
+
+	// Alertmanager configures the Alertmanager alerts are sent to
+	// +optional
+	Alertmanager AlertmanagerConfig `json:"alertmanager,omitempty"`
+
+	// SelfMonitoring configures the Rule alerting on the operator itself
+	// +optional
+	SelfMonitoring SelfMonitoringConfig `json:"selfMonitoring,omitempty"`
+}
+
+// PrometheusConfig configures the Prometheus server Rules are checked against
+type PrometheusConfig struct {
+	// URL of the Prometheus server, checks against Prometheus are disabled
+	// when empty
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// HTTPConfig configures the authentication and TLS of the requests to
+	// Prometheus
+	// +optional
+	HTTPConfig *HTTPClientConfig `json:"httpConfig,omitempty"`
+
+	// CheckInterval is the period at which Rules are checked against
+	// Prometheus. Defaults to 10m.
+	// +optional
+	CheckInterval *metav1.Duration `json:"checkInterval,omitempty"`
+
+	// CardinalityBudget is the number of series the recording rules of a
+	// namespace may produce, 0 means unlimited
+	// +optional
+	CardinalityBudget *int `json:"cardinalityBudget,omitempty"`
+
+	// NamespaceCardinalityBudgets override CardinalityBudget for specific
+	// namespaces
+	// +optional
+	NamespaceCardinalityBudgets map[string]int `json:"namespaceCardinalityBudgets,omitempty"`
+
+	// RefuseOverBudget leaves the Rules with a CardinalityBudgetExceeded
+	// condition out of the rendered rule files, they are only reported
+	// otherwise
+	// +optional
+	RefuseOverBudget bool `json:"refuseOverBudget,omitempty"`
+}
+
+// OutputConfig configures the output every Rule is rendered to
+type OutputConfig struct {
+	// Namespace of the ConfigMaps of the output
+	Namespace string `json:"namespace"`
+
+	monitoringv1alpha1.Output `json:",inline"`
+}
+
+// ValidationConfig configures how invalid Rules are handled
+type ValidationConfig struct {
+	// Strict rejects invalid Rules on admission, they are reported by their
+	// Invalid condition and left out of the rule files otherwise
+	// +optional
+	Strict bool `json:"strict,omitempty"`
+
+	// RefuseConflicts leaves the Rules with a Conflict condition out of the
+	// rendered rule files, they are only reported otherwise
+	// +optional
+	RefuseConflicts bool `json:"refuseConflicts,omitempty"`
+
+	// EnforceQuotas leaves the Rules with a QuotaExceeded condition out of
+	// the rendered rule files. RuleQuotas are only reported otherwise, which
+	// is the default.
+	// +optional
+	EnforceQuotas bool `json:"enforceQuotas,omitempty"`
+}
+
+// AlertmanagerConfig configures the Alertmanager alerts are sent to
+type AlertmanagerConfig struct {
+	// URL of the Alertmanager server Silences are created in, Silences are
+	// not synchronized when empty
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// HTTPConfig configures the authentication and TLS of the requests to
+	// Alertmanager
+	// +optional
+	HTTPConfig *HTTPClientConfig `json:"httpConfig,omitempty"`
+
+	// ExpiredSilenceRetention, when set, is how long expired Silences are
+	// kept before being deleted. Expired Silences are only reported by their
+	// state by default, leaving their deletion to their owner.
+	// +optional
+	ExpiredSilenceRetention *metav1.Duration `json:"expiredSilenceRetention,omitempty"`
+
+	// ConfigSecret references the Secret holding the Alertmanager
+	// configuration, used to preview the routing of alerts
+	// +optional
+	ConfigSecret *SecretKeyReference `json:"configSecret,omitempty"`
+
+	// GeneratedConfigSecret references the Secret the operator writes the
+	// configuration of ConfigSecret to, with the inhibition rules of the
+	// InhibitRules appended. Alertmanager must read its configuration from
+	// this Secret for the InhibitRules to apply. Requires ConfigSecret.
+	// +optional
+	GeneratedConfigSecret *SecretKeyReference `json:"generatedConfigSecret,omitempty"`
+}
+
+// HTTPClientConfig configures the authentication and TLS of the requests
+// of an integration
+type HTTPClientConfig struct {
+	// Namespace of the Secrets referenced by the configuration
+	Namespace string `json:"namespace"`
+
+	monitoringv1alpha1.HTTPConfig `json:",inline"`
+}
+
+// SecretKeyReference references a key of a Secret. The Secret must have the
+// monitoring.cyrilix.fr/secret label set to "true".
+type SecretKeyReference struct {
+	// Namespace of the Secret
+	Namespace string `json:"namespace"`
+
+	// Name of the Secret
+	Name string `json:"name"`
+
+	// Key of the Secret, defaults to alertmanager.yaml
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// SelfMonitoringConfig configures the Rule alerting on the metrics of the
+// operator itself
+type SelfMonitoringConfig struct {
+	// Enabled creates the Rule at startup and updates it when the
+	// configuration changes
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Namespace of the Rule, required when enabled
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the Rule, defaults to prometheus-rules-operator
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Labels of the Rule, to match the selector of the operator or of
+	// Prometheus
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Job is the job label of the operator metrics scraped by Prometheus.
+	// Defaults to prometheus-rules-operator-controller-manager-metrics-service.
+	// +optional
+	Job string `json:"job,omitempty"`
+
+	// For is how long a threshold must be exceeded before alerting. Defaults
+	// to 10m.
+	// +optional
+	For *metav1.Duration `json:"for,omitempty"`
+
+	// ReconcileErrors is the number of reconcile errors of a controller
+	// tolerated over 5 minutes. Defaults to 0.
+	// +optional
+	ReconcileErrors *int `json:"reconcileErrors,omitempty"`
+
+	// ProblemRules is the number of Rules of a namespace tolerated with a
+	// problem reported by their conditions. Defaults to 0.
+	// +optional
+	ProblemRules *int `json:"problemRules,omitempty"`
+
+	// WebhookLatency is the 99th percentile of the admission latency above
+	// which the webhooks are considered slow. Defaults to 1s.
+	// +optional
+	WebhookLatency *metav1.Duration `json:"webhookLatency,omitempty"`
+
+	// ReloadFailures is the number of failed configuration reloads of a
+	// PrometheusTarget tolerated over 1 hour, a target whose last reload
+	// failed always alerts. Defaults to 0.
+	// +optional
+	ReloadFailures *int `json:"reloadFailures,omitempty"`
+
+	// NotLoadedRules is the number of Rules selected by a PrometheusTarget
+	// tolerated without being loaded by it. Defaults to 0.
+	// +optional
+	NotLoadedRules *int `json:"notLoadedRules,omitempty"`
+}
+
+// ControllersConfig configures the concurrency and rate limiting of the
+// controllers, it is only read at startup
+type ControllersConfig struct {
+	// MaxConcurrentReconciles is the number of objects each controller
+	// reconciles concurrently. Defaults to 1.
+	// +optional
+	MaxConcurrentReconciles *int `json:"maxConcurrentReconciles,omitempty"`
+
+	// RateLimiter configures how the requests of the controllers are delayed
+	// +optional
+	RateLimiter RateLimiterConfig `json:"rateLimiter,omitempty"`
+
+	// Client configures the rate limits of the Kubernetes API client
+	// +optional
+	Client ClientConfig `json:"client,omitempty"`
+}
+
+// RateLimiterConfig configures the work queue rate limiter of each
+// controller: failing objects are retried with an exponential backoff and
+// all requests are limited by a token bucket
+type RateLimiterConfig struct {
+	// BaseDelay is the delay of the first retry of a failing object.
+	// Defaults to 5ms.
+	// +optional
+	BaseDelay *metav1.Duration `json:"baseDelay,omitempty"`
+
+	// MaxDelay is the maximum delay between the retries of a failing object.
+	// Defaults to 1000s.
+	// +optional
+	MaxDelay *metav1.Duration `json:"maxDelay,omitempty"`
+
+	// QPS is the number of requests per second of the token bucket.
+	// Defaults to 10.
+	// +optional
+	QPS *int `json:"qps,omitempty"`
+
+	// Burst is the size of the token bucket. Defaults to 100.
+	// +optional
+	Burst *int `json:"burst,omitempty"`
+}
+
+// ClientConfig configures the rate limits of the Kubernetes API client
+type ClientConfig struct {
+	// QPS is the number of queries per second sent to the API server.
+	// Defaults to 20.
+	// +optional
+	QPS *int `json:"qps,omitempty"`
+
+	// Burst is the number of queries sent above QPS during bursts.
+	// Defaults to 30.
+	// +optional
+	Burst *int `json:"burst,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// OperatorConfig is the Schema for the operator configuration file. The
+// controller manager settings are read at startup, the rules settings are
+// reloaded whenever the file changes.
+type OperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the configurations for controllers
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// Controllers configures the concurrency and rate limiting of the
+	// controllers
+	// +optional
+	Controllers ControllersConfig `json:"controllers,omitempty"`
+
+	// Rules configures how Rules are handled
+	// +optional
+	Rules RulesConfig `json:"rules,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OperatorConfig{})
+}
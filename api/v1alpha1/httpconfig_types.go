@@ -0,0 +1,112 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SecretLabel must be set to "true" on the Secrets read by the operator: the
+// Secrets of the HTTP configurations and of the Alertmanager configuration.
+// The operator only caches and watches the Secrets with this label.
+const SecretLabel = "monitoring.cyrilix.fr/secret"
+
+// HTTPConfig configures the authentication and TLS of HTTP requests. The
+// Secrets are read from the namespace of the object holding the
+// configuration and must have the SecretLabel label. BasicAuth,
+// BearerTokenSecret and OAuth2 are mutually exclusive.
+type HTTPConfig struct {
+	// BasicAuth authenticates the requests with a username and a password
+	//+optional
+	BasicAuth *BasicAuth `json:"basicAuth,omitempty"`
+
+	// BearerTokenSecret authenticates the requests with the bearer token
+	// stored in this Secret key
+	//+optional
+	BearerTokenSecret *corev1.SecretKeySelector `json:"bearerTokenSecret,omitempty"`
+
+	// OAuth2 authenticates the requests with the access tokens of the
+	// OAuth2 client credentials flow
+	//+optional
+	OAuth2 *OAuth2 `json:"oauth2,omitempty"`
+
+	// TLSConfig configures the TLS connections
+	//+optional
+	TLSConfig *TLSConfig `json:"tlsConfig,omitempty"`
+
+	// Timeout bounds each request, including reading its response. Defaults
+	// to 30s.
+	//+optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// BasicAuth references the username and the password of a basic
+// authentication
+type BasicAuth struct {
+	// Username is the Secret key holding the username
+	Username corev1.SecretKeySelector `json:"username"`
+
+	// Password is the Secret key holding the password
+	Password corev1.SecretKeySelector `json:"password"`
+}
+
+// OAuth2 configures the OAuth2 client credentials flow
+type OAuth2 struct {
+	// ClientID is the Secret key holding the client identifier
+	ClientID corev1.SecretKeySelector `json:"clientId"`
+
+	// ClientSecret is the Secret key holding the client secret
+	ClientSecret corev1.SecretKeySelector `json:"clientSecret"`
+
+	// TokenURL is the URL of the token endpoint
+	//+kubebuilder:validation:Pattern=`^https?://`
+	TokenURL string `json:"tokenUrl"`
+
+	// Scopes requested for the access tokens
+	//+optional
+	Scopes []string `json:"scopes,omitempty"`
+
+	// EndpointParams are additional parameters of the token requests
+	//+optional
+	EndpointParams map[string]string `json:"endpointParams,omitempty"`
+}
+
+// TLSConfig configures TLS connections
+type TLSConfig struct {
+	// CA is the Secret key holding the PEM bundle of the certificate
+	// authorities verifying the server, the system ones are used when unset
+	//+optional
+	CA *corev1.SecretKeySelector `json:"ca,omitempty"`
+
+	// Cert is the Secret key holding the PEM client certificate
+	//+optional
+	Cert *corev1.SecretKeySelector `json:"cert,omitempty"`
+
+	// Key is the Secret key holding the PEM key of the client certificate
+	//+optional
+	Key *corev1.SecretKeySelector `json:"key,omitempty"`
+
+	// ServerName is used to verify the server certificate, the host of the
+	// URL by default
+	//+optional
+	ServerName string `json:"serverName,omitempty"`
+
+	// InsecureSkipVerify disables the verification of the server certificate
+	//+optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InhibitRuleSpec defines an Alertmanager inhibition rule
+type InhibitRuleSpec struct {
+	// SourceMatchers select the alerts muting the target alerts
+	//+kubebuilder:validation:MinItems=1
+	SourceMatchers []Matcher `json:"sourceMatchers"`
+
+	// TargetMatchers select the alerts muted while a source alert fires
+	//+kubebuilder:validation:MinItems=1
+	TargetMatchers []Matcher `json:"targetMatchers"`
+
+	// Equal lists the labels that must have the same value in the source and
+	// target alerts
+	//+optional
+	Equal []string `json:"equal,omitempty"`
+}
+
+// InhibitRuleStatus defines the observed state of InhibitRule
+type InhibitRuleStatus struct {
+	// Conditions represent the latest available observations of the
+	// InhibitRule
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// InhibitRule is the Schema for the inhibitrules API, it mutes alerts while
+// other alerts fire. The valid InhibitRules are added to the Alertmanager
+// configuration written to the generatedConfigSecret of the operator
+// settings.
+type InhibitRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InhibitRuleSpec   `json:"spec,omitempty"`
+	Status InhibitRuleStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InhibitRuleList contains a list of InhibitRule
+type InhibitRuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InhibitRule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InhibitRule{}, &InhibitRuleList{})
+}
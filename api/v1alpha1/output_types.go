@@ -0,0 +1,42 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// Output configures where the rule files rendered from a set of Rules are
+// written
+type Output struct {
+	// ConfigMap writes the rule files into ConfigMaps, to be mounted in the
+	// Prometheus pods
+	ConfigMap ConfigMapOutput `json:"configMap"`
+}
+
+// ConfigMapOutput writes the rule files into ConfigMaps named <name>-<shard>,
+// each holding a rules.yaml key. The Rules of a namespace are always written
+// to the same shard, so that their merged groups are not split. ConfigMaps
+// changed outside of the operator are restored.
+type ConfigMapOutput struct {
+	// Name prefixes the names of the ConfigMaps
+	//+kubebuilder:validation:MinLength=1
+	//+kubebuilder:validation:MaxLength=200
+	Name string `json:"name"`
+
+	// Shards is the number of ConfigMaps the Rules are spread across, to stay
+	// below the size limit of a ConfigMap. Defaults to 1.
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	Shards *int32 `json:"shards,omitempty"`
+}
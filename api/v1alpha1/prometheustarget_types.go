@@ -0,0 +1,122 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PrometheusTargetSpec describes a Prometheus server, or a compatible ruler,
+// and the Rules it evaluates
+type PrometheusTargetSpec struct {
+	// URL of the Prometheus server, its rules API is queried to check that
+	// the Rules are loaded
+	//+kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+
+	// Selector selects the Rules evaluated by the target by label, all Rules
+	// are selected when unset
+	//+optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// NamespaceSelector selects the namespaces of the Rules evaluated by the
+	// target, all namespaces are selected when unset
+	//+optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Output configures where the rule files of the target are written, in
+	// the namespace of the target
+	Output Output `json:"output"`
+
+	// ExternalLabels are added to the labels of every rule rendered for the
+	// target, unless the rule sets them
+	//+optional
+	ExternalLabels map[string]string `json:"externalLabels,omitempty"`
+
+	// HTTPConfig configures the authentication and TLS of the requests to
+	// the target
+	//+optional
+	HTTPConfig HTTPConfig `json:"httpConfig,omitempty"`
+}
+
+// Condition types reported in PrometheusTargetStatus
+const (
+	// ConditionUnreachable is true when the rules API of a PrometheusTarget
+	// can't be queried
+	ConditionUnreachable = "Unreachable"
+
+	// ConditionRenderFailed is true when a rule file of a PrometheusTarget
+	// can't be written to its output
+	ConditionRenderFailed = "RenderFailed"
+)
+
+// ShardStatus reports the content of a ConfigMap of an output
+type ShardStatus struct {
+	// Name of the ConfigMap
+	Name string `json:"name"`
+
+	// Groups is the number of rule groups written to the ConfigMap
+	Groups int32 `json:"groups"`
+
+	// Bytes is the size of the rule file
+	Bytes int64 `json:"bytes"`
+}
+
+// PrometheusTargetStatus defines the observed state of PrometheusTarget
+type PrometheusTargetStatus struct {
+	// Conditions represent the latest available observations of the
+	// PrometheusTarget
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Rules is the number of Rules selected by the target
+	//+optional
+	Rules int32 `json:"rules,omitempty"`
+
+	// Shards reports the ConfigMaps written to the output of the target
+	//+optional
+	Shards []ShardStatus `json:"shards,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.spec.url`
+//+kubebuilder:printcolumn:name="Rules",type=integer,JSONPath=`.status.rules`
+
+// PrometheusTarget is the Schema for the prometheustargets API, it describes
+// a Prometheus server, the output its selected Rules are rendered to and
+// where they are expected to be loaded
+type PrometheusTarget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PrometheusTargetSpec   `json:"spec,omitempty"`
+	Status PrometheusTargetStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PrometheusTargetList contains a list of PrometheusTarget
+type PrometheusTargetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PrometheusTarget `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PrometheusTarget{}, &PrometheusTargetList{})
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/cyrilix/prometheus-rules-operator/api/v1beta1"
+)
+
+// The types without nested API types are converted with Go conversions,
+// which stop compiling as soon as the versions differ.
+
+// ConvertTo converts this Rule to the Hub version, v1beta1
+func (r *Rule) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.Rule)
+	dst.ObjectMeta = r.ObjectMeta
+
+	dst.Spec.Groups = nil
+	for _, group := range r.Spec.Groups {
+		g := v1beta1.RuleGroup{
+			Name:        group.Name,
+			Interval:    group.Interval,
+			QueryOffset: group.QueryOffset,
+			MergeKey:    group.MergeKey,
+			Priority:    group.Priority,
+		}
+		if group.Rules != nil {
+			g.Rules = make([]v1beta1.RuleDefinition, 0, len(group.Rules))
+		}
+		for _, def := range group.Rules {
+			g.Rules = append(g.Rules, v1beta1.RuleDefinition(def))
+		}
+		dst.Spec.Groups = append(dst.Spec.Groups, g)
+	}
+
+	status := &r.Status
+	dst.Status = v1beta1.RuleStatus{Conditions: status.Conditions, MissingRecords: status.MissingRecords}
+	for _, dependency := range status.Dependencies {
+		dst.Status.Dependencies = append(dst.Status.Dependencies, v1beta1.RecordReference(dependency))
+	}
+	if dryRun := status.DryRun; dryRun != nil {
+		dst.Status.DryRun = &v1beta1.DryRunStatus{
+			ObservedGeneration: dryRun.ObservedGeneration,
+			Window:             dryRun.Window,
+			Time:               dryRun.Time,
+		}
+		for _, alert := range dryRun.Alerts {
+			dst.Status.DryRun.Alerts = append(dst.Status.DryRun.Alerts, v1beta1.AlertDryRun(alert))
+		}
+	}
+	if cardinality := status.Cardinality; cardinality != nil {
+		dst.Status.Cardinality = &v1beta1.CardinalityStatus{
+			ObservedGeneration: cardinality.ObservedGeneration,
+			Time:               cardinality.Time,
+			Series:             cardinality.Series,
+		}
+		for _, record := range cardinality.Records {
+			dst.Status.Cardinality.Records = append(dst.Status.Cardinality.Records, v1beta1.RecordCardinality(record))
+		}
+	}
+	for _, routing := range status.Routing {
+		dst.Status.Routing = append(dst.Status.Routing, v1beta1.AlertRouting(routing))
+	}
+	for _, merged := range status.MergedGroups {
+		dst.Status.MergedGroups = append(dst.Status.MergedGroups, v1beta1.MergedGroupStatus(merged))
+	}
+	for _, target := range status.Targets {
+		dst.Status.Targets = append(dst.Status.Targets, v1beta1.TargetStatus(target))
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version, v1beta1, to this version
+func (r *Rule) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.Rule)
+	r.ObjectMeta = src.ObjectMeta
+
+	r.Spec.Groups = nil
+	for _, group := range src.Spec.Groups {
+		g := RuleGroup{
+			Name:        group.Name,
+			Interval:    group.Interval,
+			QueryOffset: group.QueryOffset,
+			MergeKey:    group.MergeKey,
+			Priority:    group.Priority,
+		}
+		if group.Rules != nil {
+			g.Rules = make([]RuleDefinition, 0, len(group.Rules))
+		}
+		for _, def := range group.Rules {
+			g.Rules = append(g.Rules, RuleDefinition(def))
+		}
+		r.Spec.Groups = append(r.Spec.Groups, g)
+	}
+
+	status := &src.Status
+	r.Status = RuleStatus{Conditions: status.Conditions, MissingRecords: status.MissingRecords}
+	for _, dependency := range status.Dependencies {
+		r.Status.Dependencies = append(r.Status.Dependencies, RecordReference(dependency))
+	}
+	if dryRun := status.DryRun; dryRun != nil {
+		r.Status.DryRun = &DryRunStatus{
+			ObservedGeneration: dryRun.ObservedGeneration,
+			Window:             dryRun.Window,
+			Time:               dryRun.Time,
+		}
+		for _, alert := range dryRun.Alerts {
+			r.Status.DryRun.Alerts = append(r.Status.DryRun.Alerts, AlertDryRun(alert))
+		}
+	}
+	if cardinality := status.Cardinality; cardinality != nil {
+		r.Status.Cardinality = &CardinalityStatus{
+			ObservedGeneration: cardinality.ObservedGeneration,
+			Time:               cardinality.Time,
+			Series:             cardinality.Series,
+		}
+		for _, record := range cardinality.Records {
+			r.Status.Cardinality.Records = append(r.Status.Cardinality.Records, RecordCardinality(record))
+		}
+	}
+	for _, routing := range status.Routing {
+		r.Status.Routing = append(r.Status.Routing, AlertRouting(routing))
+	}
+	for _, merged := range status.MergedGroups {
+		r.Status.MergedGroups = append(r.Status.MergedGroups, MergedGroupStatus(merged))
+	}
+	for _, target := range status.Targets {
+		r.Status.Targets = append(r.Status.Targets, TargetStatus(target))
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	fuzz "github.com/google/gofuzz"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cyrilix/prometheus-rules-operator/api/v1beta1"
+)
+
+// fuzzIterations is the number of random Rules converted in each direction
+const fuzzIterations = 1000
+
+// newFuzzer returns a fuzzer filling the exported fields of the Rules,
+// including metav1.Time which has none
+func newFuzzer() *fuzz.Fuzzer {
+	return fuzz.New().NilChance(0.2).NumElements(0, 3).Funcs(
+		func(t *metav1.Time, c fuzz.Continue) {
+			*t = metav1.Unix(c.Int63n(1<<32), 0)
+		},
+		func(d *metav1.Duration, c fuzz.Continue) {
+			d.Duration = time.Duration(c.Int63())
+		},
+	)
+}
+
+var _ = Describe("Rule conversion", func() {
+	It("should convert to the hub and back without loss", func() {
+		f := newFuzzer()
+		for i := 0; i < fuzzIterations; i++ {
+			var rule Rule
+			f.Fuzz(&rule)
+			rule.TypeMeta = metav1.TypeMeta{}
+
+			var hub v1beta1.Rule
+			Expect(rule.ConvertTo(&hub)).To(Succeed())
+			var converted Rule
+			Expect(converted.ConvertFrom(&hub)).To(Succeed())
+			Expect(equality.Semantic.DeepEqual(&converted, &rule)).To(BeTrue(),
+				"%+v converted to %+v", rule, converted)
+		}
+	})
+
+	It("should convert from the hub and back without loss", func() {
+		f := newFuzzer()
+		for i := 0; i < fuzzIterations; i++ {
+			var hub v1beta1.Rule
+			f.Fuzz(&hub)
+			hub.TypeMeta = metav1.TypeMeta{}
+
+			var rule Rule
+			Expect(rule.ConvertFrom(&hub)).To(Succeed())
+			var converted v1beta1.Rule
+			Expect(rule.ConvertTo(&converted)).To(Succeed())
+			Expect(equality.Semantic.DeepEqual(&converted, &hub)).To(BeTrue(),
+				"%+v converted to %+v", hub, converted)
+		}
+	})
+
+	It("should convert a Rule setting every field without loss", func() {
+		Expect(unsetFields(reflect.ValueOf(completeRule.Spec), "spec")).To(BeEmpty(),
+			"completeRule must set every field, including the new ones")
+		Expect(unsetFields(reflect.ValueOf(completeRule.Status), "status")).To(BeEmpty(),
+			"completeRule must set every field, including the new ones")
+
+		var hub v1beta1.Rule
+		Expect(completeRule.ConvertTo(&hub)).To(Succeed())
+		Expect(unsetFields(reflect.ValueOf(hub.Spec), "spec")).To(BeEmpty())
+		Expect(unsetFields(reflect.ValueOf(hub.Status), "status")).To(BeEmpty())
+		var converted Rule
+		Expect(converted.ConvertFrom(&hub)).To(Succeed())
+		Expect(equality.Semantic.DeepEqual(&converted, completeRule)).To(BeTrue(),
+			"%+v converted to %+v", completeRule, converted)
+	})
+})
+
+// completeRule sets every field of the spec and status of a Rule, checked by
+// unsetFields, so that a field added to both versions but left out of the
+// conversion fails the tests
+var completeRule = &Rule{
+	ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "api", Generation: 2},
+	Spec: RuleSpec{Groups: []RuleGroup{{
+		Name:        "api",
+		Interval:    "1m",
+		QueryOffset: "30s",
+		Rules: []RuleDefinition{{
+			Record:      "job:errors:rate5m",
+			Alert:       "HighErrorRate",
+			Expr:        "sum by (job) (rate(errors_total[5m]))",
+			For:         "5m",
+			Labels:      map[string]string{"severity": "warning"},
+			Annotations: map[string]string{"summary": "high error rate"},
+		}},
+		MergeKey: "api",
+		Priority: 1,
+	}}},
+	Status: RuleStatus{
+		Conditions: []metav1.Condition{{
+			Type:               ConditionInvalid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: 2,
+			LastTransitionTime: metav1.Unix(1000, 0),
+			Reason:             "Valid",
+			Message:            "Rule is valid",
+		}},
+		Dependencies:   []RecordReference{{Record: "job:up:sum", Namespace: "team-b", Name: "up"}},
+		MissingRecords: []string{"job:down:sum"},
+		DryRun: &DryRunStatus{
+			ObservedGeneration: 2,
+			Window:             metav1.Duration{Duration: time.Hour},
+			Time:               metav1.Unix(1000, 0),
+			Alerts: []AlertDryRun{{
+				Group:          "api",
+				Alert:          "HighErrorRate",
+				Series:         2,
+				Firings:        1,
+				FiringDuration: metav1.Duration{Duration: time.Minute},
+				Error:          "timeout",
+			}},
+		},
+		Cardinality: &CardinalityStatus{
+			ObservedGeneration: 2,
+			Time:               metav1.Unix(1000, 0),
+			Series:             10,
+			Records:            []RecordCardinality{{Group: "api", Record: "job:errors:rate5m", Series: 10, Error: "timeout"}},
+		},
+		Routing: []AlertRouting{{
+			Group:     "api",
+			Alert:     "HighErrorRate",
+			Receivers: []string{"team-a"},
+			Routes:    []string{"{}/{team=\"a\"}"},
+		}},
+		MergedGroups: []MergedGroupStatus{{
+			Group:        "api",
+			MergedGroup:  "api",
+			Position:     1,
+			Contributors: []string{"team-a/api"},
+		}},
+		Targets: []TargetStatus{{
+			Target:             "monitoring/main",
+			ObservedGeneration: 2,
+			Since:              &metav1.Time{Time: time.Unix(1000, 0)},
+			Groups:             []string{"api"},
+			Shard:              "rules-0",
+			Rendered:           true,
+			Loaded:             true,
+			Message:            "loaded",
+		}},
+	},
+}
+
+// unsetFields returns the paths of the fields of v left to their zero value.
+// The fields of the structs of other packages, like metav1.Time, aren't
+// inspected.
+func unsetFields(v reflect.Value, path string) []string {
+	if v.IsZero() {
+		return []string{path}
+	}
+	var unset []string
+	switch v.Kind() {
+	case reflect.Ptr:
+		return unsetFields(v.Elem(), path)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			unset = append(unset, unsetFields(v.Index(i), fmt.Sprintf("%v[%d]", path, i))...)
+		}
+	case reflect.Struct:
+		if v.Type().PkgPath() != reflect.TypeOf(Rule{}).PkgPath() &&
+			v.Type().PkgPath() != reflect.TypeOf(v1beta1.Rule{}).PkgPath() {
+			return nil
+		}
+		for i := 0; i < v.NumField(); i++ {
+			unset = append(unset, unsetFields(v.Field(i), path+"."+v.Type().Field(i).Name)...)
+		}
+	}
+	return unset
+}
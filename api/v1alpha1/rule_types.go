@@ -18,24 +18,285 @@ package v1alpha1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-)
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+	"github.com/cyrilix/prometheus-rules-operator/api/v1beta1"
+)
 
 // RuleSpec defines the desired state of Rule
 type RuleSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Groups is the list of Prometheus rule groups defined by this Rule
+	Groups []RuleGroup `json:"groups"`
+}
+
+// RuleGroup is a list of recording and alerting rules evaluated sequentially
+// at the same interval, see
+// https://prometheus.io/docs/prometheus/latest/configuration/recording_rules/#rule_group
+type RuleGroup struct {
+	// Name of the group, must be unique within the Rule
+	Name string `json:"name"`
+
+	// Interval is how often rules in the group are evaluated, defaults to the
+	// Prometheus global evaluation interval
+	//+optional
+	Interval string `json:"interval,omitempty"`
+
+	// QueryOffset delays the evaluation timestamp of the rules in the group,
+	// for samples ingested late, defaults to the Prometheus global rule query
+	// offset. It doesn't change when the group is evaluated: Prometheus
+	// spreads the groups over their interval by itself, from a hash of their
+	// name and rule file. Requires Prometheus 2.53 or later.
+	//+optional
+	QueryOffset string `json:"queryOffset,omitempty"`
+
+	// Rules of the group
+	Rules []RuleDefinition `json:"rules"`
+
+	// MergeKey, when set, merges the rules of this group with the groups of
+	// the other Rules of the namespace sharing the same key, into a single
+	// group named after the key
+	//+optional
+	MergeKey string `json:"mergeKey,omitempty"`
+
+	// Priority orders the groups merged with the same MergeKey, lowest
+	// first. Ties are broken on the name of the Rule, then of the group.
+	//+optional
+	Priority int32 `json:"priority,omitempty"`
+}
+
+// RuleDefinition describes a single recording or alerting rule. Exactly one
+// of Record or Alert must be set.
+type RuleDefinition struct {
+	// Record is the name of the time series to output to
+	//+optional
+	Record string `json:"record,omitempty"`
+
+	// Alert is the name of the alert
+	//+optional
+	Alert string `json:"alert,omitempty"`
+
+	// Expr is the PromQL expression to evaluate
+	Expr string `json:"expr"`
+
+	// For is the duration an alert has to be pending before firing
+	//+optional
+	For string `json:"for,omitempty"`
+
+	// Labels to add or overwrite on each resulting series or alert
+	//+optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations to add to each alert
+	//+optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// DryRunAnnotation requests the evaluation of the alerts of a Rule over past
+// data, see v1beta1.DryRunAnnotation
+const DryRunAnnotation = v1beta1.DryRunAnnotation
+
+// Condition types reported in RuleStatus, documented in the hub version
+const (
+	ConditionConflict                  = v1beta1.ConditionConflict
+	ConditionMissingDependency         = v1beta1.ConditionMissingDependency
+	ConditionDependencyCycle           = v1beta1.ConditionDependencyCycle
+	ConditionStaleMetrics              = v1beta1.ConditionStaleMetrics
+	ConditionCardinalityBudgetExceeded = v1beta1.ConditionCardinalityBudgetExceeded
+	ConditionQuotaExceeded             = v1beta1.ConditionQuotaExceeded
+	ConditionInvalid                   = v1beta1.ConditionInvalid
+	ConditionMergeConflict             = v1beta1.ConditionMergeConflict
+	ConditionDryRun                    = v1beta1.ConditionDryRun
+)
+
+// RecordReference identifies a recording rule defined by a Rule
+type RecordReference struct {
+	// Record is the name of the recorded time series
+	Record string `json:"record"`
+
+	// Namespace of the Rule defining the recording rule
+	Namespace string `json:"namespace"`
+
+	// Name of the Rule defining the recording rule
+	Name string `json:"name"`
+}
+
+// DryRunStatus is the result of evaluating the alerts of a Rule over past data
+type DryRunStatus struct {
+	// ObservedGeneration is the generation of the evaluated Rule
+	ObservedGeneration int64 `json:"observedGeneration"`
+
+	// Window is the evaluated period, ending at Time
+	Window metav1.Duration `json:"window"`
+
+	// Time is when the evaluation ran
+	Time metav1.Time `json:"time"`
+
+	// Alerts are the results for each alerting rule
+	//+optional
+	Alerts []AlertDryRun `json:"alerts,omitempty"`
+}
+
+// AlertDryRun is the result of evaluating an alerting rule over past data
+type AlertDryRun struct {
+	// Group of the alerting rule
+	Group string `json:"group"`
+
+	// Alert is the name of the alerting rule
+	Alert string `json:"alert"`
+
+	// Series is the number of distinct series returned by the expression
+	Series int `json:"series"`
+
+	// Firings is the estimated number of times an alert would have fired
+	Firings int `json:"firings"`
+
+	// FiringDuration is the estimated time alerts would have been firing,
+	// summed over all series
+	FiringDuration metav1.Duration `json:"firingDuration"`
+
+	// Error reported when evaluating the expression
+	//+optional
+	Error string `json:"error,omitempty"`
+}
+
+// CardinalityStatus reports the number of series produced by the recording
+// rules of a Rule
+type CardinalityStatus struct {
+	// ObservedGeneration is the generation of the measured Rule
+	ObservedGeneration int64 `json:"observedGeneration"`
+
+	// Time is when the recording rules were evaluated
+	Time metav1.Time `json:"time"`
+
+	// Series is the total number of series produced by the recording rules
+	Series int `json:"series"`
+
+	// Records are the results for each recording rule
+	//+optional
+	Records []RecordCardinality `json:"records,omitempty"`
+}
+
+// RecordCardinality is the number of series produced by a recording rule
+type RecordCardinality struct {
+	// Group of the recording rule
+	Group string `json:"group"`
+
+	// Record is the name of the recording rule
+	Record string `json:"record"`
+
+	// Series is the number of series returned by the expression
+	Series int `json:"series"`
 
-	// Foo is an example field of Rule. Edit rule_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// Error reported when evaluating the expression
+	//+optional
+	Error string `json:"error,omitempty"`
+}
+
+// AlertRouting is the Alertmanager routing of an alerting rule
+type AlertRouting struct {
+	// Group of the alerting rule
+	Group string `json:"group"`
+
+	// Alert is the name of the alerting rule
+	Alert string `json:"alert"`
+
+	// Receivers are the Alertmanager receivers the alerts are sent to
+	Receivers []string `json:"receivers"`
+
+	// Routes identify the matching Alertmanager routes by the matchers of
+	// each level of the routing tree
+	Routes []string `json:"routes"`
+}
+
+// MergedGroupStatus reports the merged group a group of the Rule landed in
+type MergedGroupStatus struct {
+	// Group of the Rule
+	Group string `json:"group"`
+
+	// MergedGroup is the name of the merged group, its merge key
+	MergedGroup string `json:"mergedGroup"`
+
+	// Position is the index of the first rule of the group in the merged
+	// group
+	Position int32 `json:"position"`
+
+	// Contributors are the groups merged, in evaluation order, as
+	// namespace/rule/group
+	Contributors []string `json:"contributors"`
+}
+
+// TargetStatus reports whether a PrometheusTarget selecting the Rule rendered
+// and loaded its groups
+type TargetStatus struct {
+	// Target is the PrometheusTarget, as namespace/name
+	Target string `json:"target"`
+
+	// ObservedGeneration is the generation of the Rule checked
+	ObservedGeneration int64 `json:"observedGeneration"`
+
+	// Since is when this generation of the Rule was first checked against
+	// the target, the time taken to load it is measured from there
+	//+optional
+	Since *metav1.Time `json:"since,omitempty"`
+
+	// Groups are the names of the groups of the Rule rendered for the target
+	Groups []string `json:"groups"`
+
+	// Shard is the ConfigMap of the output of the target the groups are
+	// rendered to, as namespace/name
+	Shard string `json:"shard"`
+
+	// Rendered is true when the shard holds the groups of this generation
+	Rendered bool `json:"rendered"`
+
+	// Loaded is true when the target evaluates every rule of the groups
+	Loaded bool `json:"loaded"`
+
+	// Message explains why the groups are not rendered or loaded
+	//+optional
+	Message string `json:"message,omitempty"`
 }
 
 // RuleStatus defines the observed state of Rule
 type RuleStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Conditions represent the latest available observations of the Rule state
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Dependencies lists the recording rules of other Rules whose metrics
+	// are used by the expressions of this Rule
+	//+optional
+	Dependencies []RecordReference `json:"dependencies,omitempty"`
+
+	// MissingRecords lists the recorded metrics used by the expressions of
+	// this Rule whose recording rules were deleted
+	//+optional
+	MissingRecords []string `json:"missingRecords,omitempty"`
+
+	// DryRun is the result of the last evaluation of the alerts, requested
+	// with the monitoring.cyrilix.fr/dry-run annotation
+	//+optional
+	DryRun *DryRunStatus `json:"dryRun,omitempty"`
+
+	// Cardinality is the number of series produced by the recording rules,
+	// measured against Prometheus
+	//+optional
+	Cardinality *CardinalityStatus `json:"cardinality,omitempty"`
+
+	// Routing is the Alertmanager routing of each alerting rule, based on its
+	// name, its static labels and the labels injected by the operator, since
+	// labels returned by expressions are only known at evaluation
+	//+optional
+	Routing []AlertRouting `json:"routing,omitempty"`
+
+	// MergedGroups reports the merged group each group with a merge key
+	// landed in
+	//+optional
+	MergedGroups []MergedGroupStatus `json:"mergedGroups,omitempty"`
+
+	// Targets reports, for each PrometheusTarget selecting the Rule, whether
+	// it rendered and loaded the groups of the Rule
+	//+optional
+	Targets []TargetStatus `json:"targets,omitempty"`
 }
 
 //+kubebuilder:object:root=true
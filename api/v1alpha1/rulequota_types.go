@@ -0,0 +1,87 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RuleQuotaSpec defines the limits applied to the Rules of a namespace. The
+// Rules exceeding them are reported by their QuotaExceeded condition, and only
+// left out of the rule files when the operator enforces quotas.
+type RuleQuotaSpec struct {
+	// MaxGroups is the maximum number of rule groups defined by the Rules of
+	// the namespace
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	MaxGroups *int32 `json:"maxGroups,omitempty"`
+
+	// MaxRules is the maximum number of recording and alerting rules defined
+	// by the Rules of the namespace
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	MaxRules *int32 `json:"maxRules,omitempty"`
+
+	// MinInterval is the minimum evaluation interval of rule groups, like 30s.
+	// Groups without interval use the Prometheus global evaluation interval
+	// and are not limited.
+	//+optional
+	//+kubebuilder:validation:Pattern=`^((([0-9]+)y)?(([0-9]+)w)?(([0-9]+)d)?(([0-9]+)h)?(([0-9]+)m)?(([0-9]+)s)?(([0-9]+)ms)?|0)$`
+	MinInterval string `json:"minInterval,omitempty"`
+}
+
+// RuleQuotaUsage is the number of groups and rules defined by Rules
+type RuleQuotaUsage struct {
+	// Groups is the number of rule groups
+	Groups int32 `json:"groups"`
+
+	// Rules is the number of recording and alerting rules
+	Rules int32 `json:"rules"`
+}
+
+// RuleQuotaStatus defines the observed state of RuleQuota
+type RuleQuotaStatus struct {
+	// Used is the current usage of the namespace
+	//+optional
+	Used RuleQuotaUsage `json:"used,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// RuleQuota is the Schema for the rulequotas API, it limits the Rules of its
+// namespace
+type RuleQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RuleQuotaSpec   `json:"spec,omitempty"`
+	Status RuleQuotaStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RuleQuotaList contains a list of RuleQuota
+type RuleQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RuleQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RuleQuota{}, &RuleQuotaList{})
+}
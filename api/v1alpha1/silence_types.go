@@ -0,0 +1,125 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Matcher selects alerts by the value of one of their labels
+type Matcher struct {
+	// Name of the label
+	Name string `json:"name"`
+
+	// Value of the label, an anchored regular expression when IsRegex is set
+	Value string `json:"value"`
+
+	// IsRegex matches Value as a regular expression
+	//+optional
+	IsRegex bool `json:"isRegex,omitempty"`
+
+	// IsNegative selects the alerts whose label doesn't match Value
+	//+optional
+	IsNegative bool `json:"isNegative,omitempty"`
+}
+
+// SilenceSpec defines an Alertmanager silence
+type SilenceSpec struct {
+	// Matchers select the alerts to silence
+	//+kubebuilder:validation:MinItems=1
+	Matchers []Matcher `json:"matchers"`
+
+	// StartsAt is when the silence starts, defaults to the creation of the
+	// Silence
+	//+optional
+	StartsAt *metav1.Time `json:"startsAt,omitempty"`
+
+	// EndsAt is when the silence expires
+	EndsAt metav1.Time `json:"endsAt"`
+
+	// CreatedBy is the author of the silence
+	//+kubebuilder:validation:MinLength=1
+	CreatedBy string `json:"createdBy"`
+
+	// Comment explains the silence
+	//+kubebuilder:validation:MinLength=1
+	Comment string `json:"comment"`
+}
+
+// SilenceState is the state of a silence in Alertmanager
+type SilenceState string
+
+const (
+	SilenceStatePending SilenceState = "pending"
+	SilenceStateActive  SilenceState = "active"
+	SilenceStateExpired SilenceState = "expired"
+)
+
+const (
+	// ConditionSyncFailed is true when the object could not be synchronized
+	// with Alertmanager
+	ConditionSyncFailed = "SyncFailed"
+)
+
+// SilenceStatus defines the observed state of Silence
+type SilenceStatus struct {
+	// ObservedGeneration is the generation of the Silence last sent to
+	// Alertmanager
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// ID of the silence in Alertmanager
+	//+optional
+	ID string `json:"id,omitempty"`
+
+	// State of the silence in Alertmanager
+	//+optional
+	State SilenceState `json:"state,omitempty"`
+
+	// Conditions represent the latest available observations of the Silence
+	//+optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+//+kubebuilder:printcolumn:name="Ends At",type=string,format=date-time,JSONPath=`.spec.endsAt`
+
+// Silence is the Schema for the silences API, it mutes alerts in Alertmanager
+// until it expires or is deleted. Expired Silences are kept, with their
+// expired state, unless a retention is configured for the operator.
+type Silence struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SilenceSpec   `json:"spec,omitempty"`
+	Status SilenceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SilenceList contains a list of Silence
+type SilenceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Silence `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Silence{}, &SilenceList{})
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command convert-rules converts Prometheus rule files into Rule manifests.
+//
+//	convert-rules [flags] PATH...
+//
+// PATH is a rule file or a directory whose .yml and .yaml files are
+// converted. Each conversion is verified by rendering the Rules back into
+// the rules of the original file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/convert"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/validation"
+)
+
+func main() {
+	var options convert.Options
+	var mode, ruleLabels, output string
+	flag.StringVar(&options.Namespace, "namespace", "default", "The namespace of the Rules.")
+	flag.StringVar(&ruleLabels, "labels", "", "Comma separated list of name=value labels set on the Rules.")
+	flag.StringVar(&mode, "mode", string(convert.PerFile),
+		"How rule files are split into Rules: file converts each file into a Rule, group each group.")
+	flag.StringVar(&output, "output", "",
+		"The directory the manifests are written to, one file per Rule. "+
+			"Manifests are written to the standard output when empty.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Convert Prometheus rule files into Rule manifests.\n\n"+
+			"Usage:\n  %v [flags] PATH...\n\nFlags:\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	options.Mode = convert.Mode(mode)
+	set, err := labels.ConvertSelectorToLabelsMap(ruleLabels)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid labels: %v\n", err)
+		os.Exit(2)
+	}
+	if len(set) > 0 {
+		options.Labels = set
+	}
+
+	if err := run(&convert.Converter{Options: options}, flag.Args(), output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run converts the rule files of paths and writes the manifests of the Rules
+// to output, or to the standard output when empty
+func run(c *convert.Converter, paths []string, output string) error {
+	files, err := ruleFiles(paths)
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		if err := os.MkdirAll(output, 0755); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		file, err := convert.Parse(data)
+		if err != nil {
+			return fmt.Errorf("unable to parse %v: %w", path, err)
+		}
+		rules, err := c.Convert(path, file)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			for _, problem := range validation.Validate(rule) {
+				fmt.Fprintf(os.Stderr, "warning: %v, Rule %v: %v\n", path, rule.Name, problem)
+			}
+			manifest, err := marshal(rule)
+			if err != nil {
+				return err
+			}
+			if err := write(output, rule.Name, manifest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ruleFiles returns the sorted rule files of paths, looking for .yml and
+// .yaml files in directories
+func ruleFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ext := filepath.Ext(path); !info.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// marshal returns the YAML manifest of rule, without status nor empty
+// creation timestamp
+func marshal(rule *monitoringv1alpha1.Rule) ([]byte, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(rule)
+	if err != nil {
+		return nil, err
+	}
+	delete(obj, "status")
+	unstructured.RemoveNestedField(obj, "metadata", "creationTimestamp")
+	return yaml.Marshal(obj)
+}
+
+// write writes manifest to the file of the Rule named name in output, or to
+// the standard output as a document of a YAML stream
+func write(output, name string, manifest []byte) error {
+	if output == "" {
+		_, err := io.WriteString(os.Stdout, "---\n"+string(manifest))
+		return err
+	}
+	path := filepath.Join(output, name+".yaml")
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%v already exists", path)
+	}
+	return ioutil.WriteFile(path, manifest, 0644)
+}
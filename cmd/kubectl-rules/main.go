@@ -0,0 +1,458 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command kubectl-rules is a kubectl plugin linting, rendering and diffing
+// Rule manifests with the validation and rendering code of the operator.
+//
+//	kubectl rules lint FILE...
+//	kubectl rules render FILE...
+//	kubectl rules diff FILE...
+//
+// FILE may be - to read the standard input.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	monitoringv1beta1 "github.com/cyrilix/prometheus-rules-operator/api/v1beta1"
+	"github.com/cyrilix/prometheus-rules-operator/controllers"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/merge"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/validation"
+)
+
+const usage = `Lint, render and diff Rule manifests.
+
+Usage:
+  kubectl rules [flags] lint FILE...    check the Rules as the operator does
+  kubectl rules [flags] render FILE...  print the Prometheus rule file of the Rules
+  kubectl rules [flags] diff FILE...    diff the output ConfigMaps of the cluster
+                                        with the ones rendered once FILE applied
+
+FILE may be - to read the standard input. The diff command compares the rule
+files of the output of the operator and of each PrometheusTarget, rendered
+with the operator configuration given by -config. It runs
+KUBECTL_EXTERNAL_DIFF when set, "diff -u -N" otherwise.
+
+Flags:
+`
+
+// errProblems is returned when Rules have problems, already reported
+var errProblems = errors.New("rules have problems")
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(monitoringv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(monitoringv1beta1.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
+}
+
+func main() {
+	var namespace, configFile string
+	flag.StringVar(&namespace, "namespace", "default", "The namespace of Rules without one.")
+	flag.StringVar(&configFile, "config", "",
+		"The operator configuration file the diff command renders with, the defaults when omitted.")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	commands := map[string]func([]*monitoringv1alpha1.Rule) error{
+		"lint":   func(rules []*monitoringv1alpha1.Rule) error { return lint(os.Stdout, rules) },
+		"render": func(rules []*monitoringv1alpha1.Rule) error { return renderValid(os.Stdout, rules) },
+		"diff": func(rules []*monitoringv1alpha1.Rule) error {
+			return diff(context.Background(), configFile, rules)
+		},
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rules, err := readRules(flag.Args()[1:], namespace)
+	if err == nil {
+		err = command(rules)
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		os.Exit(exitErr.ExitCode())
+	case errors.Is(err, errProblems):
+		os.Exit(1)
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// readRules reads the Rules of the YAML or JSON manifests at paths, of any
+// served version, ignoring other kinds. Rules without namespace are set in
+// namespace.
+func readRules(paths []string, namespace string) ([]*monitoringv1alpha1.Rule, error) {
+	var rules []*monitoringv1alpha1.Rule
+	for _, path := range paths {
+		if path == "-" {
+			read, err := decodeRules(os.Stdin, path, namespace)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, read...)
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		read, err := decodeRules(f, path, namespace)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, read...)
+	}
+	return rules, nil
+}
+
+// decodeRules decodes the Rules of the manifests read from in, converted to
+// v1alpha1. Rules without namespace are set in namespace.
+func decodeRules(in io.Reader, path, namespace string) ([]*monitoringv1alpha1.Rule, error) {
+	var rules []*monitoringv1alpha1.Rule
+	decoder := utilyaml.NewYAMLOrJSONDecoder(in, 4096)
+	for {
+		var obj unstructured.Unstructured
+		if err := decoder.Decode(&obj.Object); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read %v: %w", path, err)
+		}
+		if obj.Object == nil {
+			continue
+		}
+		var rule monitoringv1alpha1.Rule
+		var err error
+		switch obj.GroupVersionKind() {
+		case monitoringv1alpha1.GroupVersion.WithKind("Rule"):
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &rule)
+		case monitoringv1beta1.GroupVersion.WithKind("Rule"):
+			var hub monitoringv1beta1.Rule
+			if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &hub); err == nil {
+				err = rule.ConvertFrom(&hub)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read Rule %v of %v: %w", obj.GetName(), path, err)
+		}
+		if rule.Namespace == "" {
+			rule.Namespace = namespace
+		}
+		rules = append(rules, &rule)
+	}
+	return rules, nil
+}
+
+// problems returns the problems of each Rule of rules by namespace/name: the
+// validation problems and the conflicts of the groups merged between rules
+func problems(rules []*monitoringv1alpha1.Rule) map[types.NamespacedName][]string {
+	problems := make(map[types.NamespacedName][]string)
+	for _, rule := range rules {
+		key := types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}
+		problems[key] = append(problems[key], validation.Validate(rule)...)
+	}
+	for _, group := range merge.Groups(rules...) {
+		if group.Conflict == "" {
+			continue
+		}
+		for _, c := range group.Contributions {
+			problems[c.Rule] = append(problems[c.Rule],
+				fmt.Sprintf("merged group %q: %v", group.Name, group.Conflict))
+		}
+	}
+	return problems
+}
+
+// lint writes the problems of rules to out, it returns errProblems when
+// there are any
+func lint(out io.Writer, rules []*monitoringv1alpha1.Rule) error {
+	found := problems(rules)
+	var err error
+	for _, rule := range rules {
+		key := types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}
+		for _, problem := range found[key] {
+			fmt.Fprintf(out, "%v: %v\n", key, problem)
+			err = errProblems
+		}
+	}
+	return err
+}
+
+// valid returns the Rules without problems, reporting the others to report
+func valid(report io.Writer, rules []*monitoringv1alpha1.Rule) ([]*monitoringv1alpha1.Rule, error) {
+	if err := lint(report, rules); err == nil {
+		return rules, nil
+	}
+	found := problems(rules)
+	var result []*monitoringv1alpha1.Rule
+	for _, rule := range rules {
+		if len(found[types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}]) == 0 {
+			result = append(result, rule)
+		}
+	}
+	return result, errProblems
+}
+
+// renderValid writes to out the rule file of the Rules without problems, it
+// returns errProblems when Rules were left out
+func renderValid(out io.Writer, rules []*monitoringv1alpha1.Rule) error {
+	rules, invalid := valid(os.Stderr, rules)
+	file, err := render.Render(rules...)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(file); err != nil {
+		return err
+	}
+	return invalid
+}
+
+// diff compares the rule files of the output ConfigMaps of the cluster with
+// the ones the operator configured by configFile renders once rules are
+// applied
+func diff(ctx context.Context, configFile string, rules []*monitoringv1alpha1.Rule) error {
+	settings, err := loadSettings(configFile)
+	if err != nil {
+		return err
+	}
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	// problems of the applied Rules leave them out of the rendered files
+	_ = lint(os.Stderr, rules)
+	dir, err := ioutil.TempDir("", "kubectl-rules")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	live, merged := filepath.Join(dir, "live"), filepath.Join(dir, "merged")
+	if err := writeOutputs(ctx, c, settings, rules, live, merged); err != nil {
+		return err
+	}
+
+	command := []string{"diff", "-u", "-N"}
+	if external := os.Getenv("KUBECTL_EXTERNAL_DIFF"); external != "" {
+		command = strings.Fields(external)
+	}
+	cmd := exec.Command(command[0], append(command[1:], live, merged)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// loadSettings returns the rules settings of the operator configuration file
+// at path, the default ones when path is empty. Prometheus and Alertmanager
+// are not queried, their configuration is ignored.
+func loadSettings(path string) (*operatorconfig.Settings, error) {
+	var cfg configv1alpha1.OperatorConfig
+	if path != "" {
+		if err := operatorconfig.Load(path, scheme, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Rules.Prometheus = configv1alpha1.PrometheusConfig{}
+	cfg.Rules.Alertmanager = configv1alpha1.AlertmanagerConfig{}
+	cfg.Rules.SelfMonitoring = configv1alpha1.SelfMonitoringConfig{}
+	return operatorconfig.New(&cfg.Rules, nil)
+}
+
+// writeOutputs writes to the live directory the rule files of the
+// ConfigMaps of the default output of settings and of the outputs of the
+// PrometheusTargets, as read with c, and to the merged directory the ones
+// rendered from the Rules read with c once rules are applied. Each file is
+// named after its ConfigMap, as namespace.name.
+func writeOutputs(ctx context.Context, c client.Reader, settings *operatorconfig.Settings,
+	rules []*monitoringv1alpha1.Rule, live, merged string) error {
+	for _, dir := range []string{live, merged} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	applied := &appliedReader{Reader: c, rules: rules}
+
+	type target struct {
+		namespace      string
+		config         *monitoringv1alpha1.ConfigMapOutput
+		externalLabels map[string]string
+		selected       func() ([]*monitoringv1alpha1.Rule, error)
+	}
+	var targets []target
+	if settings.Output != nil {
+		targets = append(targets, target{
+			namespace: settings.Output.Namespace,
+			config:    &settings.Output.ConfigMap,
+			selected:  func() ([]*monitoringv1alpha1.Rule, error) { return controllers.HandledRules(ctx, applied, settings) },
+		})
+	}
+	var list monitoringv1alpha1.PrometheusTargetList
+	if err := c.List(ctx, &list); err != nil {
+		return fmt.Errorf("unable to list prometheustargets: %w", err)
+	}
+	for i := range list.Items {
+		item := &list.Items[i]
+		targets = append(targets, target{
+			namespace:      item.Namespace,
+			config:         &item.Spec.Output.ConfigMap,
+			externalLabels: item.Spec.ExternalLabels,
+			selected: func() ([]*monitoringv1alpha1.Rule, error) {
+				return controllers.SelectedRules(ctx, applied, settings, item)
+			},
+		})
+	}
+
+	for _, t := range targets {
+		for shard := int32(0); shard < output.Shards(t.config); shard++ {
+			key := types.NamespacedName{Namespace: t.namespace, Name: output.ConfigMapName(t.config, shard)}
+			var configMap corev1.ConfigMap
+			if err := c.Get(ctx, key, &configMap); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("unable to get configmap %v: %w", key, err)
+			}
+			if err := writeFile(live, key, []byte(configMap.Data[output.Key])); err != nil {
+				return err
+			}
+		}
+		selected, err := t.selected()
+		if err != nil {
+			return err
+		}
+		files, err := controllers.RenderFiles(settings, t.config, t.externalLabels, selected)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := writeFile(merged, types.NamespacedName{Namespace: t.namespace, Name: file.Name}, file.Data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFile writes data to the file of dir named after the ConfigMap key,
+// leaving out empty files so that diff reports them as absent
+func writeFile(dir string, key types.NamespacedName, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return ioutil.WriteFile(filepath.Join(dir, key.Namespace+"."+key.Name), data, 0600)
+}
+
+// appliedReader reads the Rules of Reader as if rules were applied, replacing
+// the Rules of the same namespace and name
+type appliedReader struct {
+	client.Reader
+	rules []*monitoringv1alpha1.Rule
+}
+
+// List lists the objects of Reader, with rules applied to the lists of Rules
+func (r *appliedReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := r.Reader.List(ctx, list, opts...); err != nil {
+		return err
+	}
+	rules, ok := list.(*monitoringv1alpha1.RuleList)
+	if !ok {
+		return nil
+	}
+	options := (&client.ListOptions{}).ApplyOptions(opts)
+	applied := make(map[types.NamespacedName]*monitoringv1alpha1.Rule, len(r.rules))
+	for _, rule := range r.rules {
+		applied[types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}] = rule
+	}
+	items := rules.Items[:0]
+	for _, item := range rules.Items {
+		if _, ok := applied[types.NamespacedName{Namespace: item.Namespace, Name: item.Name}]; !ok {
+			items = append(items, item)
+		}
+	}
+	for _, rule := range r.rules {
+		if options.Namespace != "" && rule.Namespace != options.Namespace ||
+			options.LabelSelector != nil && !options.LabelSelector.Matches(labels.Set(rule.Labels)) {
+			continue
+		}
+		items = append(items, *rule)
+	}
+	rules.Items = items
+	return nil
+}
+
+// Get gets the object of Reader, a namespace missing from the cluster being
+// created by applying the Rules
+func (r *appliedReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	err := r.Reader.Get(ctx, key, obj)
+	if _, ok := obj.(*corev1.Namespace); ok && apierrors.IsNotFound(err) {
+		for _, rule := range r.rules {
+			if rule.Namespace == key.Name {
+				obj.SetName(key.Name)
+				return nil
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+)
+
+func newRule(namespace, name string, labels map[string]string, expr string) *monitoringv1alpha1.Rule {
+	return &monitoringv1alpha1.Rule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Spec: monitoringv1alpha1.RuleSpec{Groups: []monitoringv1alpha1.RuleGroup{{
+			Name:  name,
+			Rules: []monitoringv1alpha1.RuleDefinition{{Record: "job:up:sum", Expr: expr}},
+		}}},
+	}
+}
+
+func TestReadRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.yaml")
+	manifests := `apiVersion: monitoring.cyrilix.fr/v1alpha1
+kind: Rule
+metadata:
+  name: alpha
+spec:
+  groups:
+  - name: alpha
+    rules:
+    - record: job:up:sum
+      expr: sum by (job) (up)
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+---
+apiVersion: monitoring.cyrilix.fr/v1beta1
+kind: Rule
+metadata:
+  name: beta
+  namespace: team-b
+spec:
+  groups:
+  - name: beta
+    queryOffset: 1m
+    rules:
+    - alert: Down
+      expr: up == 0
+`
+	if err := ioutil.WriteFile(path, []byte(manifests), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := readRules([]string{path}, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, rule := range rules {
+		names = append(names, rule.Namespace+"/"+rule.Name)
+	}
+	if expected := []string{"team-a/alpha", "team-b/beta"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("readRules() = %q, expected %q", names, expected)
+	}
+	if group := rules[1].Spec.Groups[0]; group.QueryOffset != "1m" || group.Rules[0].Alert != "Down" {
+		t.Errorf("v1beta1 Rule read as %+v", group)
+	}
+}
+
+func TestLint(t *testing.T) {
+	valid := newRule("team-a", "valid", nil, "sum by (job) (up)")
+	invalid := newRule("team-a", "invalid", nil, "sum by (job) (up")
+
+	var out bytes.Buffer
+	if err := lint(&out, []*monitoringv1alpha1.Rule{valid}); err != nil || out.Len() > 0 {
+		t.Errorf("lint() of a valid Rule = %v, reported %q", err, out.String())
+	}
+	out.Reset()
+	if err := lint(&out, []*monitoringv1alpha1.Rule{valid, invalid}); !errors.Is(err, errProblems) {
+		t.Errorf("lint() = %v, expected %v", err, errProblems)
+	}
+	if report := out.String(); !strings.HasPrefix(report, "team-a/invalid: ") || strings.Contains(report, "team-a/valid") {
+		t.Errorf("lint() reported %q, expected the problems of team-a/invalid", report)
+	}
+}
+
+func TestRenderValid(t *testing.T) {
+	valid := newRule("team-a", "valid", nil, "sum by (job) (up)")
+	invalid := newRule("team-a", "invalid", nil, "sum by (job) (up")
+
+	var out bytes.Buffer
+	if err := renderValid(&out, []*monitoringv1alpha1.Rule{valid, invalid}); !errors.Is(err, errProblems) {
+		t.Errorf("renderValid() = %v, expected %v", err, errProblems)
+	}
+	file, err := render.Unmarshal(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []string
+	for _, group := range file.Groups {
+		groups = append(groups, group.Name)
+	}
+	expected := render.Groups(valid)
+	if len(groups) != 1 || groups[0] != expected[0].Name {
+		t.Errorf("renderValid() rendered groups %q, expected %q", groups, expected[0].Name)
+	}
+}
+
+func TestWriteOutputs(t *testing.T) {
+	target := &monitoringv1alpha1.PrometheusTarget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "main"},
+		Spec: monitoringv1alpha1.PrometheusTargetSpec{
+			Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"target": "main"}},
+			Output:         monitoringv1alpha1.Output{ConfigMap: monitoringv1alpha1.ConfigMapOutput{Name: "main-rules"}},
+			ExternalLabels: map[string]string{"cluster": "east"},
+		},
+	}
+	selected := map[string]string{"target": "main"}
+	live := newRule("team-a", "api", selected, "sum by (job) (up)")
+	liveFile, err := output.Files(&target.Spec.Output.ConfigMap, target.Spec.ExternalLabels, live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		target,
+		live,
+		newRule("team-b", "other", nil, "count(up)"),
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "main-rules-0"},
+			Data:       map[string]string{output.Key: string(liveFile[0].Data)},
+		},
+	).Build()
+	settings, err := loadSettings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "kubectl-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	applied := []*monitoringv1alpha1.Rule{
+		newRule("team-a", "api", selected, "sum by (job) (up == 1)"),
+		newRule("team-c", "new", selected, "count by (job) (up)"),
+	}
+	if err := writeOutputs(context.Background(), c, settings, applied,
+		filepath.Join(dir, "live"), filepath.Join(dir, "merged")); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(path string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if data := read("live/monitoring.main-rules-0"); data != string(liveFile[0].Data) {
+		t.Errorf("live file = %q, expected the ConfigMap content %q", data, liveFile[0].Data)
+	}
+	merged, err := render.Unmarshal([]byte(read("merged/monitoring.main-rules-0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exprs []string
+	for _, group := range merged.Groups {
+		for _, rule := range group.Rules {
+			if rule.Labels["cluster"] != "east" {
+				t.Errorf("rule %v of group %v misses the external labels: %v", rule.Record, group.Name, rule.Labels)
+			}
+			exprs = append(exprs, rule.Expr)
+		}
+	}
+	sort.Strings(exprs)
+	if expected := []string{"count by (job) (up)", "sum by (job) (up == 1)"}; !reflect.DeepEqual(exprs, expected) {
+		t.Errorf("merged file defines %q, expected %q", exprs, expected)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/prometheus"
+)
+
+// checkCardinality measures the series produced by the recording rules of
+// rule, once per generation, and sets its CardinalityBudgetExceeded condition.
+func (r *RuleReconciler) checkCardinality(ctx context.Context, settings *operatorconfig.Settings, rule *monitoringv1alpha1.Rule, previous *monitoringv1alpha1.RuleStatus) error {
+	if c := rule.Status.Cardinality; c == nil || c.ObservedGeneration != rule.Generation {
+		cardinality, err := r.measureCardinality(ctx, settings, rule)
+		if err != nil {
+			return err
+		}
+		rule.Status.Cardinality = cardinality
+	}
+
+	budget := settings.CardinalityBudget.For(rule.Namespace)
+	exceeded := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionCardinalityBudgetExceeded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "WithinBudget",
+		Message:            fmt.Sprintf("namespace has a budget of %v series", budget),
+	}
+	if budget == 0 {
+		exceeded.Reason = "NoBudget"
+		exceeded.Message = "namespace has no cardinality budget"
+		meta.SetStatusCondition(&rule.Status.Conditions, exceeded)
+		return nil
+	}
+
+	series, err := r.namespaceCardinality(ctx, rule)
+	if err != nil {
+		return err
+	}
+	if series > budget {
+		exceeded.Status = metav1.ConditionTrue
+		exceeded.Reason = "BudgetExceeded"
+		exceeded.Message = fmt.Sprintf("recording rules of namespace %v produce %v series with this Rule, exceeding the budget of %v series",
+			rule.Namespace, series, budget)
+		if !meta.IsStatusConditionTrue(previous.Conditions, monitoringv1alpha1.ConditionCardinalityBudgetExceeded) {
+			r.Recorder.Event(rule, corev1.EventTypeWarning, exceeded.Reason, exceeded.Message)
+		}
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, exceeded)
+	return nil
+}
+
+// measureCardinality counts the series currently returned by the expression
+// of each recording rule of rule
+func (r *RuleReconciler) measureCardinality(ctx context.Context, settings *operatorconfig.Settings, rule *monitoringv1alpha1.Rule) (*monitoringv1alpha1.CardinalityStatus, error) {
+	now := time.Now()
+	cardinality := &monitoringv1alpha1.CardinalityStatus{
+		ObservedGeneration: rule.Generation,
+		Time:               metav1.NewTime(now),
+	}
+	for _, group := range rule.Spec.Groups {
+		for _, def := range group.Rules {
+			if def.Record == "" {
+				continue
+			}
+			record := monitoringv1alpha1.RecordCardinality{Group: group.Name, Record: def.Record}
+			samples, err := settings.Prometheus.Query(ctx, fmt.Sprintf("count(%v)", def.Expr), now)
+			var apiErr *prometheus.APIError
+			switch {
+			case errors.As(err, &apiErr):
+				record.Error = apiErr.Message
+			case err != nil:
+				return nil, fmt.Errorf("unable to evaluate record %v: %w", def.Record, err)
+			case len(samples) > 0:
+				record.Series = int(samples[0].Point.Value)
+			}
+			cardinality.Series += record.Series
+			cardinality.Records = append(cardinality.Records, record)
+		}
+	}
+	return cardinality, nil
+}
+
+// namespaceCardinality returns the number of series produced by the recording
+// rules of rule and of the older Rules of its namespace, so that the newest
+// Rules are the ones exceeding the budget.
+func (r *RuleReconciler) namespaceCardinality(ctx context.Context, rule *monitoringv1alpha1.Rule) (int, error) {
+	var rules monitoringv1alpha1.RuleList
+	if err := r.List(ctx, &rules, client.InNamespace(rule.Namespace)); err != nil {
+		return 0, fmt.Errorf("unable to list rules of namespace %v: %w", rule.Namespace, err)
+	}
+
+	series := rule.Status.Cardinality.Series
+	for i := range rules.Items {
+		other := &rules.Items[i]
+		if other.UID == rule.UID || !olderThan(other, rule) {
+			continue
+		}
+		if other.Status.Cardinality != nil {
+			series += other.Status.Cardinality.Series
+		}
+	}
+	return series, nil
+}
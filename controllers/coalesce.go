@@ -0,0 +1,171 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// coalesceDelay is how long the requests of related objects wait in the
+// queue, so that a burst of changes, like the apply of many Rules, reconciles
+// each related Rule once instead of once per change
+const coalesceDelay = time.Second
+
+const (
+	// debounceQuiet is how long the requests of the outputs wait for the
+	// changes of a burst to stop, so that the burst is rendered into each
+	// shard with a single write, and a single reload of Prometheus
+	debounceQuiet = 2 * time.Second
+
+	// debounceMaxWait bounds the wait of the requests of the outputs during a
+	// continuous stream of changes
+	debounceMaxWait = 10 * time.Second
+)
+
+// coalescingMapHandler enqueues the requests returned by toRequests after a
+// delay. The queue keeps a single pending request per object, requests added
+// again before the delay elapses are coalesced with it.
+type coalescingMapHandler struct {
+	delay      time.Duration
+	toRequests handler.MapFunc
+}
+
+// enqueueCoalescedRequestsFromMapFunc returns an EventHandler enqueuing the
+// requests returned by fn after coalesceDelay
+func enqueueCoalescedRequestsFromMapFunc(fn handler.MapFunc) handler.EventHandler {
+	return &coalescingMapHandler{delay: coalesceDelay, toRequests: fn}
+}
+
+// Create implements EventHandler
+func (h *coalescingMapHandler) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.mapAndEnqueue(q, evt.Object)
+}
+
+// Update implements EventHandler
+func (h *coalescingMapHandler) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.mapAndEnqueue(q, evt.ObjectOld)
+	h.mapAndEnqueue(q, evt.ObjectNew)
+}
+
+// Delete implements EventHandler
+func (h *coalescingMapHandler) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.mapAndEnqueue(q, evt.Object)
+}
+
+// Generic implements EventHandler
+func (h *coalescingMapHandler) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.mapAndEnqueue(q, evt.Object)
+}
+
+func (h *coalescingMapHandler) mapAndEnqueue(q workqueue.RateLimitingInterface, obj client.Object) {
+	for _, req := range h.toRequests(obj) {
+		q.AddAfter(req, h.delay)
+	}
+}
+
+// debouncingMapHandler enqueues the requests returned by toRequests once no
+// event mapped to them for quiet, or maxWait after the first of them.
+// Unlike the delay of the queue, which is counted from the first event, the
+// wait is extended by each event of a burst.
+type debouncingMapHandler struct {
+	quiet, maxWait time.Duration
+	toRequests     handler.MapFunc
+	// clock starts the timers of the pending requests, stepped by the tests
+	clock clock.Clock
+
+	mu      sync.Mutex
+	pending map[reconcile.Request]*pendingRequest
+}
+
+// pendingRequest is a request waiting for the end of a burst
+type pendingRequest struct {
+	first time.Time
+	timer clock.Timer
+}
+
+// enqueueDebouncedRequestsFromMapFunc returns an EventHandler enqueuing the
+// requests returned by fn once their burst of events is over
+func enqueueDebouncedRequestsFromMapFunc(fn handler.MapFunc) handler.EventHandler {
+	return &debouncingMapHandler{
+		quiet:      debounceQuiet,
+		maxWait:    debounceMaxWait,
+		toRequests: fn,
+		clock:      clock.RealClock{},
+		pending:    map[reconcile.Request]*pendingRequest{},
+	}
+}
+
+// Create implements EventHandler
+func (h *debouncingMapHandler) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.mapAndEnqueue(q, evt.Object)
+}
+
+// Update implements EventHandler
+func (h *debouncingMapHandler) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.mapAndEnqueue(q, evt.ObjectOld)
+	h.mapAndEnqueue(q, evt.ObjectNew)
+}
+
+// Delete implements EventHandler
+func (h *debouncingMapHandler) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.mapAndEnqueue(q, evt.Object)
+}
+
+// Generic implements EventHandler
+func (h *debouncingMapHandler) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.mapAndEnqueue(q, evt.Object)
+}
+
+func (h *debouncingMapHandler) mapAndEnqueue(q workqueue.RateLimitingInterface, obj client.Object) {
+	requests := h.toRequests(obj)
+	now := h.clock.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, req := range requests {
+		if p, ok := h.pending[req]; ok && p.timer.Stop() {
+			delay := h.quiet
+			if deadline := p.first.Add(h.maxWait); now.Add(delay).After(deadline) {
+				delay = deadline.Sub(now)
+			}
+			p.timer.Reset(delay)
+			continue
+		}
+		// the timer of a previous burst may be firing, it enqueues the
+		// request without forgetting the new burst
+		p := &pendingRequest{first: now, timer: h.clock.NewTimer(h.quiet)}
+		req := req
+		// the timer is only stopped to be reset, it always fires
+		go func() {
+			<-p.timer.C()
+			h.mu.Lock()
+			if h.pending[req] == p {
+				delete(h.pending, req)
+			}
+			h.mu.Unlock()
+			q.Add(req)
+		}()
+		h.pending[req] = p
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDebouncingMapHandler(t *testing.T) {
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Name: "output"}}
+	h := enqueueDebouncedRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{request}
+	}).(*debouncingMapHandler)
+	fakeClock := clock.NewFakeClock(time.Now())
+	h.clock = fakeClock
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+	step := h.quiet / 2
+
+	// a burst shorter than maxWait is enqueued once, quiet after its end
+	for i := 0; i < 5; i++ {
+		h.Generic(event.GenericEvent{}, q)
+		fakeClock.Step(step)
+	}
+	fakeClock.Step(h.quiet - step - time.Nanosecond)
+	if q.Len() != 0 {
+		t.Fatalf("request enqueued before the end of the burst")
+	}
+	fakeClock.Step(time.Nanosecond)
+	if item, _ := q.Get(); item != request {
+		t.Fatalf("got %v, expected %v", item, request)
+	} else {
+		q.Done(item)
+	}
+
+	// a continuous stream of events is enqueued maxWait after its first event
+	for elapsed := time.Duration(0); elapsed < h.maxWait; elapsed += step {
+		if q.Len() != 0 {
+			t.Fatalf("request enqueued %v after the first event, expected %v", elapsed, h.maxWait)
+		}
+		h.Generic(event.GenericEvent{}, q)
+		fakeClock.Step(step)
+	}
+	if item, _ := q.Get(); item != request {
+		t.Fatalf("got %v, expected %v", item, request)
+	} else {
+		q.Done(item)
+	}
+}
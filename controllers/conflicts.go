@@ -0,0 +1,148 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+// ruleNamesField is the cache index listing the record and alert names
+// defined by a Rule, as returned by ruleNames
+const ruleNamesField = ".spec.groups.rules.names"
+
+const (
+	recordPrefix = "record/"
+	alertPrefix  = "alert/"
+)
+
+// definedNames returns the record and alert names defined by rule, keyed as
+// in ruleNames, with their description. Alerts sharing a name are common,
+// e.g. one per severity, so an alert name only conflicts when its static
+// labels are identical too.
+func definedNames(rule *monitoringv1alpha1.Rule) map[string]string {
+	names := make(map[string]string)
+	for _, group := range rule.Spec.Groups {
+		for _, r := range group.Rules {
+			if r.Record != "" {
+				names[recordPrefix+r.Record] = fmt.Sprintf("record %q", r.Record)
+			}
+			if r.Alert != "" {
+				labels := labelSet(r.Labels)
+				names[alertPrefix+r.Alert+labels] = fmt.Sprintf("alert %q with labels %v", r.Alert, labels)
+			}
+		}
+	}
+	return names
+}
+
+// labelSet formats labels sorted by name, as {name="value", ...}
+func labelSet(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, fmt.Sprintf("%v=%q", name, value))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// ruleNames returns the sorted record names and alert names with their
+// static labels defined by rule, prefixed by their kind so a record and an
+// alert may share a name.
+func ruleNames(rule *monitoringv1alpha1.Rule) []string {
+	set := definedNames(rule)
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// indexRuleNames is the IndexerFunc backing ruleNamesField
+func indexRuleNames(obj client.Object) []string {
+	rule, ok := obj.(*monitoringv1alpha1.Rule)
+	if !ok {
+		return nil
+	}
+	return ruleNames(rule)
+}
+
+// olderThan reports whether a has precedence over b: the first created Rule
+// owns a name, ties are broken on namespace/name.
+func olderThan(a, b *monitoringv1alpha1.Rule) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return types.NamespacedName{Namespace: a.Namespace, Name: a.Name}.String() <
+		types.NamespacedName{Namespace: b.Namespace, Name: b.Name}.String()
+}
+
+// checkConflicts sets the Conflict condition of rule
+func (r *RuleReconciler) checkConflicts(ctx context.Context, rule *monitoringv1alpha1.Rule) error {
+	conflicts, err := r.findConflicts(ctx, rule)
+	if err != nil {
+		return err
+	}
+	conflict := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionConflict,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "NoConflict",
+		Message:            "record names and labelled alert names are not defined by any other Rule",
+	}
+	if len(conflicts) > 0 {
+		log.FromContext(ctx).Info("rule conflicts with older rules", "conflicts", conflicts)
+		conflict.Status = metav1.ConditionTrue
+		conflict.Reason = "DuplicateName"
+		conflict.Message = strings.Join(conflicts, "; ")
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, conflict)
+	return nil
+}
+
+// findConflicts returns a description of every record name, or alert name
+// with the same labels, of rule already defined by an older Rule.
+func (r *RuleReconciler) findConflicts(ctx context.Context, rule *monitoringv1alpha1.Rule) ([]string, error) {
+	var conflicts []string
+	names := definedNames(rule)
+	for _, name := range ruleNames(rule) {
+		var rules monitoringv1alpha1.RuleList
+		if err := r.List(ctx, &rules, client.MatchingFields{ruleNamesField: name}); err != nil {
+			return nil, fmt.Errorf("unable to list rules defining %v: %w", name, err)
+		}
+		for i := range rules.Items {
+			other := &rules.Items[i]
+			if other.UID == rule.UID || !olderThan(other, rule) {
+				continue
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%v is already defined by %v/%v",
+				names[name], other.Namespace, other.Name))
+		}
+	}
+	return conflicts, nil
+}
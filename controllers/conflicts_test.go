@@ -0,0 +1,104 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+func TestRuleNames(t *testing.T) {
+	rule := newRule("default", "names",
+		recording("job:up:sum", "sum by (job) (up)"),
+		monitoringv1alpha1.RuleDefinition{Alert: "job:up:sum", Expr: "job:up:sum == 0"},
+		monitoringv1alpha1.RuleDefinition{Alert: "Down", Expr: "up == 0",
+			Labels: map[string]string{"severity": "page", "team": "api"}},
+		monitoringv1alpha1.RuleDefinition{Alert: "Down", Expr: "up == 0",
+			Labels: map[string]string{"team": "api", "severity": "page"}},
+		monitoringv1alpha1.RuleDefinition{Alert: "Down", Expr: "up == 0",
+			Labels: map[string]string{"severity": "ticket"}},
+	)
+	rule.Spec.Groups = append(rule.Spec.Groups, monitoringv1alpha1.RuleGroup{
+		Name:  "other",
+		Rules: []monitoringv1alpha1.RuleDefinition{recording("job:up:sum", "sum by (job) (up)")},
+	})
+
+	expected := []string{
+		`alert/Down{severity="page", team="api"}`,
+		`alert/Down{severity="ticket"}`,
+		`alert/job:up:sum{}`,
+		`record/job:up:sum`,
+	}
+	if names := ruleNames(rule); !reflect.DeepEqual(names, expected) {
+		t.Errorf("ruleNames() = %q, expected %q", names, expected)
+	}
+}
+
+func TestOlderThan(t *testing.T) {
+	now := time.Now()
+	created := func(namespace, name string, at time.Time) *monitoringv1alpha1.Rule {
+		rule := newRule(namespace, name)
+		rule.CreationTimestamp = metav1.NewTime(at)
+		return rule
+	}
+	tests := []struct {
+		name     string
+		a, b     *monitoringv1alpha1.Rule
+		expected bool
+	}{
+		{
+			name:     "created first",
+			a:        created("b", "b", now),
+			b:        created("a", "a", now.Add(time.Second)),
+			expected: true,
+		},
+		{
+			name: "created last",
+			a:    created("a", "a", now.Add(time.Second)),
+			b:    created("b", "b", now),
+		},
+		{
+			name:     "same time, namespace first",
+			a:        created("a", "z", now),
+			b:        created("b", "a", now),
+			expected: true,
+		},
+		{
+			name:     "same time and namespace, name first",
+			a:        created("a", "a", now),
+			b:        created("a", "b", now),
+			expected: true,
+		},
+		{
+			name: "itself",
+			a:    created("a", "a", now),
+			b:    created("a", "a", now),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if older := olderThan(test.a, test.b); older != test.expected {
+				t.Errorf("olderThan() = %v, expected %v", older, test.expected)
+			}
+		})
+	}
+}
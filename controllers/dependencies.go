@@ -0,0 +1,258 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/promql"
+)
+
+// ruleMetricsField is the cache index listing the metric names used by the
+// expressions of a Rule
+const ruleMetricsField = ".spec.groups.rules.expr.metrics"
+
+// recordNames returns the sorted, deduplicated record names defined by rule
+func recordNames(rule *monitoringv1alpha1.Rule) []string {
+	var names []string
+	for _, name := range ruleNames(rule) {
+		if strings.HasPrefix(name, recordPrefix) {
+			names = append(names, strings.TrimPrefix(name, recordPrefix))
+		}
+	}
+	return names
+}
+
+// usedMetrics returns the sorted, deduplicated metric names used by the
+// expressions of rule. Expressions that can't be parsed are ignored.
+func usedMetrics(rule *monitoringv1alpha1.Rule) []string {
+	set := make(map[string]struct{})
+	for _, group := range rule.Spec.Groups {
+		for _, r := range group.Rules {
+			metrics, err := promql.MetricNames(r.Expr)
+			if err != nil {
+				continue
+			}
+			for _, m := range metrics {
+				set[m] = struct{}{}
+			}
+		}
+	}
+	metrics := make([]string, 0, len(set))
+	for m := range set {
+		metrics = append(metrics, m)
+	}
+	sort.Strings(metrics)
+	return metrics
+}
+
+// indexRuleMetrics is the IndexerFunc backing ruleMetricsField
+func indexRuleMetrics(obj client.Object) []string {
+	rule, ok := obj.(*monitoringv1alpha1.Rule)
+	if !ok {
+		return nil
+	}
+	return usedMetrics(rule)
+}
+
+// checkDependencies fills the dependencies of rule and sets its
+// MissingDependency and DependencyCycle conditions. A used metric is only
+// known to be recorded once resolved against the record names indexed from
+// the Rules: it goes missing when its recording rules are deleted, and stays
+// missing until a Rule records it again. Metrics never recorded by a Rule
+// are exported by targets, the StaleMetrics condition checks them against
+// Prometheus. A Warning event is emitted when a dependency goes missing
+// compared to the previous status.
+func (r *RuleReconciler) checkDependencies(ctx context.Context, rule *monitoringv1alpha1.Rule, previous *monitoringv1alpha1.RuleStatus) error {
+	logger := log.FromContext(ctx)
+	for _, group := range rule.Spec.Groups {
+		for _, def := range group.Rules {
+			if _, err := promql.MetricNames(def.Expr); err != nil {
+				logger.Info("unable to parse expression, its dependencies are ignored",
+					"group", group.Name, "expr", def.Expr, "error", err.Error())
+			}
+		}
+	}
+
+	dependencies, undefined, err := r.resolveDependencies(ctx, rule)
+	if err != nil {
+		return err
+	}
+	rule.Status.Dependencies = dependencies
+
+	recorded := make(map[string]struct{})
+	for _, dependency := range previous.Dependencies {
+		recorded[dependency.Record] = struct{}{}
+	}
+	for _, record := range previous.MissingRecords {
+		recorded[record] = struct{}{}
+	}
+	var missing []string
+	for _, metric := range undefined {
+		if _, ok := recorded[metric]; ok {
+			missing = append(missing, metric)
+		}
+	}
+	rule.Status.MissingRecords = missing
+
+	missingDependency := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionMissingDependency,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "DependenciesResolved",
+		Message:            "all recorded metrics used by expressions are still defined",
+	}
+	if len(missing) > 0 {
+		missingDependency.Status = metav1.ConditionTrue
+		missingDependency.Reason = "UndefinedRecord"
+		missingDependency.Message = fmt.Sprintf("recording rules deleted for metrics: %v",
+			strings.Join(missing, ", "))
+		if !meta.IsStatusConditionTrue(previous.Conditions, monitoringv1alpha1.ConditionMissingDependency) {
+			r.Recorder.Event(rule, corev1.EventTypeWarning, missingDependency.Reason, missingDependency.Message)
+		}
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, missingDependency)
+
+	dependencyCycle := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionDependencyCycle,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "NoCycle",
+		Message:            "recording rules of each group don't depend on each other",
+	}
+	if cycles := dependencyCycles(rule); len(cycles) > 0 {
+		dependencyCycle.Status = metav1.ConditionTrue
+		dependencyCycle.Reason = "CyclicDependency"
+		dependencyCycle.Message = strings.Join(cycles, "; ")
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, dependencyCycle)
+	return nil
+}
+
+// resolveDependencies returns the recording rules of other Rules used by the
+// expressions of rule, and the used metrics that no Rule records.
+func (r *RuleReconciler) resolveDependencies(ctx context.Context, rule *monitoringv1alpha1.Rule) ([]monitoringv1alpha1.RecordReference, []string, error) {
+	own := make(map[string]struct{})
+	for _, name := range recordNames(rule) {
+		own[name] = struct{}{}
+	}
+
+	var dependencies []monitoringv1alpha1.RecordReference
+	var undefined []string
+	for _, metric := range usedMetrics(rule) {
+		if _, ok := own[metric]; ok {
+			continue
+		}
+		var rules monitoringv1alpha1.RuleList
+		if err := r.List(ctx, &rules, client.MatchingFields{ruleNamesField: recordPrefix + metric}); err != nil {
+			return nil, nil, fmt.Errorf("unable to list rules recording %v: %w", metric, err)
+		}
+		if len(rules.Items) == 0 {
+			undefined = append(undefined, metric)
+			continue
+		}
+		sort.Slice(rules.Items, func(i, j int) bool { return olderThan(&rules.Items[i], &rules.Items[j]) })
+		for _, other := range rules.Items {
+			dependencies = append(dependencies, monitoringv1alpha1.RecordReference{
+				Record:    metric,
+				Namespace: other.Namespace,
+				Name:      other.Name,
+			})
+		}
+	}
+	return dependencies, undefined, nil
+}
+
+// dependencyCycles returns a description of every cycle between the
+// recording rules of a same group of rule.
+func dependencyCycles(rule *monitoringv1alpha1.Rule) []string {
+	var cycles []string
+	for _, group := range rule.Spec.Groups {
+		records := make(map[string]struct{})
+		for _, def := range group.Rules {
+			if def.Record != "" {
+				records[def.Record] = struct{}{}
+			}
+		}
+
+		edges := make(map[string][]string)
+		for _, def := range group.Rules {
+			if def.Record == "" {
+				continue
+			}
+			metrics, err := promql.MetricNames(def.Expr)
+			if err != nil {
+				continue
+			}
+			for _, m := range metrics {
+				if _, ok := records[m]; ok {
+					edges[def.Record] = append(edges[def.Record], m)
+				}
+			}
+		}
+
+		nodes := make([]string, 0, len(records))
+		for record := range records {
+			nodes = append(nodes, record)
+		}
+		sort.Strings(nodes)
+
+		const (
+			unvisited = iota
+			visiting
+			visited
+		)
+		state := make(map[string]int)
+		var path []string
+		var visit func(string)
+		visit = func(node string) {
+			state[node] = visiting
+			path = append(path, node)
+			for _, next := range edges[node] {
+				switch state[next] {
+				case visiting:
+					start := len(path) - 1
+					for path[start] != next {
+						start--
+					}
+					cycle := append(append([]string{}, path[start:]...), next)
+					cycles = append(cycles, fmt.Sprintf("group %q: %v", group.Name, strings.Join(cycle, " -> ")))
+				case unvisited:
+					visit(next)
+				}
+			}
+			path = path[:len(path)-1]
+			state[node] = visited
+		}
+		for _, node := range nodes {
+			if state[node] == unvisited {
+				visit(node)
+			}
+		}
+	}
+	return cycles
+}
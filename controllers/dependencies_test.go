@@ -0,0 +1,71 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+func TestDependencyCycles(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions []monitoringv1alpha1.RuleDefinition
+		expected    []string
+	}{
+		{
+			name: "chain",
+			definitions: []monitoringv1alpha1.RuleDefinition{
+				recording("job:up:sum", "sum by (job) (up)"),
+				recording("job:up:max", "max(job:up:sum)"),
+			},
+		},
+		{
+			name: "self reference",
+			definitions: []monitoringv1alpha1.RuleDefinition{
+				recording("job:up:sum", "sum(job:up:sum)"),
+			},
+			expected: []string{`group "self reference": job:up:sum -> job:up:sum`},
+		},
+		{
+			name: "cycle",
+			definitions: []monitoringv1alpha1.RuleDefinition{
+				recording("a:up:sum", "sum(c:up:sum)"),
+				recording("b:up:sum", "sum(a:up:sum)"),
+				recording("c:up:sum", "sum(b:up:sum) + up"),
+				{Alert: "Down", Expr: "a:up:sum == 0"},
+			},
+			expected: []string{`group "cycle": a:up:sum -> c:up:sum -> b:up:sum -> a:up:sum`},
+		},
+		{
+			name: "invalid expression",
+			definitions: []monitoringv1alpha1.RuleDefinition{
+				recording("a:up:sum", `sum(a:up:sum{job="api")`),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cycles := dependencyCycles(newRule("default", test.name, test.definitions...))
+			if !reflect.DeepEqual(cycles, test.expected) {
+				t.Errorf("dependencyCycles() = %q, expected %q", cycles, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/prometheus"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/promql"
+)
+
+// maxQueryPoints is the maximum number of points per series Prometheus accepts
+// to return for a range query
+const maxQueryPoints = 11000
+
+// dryRun evaluates the alerts of rule over the window requested by the
+// dry-run annotation and stores the results in its status. Alerts are only
+// evaluated again when the Rule or the window changes.
+func (r *RuleReconciler) dryRun(ctx context.Context, settings *operatorconfig.Settings,
+	rule *monitoringv1alpha1.Rule, previous *monitoringv1alpha1.RuleStatus) error {
+	value, ok := rule.Annotations[monitoringv1alpha1.DryRunAnnotation]
+	if !ok {
+		rule.Status.DryRun = nil
+		meta.RemoveStatusCondition(&rule.Status.Conditions, monitoringv1alpha1.ConditionDryRun)
+		return nil
+	}
+	condition := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionDryRun,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: rule.Generation,
+		Reason:             "Evaluated",
+		Message:            fmt.Sprintf("alerts evaluated over the past %v", value),
+	}
+	window, err := promql.ParseDuration(value)
+	if err == nil && window <= 0 {
+		err = fmt.Errorf("window must be positive")
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InvalidDryRun"
+		condition.Message = fmt.Sprintf("invalid %v annotation %q: %v", monitoringv1alpha1.DryRunAnnotation, value, err)
+		if !meta.IsStatusConditionFalse(previous.Conditions, monitoringv1alpha1.ConditionDryRun) {
+			r.Recorder.Event(rule, corev1.EventTypeWarning, condition.Reason, condition.Message)
+		}
+		rule.Status.DryRun = nil
+		meta.SetStatusCondition(&rule.Status.Conditions, condition)
+		return nil
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, condition)
+	if previous := rule.Status.DryRun; previous != nil &&
+		previous.ObservedGeneration == rule.Generation && previous.Window.Duration == window {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("evaluating alerts over past data", "window", window)
+	end := time.Now()
+	status := &monitoringv1alpha1.DryRunStatus{
+		ObservedGeneration: rule.Generation,
+		Window:             metav1.Duration{Duration: window},
+		Time:               metav1.NewTime(end),
+	}
+	for _, group := range rule.Spec.Groups {
+		step := dryRunStep(group.Interval, settings.DefaultInterval, window)
+		for _, def := range group.Rules {
+			if def.Alert == "" {
+				continue
+			}
+			result := monitoringv1alpha1.AlertDryRun{Group: group.Name, Alert: def.Alert}
+
+			var pending time.Duration
+			if def.For != "" {
+				if pending, err = promql.ParseDuration(def.For); err != nil {
+					result.Error = err.Error()
+					status.Alerts = append(status.Alerts, result)
+					continue
+				}
+			}
+
+			series, err := settings.Prometheus.QueryRange(ctx, def.Expr, end.Add(-window), end, step)
+			var apiErr *prometheus.APIError
+			switch {
+			case errors.As(err, &apiErr):
+				result.Error = apiErr.Message
+			case err != nil:
+				return fmt.Errorf("unable to evaluate alert %v: %w", def.Alert, err)
+			default:
+				var firing time.Duration
+				result.Series = len(series)
+				result.Firings, firing = estimateFiring(series, step, pending)
+				result.FiringDuration = metav1.Duration{Duration: firing}
+			}
+			status.Alerts = append(status.Alerts, result)
+		}
+	}
+	rule.Status.DryRun = status
+	return nil
+}
+
+// dryRunStep returns the resolution at which alerts of a group are evaluated:
+// the group interval or defaultInterval, bounded so that the window doesn't exceed the maximum
+// number of points of a range query.
+func dryRunStep(interval string, defaultInterval, window time.Duration) time.Duration {
+	step := defaultInterval
+	if interval != "" {
+		if d, err := promql.ParseDuration(interval); err == nil && d > 0 {
+			step = d
+		}
+	}
+	if lowest := window / maxQueryPoints; step < lowest {
+		step = lowest
+	}
+	return step
+}
+
+// estimateFiring returns how many times and for how long alerts would have
+// fired given the series returned by their expression evaluated at each step:
+// an alert fires once its expression returned a series for the pending
+// duration and until the series disappears.
+func estimateFiring(series []prometheus.Series, step, pending time.Duration) (int, time.Duration) {
+	var firings int
+	var firing time.Duration
+	for _, s := range series {
+		for i := 0; i < len(s.Points); {
+			start := s.Points[i].Timestamp
+			j := i + 1
+			for j < len(s.Points) && s.Points[j].Timestamp.Sub(s.Points[j-1].Timestamp) <= step {
+				j++
+			}
+			active := s.Points[j-1].Timestamp.Sub(start) + step
+			if active > pending {
+				firings++
+				firing += active - pending
+			}
+			i = j
+		}
+	}
+	return firings, firing
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/record"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+)
+
+func TestDryRunInvalidAnnotation(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &RuleReconciler{Recorder: recorder}
+	rule := newRule("team-a", "api", recording("job:up:sum", "sum by (job) (up)"))
+	rule.Annotations = map[string]string{monitoringv1alpha1.DryRunAnnotation: "-1h"}
+
+	// the event is only recorded when the annotation becomes invalid
+	for i := 0; i < 2; i++ {
+		previous := rule.Status.DeepCopy()
+		if err := r.dryRun(context.Background(), &operatorconfig.Settings{}, rule, previous); err != nil {
+			t.Fatal(err)
+		}
+		if !meta.IsStatusConditionFalse(rule.Status.Conditions, monitoringv1alpha1.ConditionDryRun) {
+			t.Fatalf("DryRun condition is not false: %v", rule.Status.Conditions)
+		}
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected a single event, got %v", len(recorder.Events))
+	}
+
+	delete(rule.Annotations, monitoringv1alpha1.DryRunAnnotation)
+	if err := r.dryRun(context.Background(), &operatorconfig.Settings{}, rule, rule.Status.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+	if meta.FindStatusCondition(rule.Status.Conditions, monitoringv1alpha1.ConditionDryRun) != nil {
+		t.Errorf("DryRun condition not removed with the annotation")
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+)
+
+// fullSyncRetryPeriod is the delay before retrying a failed full sync
+const fullSyncRetryPeriod = 10 * time.Second
+
+// OutputSync is a manager.Runnable rebuilding every output once the manager
+// is elected, before the controllers catch up with the changes made while no
+// replica was leader. It deletes the orphaned ConfigMaps of the outputs of
+// PrometheusTargets, like the ones left by a target deleted without cascade,
+// and writes the ConfigMaps of every output. Its Check fails until the full
+// sync completed.
+type OutputSync struct {
+	client.Client
+
+	// Targets and Output render the outputs of the PrometheusTargets and of
+	// the operator settings
+	Targets *PrometheusTargetReconciler
+	Output  *OutputReconciler
+
+	// synced is set once the full sync completed, it is accessed atomically
+	synced int32
+}
+
+// Start implements manager.Runnable, the full sync is retried until it
+// completes
+func (s *OutputSync) Start(ctx context.Context) error {
+	log := ctrl.Log.WithName("output-sync")
+	for {
+		err := s.sync(ctx)
+		if err == nil {
+			log.Info("full sync of the outputs completed")
+			atomic.StoreInt32(&s.synced, 1)
+			return nil
+		}
+		log.Error(err, "full sync of the outputs failed")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(fullSyncRetryPeriod):
+		}
+	}
+}
+
+// sync deletes the orphaned ConfigMaps of the outputs and renders every
+// output
+func (s *OutputSync) sync(ctx context.Context) error {
+	var targets monitoringv1alpha1.PrometheusTargetList
+	if err := s.List(ctx, &targets); err != nil {
+		return fmt.Errorf("unable to list prometheus targets: %w", err)
+	}
+	if err := s.collect(ctx, targets.Items); err != nil {
+		return err
+	}
+	for _, target := range targets.Items {
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: target.Namespace, Name: target.Name}}
+		if _, err := s.Targets.Reconcile(ctx, req); err != nil {
+			return fmt.Errorf("unable to render the output of prometheus target %v: %w", req, err)
+		}
+	}
+	if _, err := s.Output.Reconcile(ctx, defaultOutputRequest); err != nil {
+		return fmt.Errorf("unable to render the default output: %w", err)
+	}
+	return nil
+}
+
+// collect deletes the ConfigMaps of the outputs of PrometheusTargets that no
+// existing target controls
+func (s *OutputSync) collect(ctx context.Context, targets []monitoringv1alpha1.PrometheusTarget) error {
+	uids := make(map[types.UID]struct{}, len(targets))
+	for _, target := range targets {
+		uids[target.UID] = struct{}{}
+	}
+	var configMaps corev1.ConfigMapList
+	if err := s.List(ctx, &configMaps, client.MatchingLabels{output.OutputLabel: output.TargetOutput}); err != nil {
+		return fmt.Errorf("unable to list configmaps: %w", err)
+	}
+	writer := &outputWriter{Client: s.Client, Locks: s.Targets.Locks}
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		if owner := metav1.GetControllerOf(configMap); owner != nil {
+			if _, ok := uids[owner.UID]; ok {
+				continue
+			}
+		}
+		if err := writer.delete(ctx, configMap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Synced reports whether the full sync completed
+func (s *OutputSync) Synced() bool {
+	return atomic.LoadInt32(&s.synced) != 0
+}
+
+// Check is a healthz.Checker failing until the full sync completed
+func (s *OutputSync) Check(*http.Request) error {
+	if !s.Synced() {
+		return errors.New("full sync of the outputs not completed")
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+)
+
+func TestOutputSync(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"success","data":{"groups":[]}}`))
+	}))
+	defer server.Close()
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := monitoringv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	target := &monitoringv1alpha1.PrometheusTarget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "main", UID: "main"},
+		Spec: monitoringv1alpha1.PrometheusTargetSpec{
+			URL:    server.URL,
+			Output: monitoringv1alpha1.Output{ConfigMap: monitoringv1alpha1.ConfigMapOutput{Name: "main-rules"}},
+		},
+	}
+	orphan := func(name string, owner *metav1.OwnerReference) *corev1.ConfigMap {
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "monitoring",
+			Name:      name,
+			Labels:    map[string]string{output.OutputLabel: output.TargetOutput},
+		}}
+		if owner != nil {
+			configMap.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return configMap
+	}
+	controller := true
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		target,
+		newRule("team-a", "api", recording("job:up:sum", "sum by (job) (up)")),
+		// left by a target deleted without cascade
+		orphan("deleted-rules-0", nil),
+		// controlled by a target deleted while the operator was down
+		orphan("gone-rules-0", &metav1.OwnerReference{
+			APIVersion: monitoringv1alpha1.GroupVersion.String(),
+			Kind:       "PrometheusTarget",
+			Name:       "gone",
+			UID:        "gone",
+			Controller: &controller,
+		}),
+		// not written by the operator
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "other"}},
+	).Build()
+
+	settings, err := operatorconfig.New(&configv1alpha1.RulesConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := operatorconfig.NewStore(settings)
+	locks := output.NewLocks()
+	sync := &OutputSync{
+		Client: c,
+		Targets: &PrometheusTargetReconciler{
+			Client:      c,
+			Scheme:      scheme,
+			Recorder:    record.NewFakeRecorder(10),
+			Config:      config,
+			HTTPClients: httpconfig.NewClients(c),
+			TargetRules: NewTargetRules(httpconfig.NewClients(c)),
+			Locks:       locks,
+		},
+		Output: &OutputReconciler{Client: c, Scheme: scheme, Config: config, Locks: locks},
+	}
+
+	if err := sync.Check(nil); err == nil {
+		t.Errorf("ready before the full sync")
+	}
+	if err := sync.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sync.Check(nil); err != nil {
+		t.Errorf("not ready after the full sync: %v", err)
+	}
+
+	var configMaps corev1.ConfigMapList
+	if err := c.List(context.Background(), &configMaps, client.InNamespace("monitoring")); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, configMap := range configMaps.Items {
+		names = append(names, configMap.Name)
+	}
+	sort.Strings(names)
+	if expected := []string{"main-rules-0", "other"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("configmaps %q, expected %q", names, expected)
+	}
+}
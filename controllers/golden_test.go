@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+)
+
+// update regenerates the golden files instead of comparing with them:
+//
+//	go test ./controllers -run TestGolden -update
+var update = flag.Bool("update", false, "update the golden files of testdata/golden")
+
+// goldenDir holds a directory per golden case, with the Rules of the case in
+// input/*.yaml and the rule file expected from them in expected.yaml
+const goldenDir = "testdata/golden"
+
+// TestGolden renders the Rules of each golden case and compares the rule
+// file byte for byte with the expected one. It doesn't use the envtest suite,
+// rendering doesn't need an API server.
+func TestGolden(t *testing.T) {
+	cases, err := ioutil.ReadDir(goldenDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		if !c.IsDir() {
+			continue
+		}
+		dir := filepath.Join(goldenDir, c.Name())
+		t.Run(c.Name(), func(t *testing.T) {
+			rules, err := readGoldenRules(filepath.Join(dir, "input"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := render.Render(rules...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the rule file must not depend on the order the Rules are listed
+			reversed := make([]*monitoringv1alpha1.Rule, 0, len(rules))
+			for i := len(rules) - 1; i >= 0; i-- {
+				reversed = append(reversed, rules[i])
+			}
+			if again, err := render.Render(reversed...); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(again, actual) {
+				t.Errorf("rendering depends on the order of the Rules:\n%s\nreversed:\n%s", actual, again)
+			}
+
+			golden := filepath.Join(dir, "expected.yaml")
+			if *update {
+				if err := ioutil.WriteFile(golden, actual, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("rendered rule file differs from %v, run with -update to accept the change:\n%s", golden, actual)
+			}
+		})
+	}
+}
+
+// readGoldenRules reads the Rules of the YAML files of dir, sorted by file
+// name, objects of other kinds are ignored
+func readGoldenRules(dir string) ([]*monitoringv1alpha1.Rule, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var rules []*monitoringv1alpha1.Rule
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+		for {
+			var rule monitoringv1alpha1.Rule
+			if err := decoder.Decode(&rule); err == io.EOF {
+				break
+			} else if err != nil {
+				f.Close()
+				return nil, err
+			}
+			if rule.Kind != "Rule" {
+				continue
+			}
+			rules = append(rules, &rule)
+		}
+		f.Close()
+	}
+	return rules, nil
+}
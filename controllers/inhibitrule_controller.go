@@ -0,0 +1,310 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/alertmanager"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+)
+
+// alertmanagerConfigRequest is the request writing the Alertmanager
+// configuration, rendered from every InhibitRule at once. InhibitRules are
+// namespaced, their requests never match it.
+var alertmanagerConfigRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: output.AlertmanagerOutput}}
+
+// InhibitRuleReconciler reconciles a InhibitRule object
+type InhibitRuleReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Config holds the operator settings, the Alertmanager configuration is
+	// written again whenever they are reloaded
+	Config *operatorconfig.Store
+
+	// Options configures the concurrency and rate limiting of the controller
+	Options controller.Options
+}
+
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=inhibitrules,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=inhibitrules/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=inhibitrules/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile checks the matchers of the InhibitRule and reports the result in
+// its Invalid condition. Alertmanager has no API for inhibition rules, they
+// are only read from its configuration file: the valid InhibitRules are
+// written with the configuration of the operator settings to the generated
+// Secret, by the single alertmanagerConfigRequest.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *InhibitRuleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if req == alertmanagerConfigRequest {
+		return ctrl.Result{}, r.writeConfig(ctx, r.Config.Get())
+	}
+
+	var rule monitoringv1alpha1.InhibitRule
+	if err := r.Get(ctx, req.NamespacedName, &rule); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	status := rule.Status.DeepCopy()
+
+	invalid := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionInvalid,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "Valid",
+		Message:            "InhibitRule is valid",
+	}
+	if problems := inhibitRuleProblems(&rule); len(problems) > 0 {
+		invalid.Status = metav1.ConditionTrue
+		invalid.Reason = "InvalidSpec"
+		invalid.Message = strings.Join(problems, "; ") + ", left out of the Alertmanager configuration"
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, invalid)
+
+	if equality.Semantic.DeepEqual(status, &rule.Status) {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, client.IgnoreNotFound(r.Status().Update(ctx, &rule))
+}
+
+// writeConfig writes the Alertmanager configuration of settings with the
+// valid InhibitRules to the generated Secret, and deletes the Secrets
+// generated before under another name. The other keys of the configuration
+// Secret, like templates, are copied along.
+func (r *InhibitRuleReconciler) writeConfig(ctx context.Context, settings *operatorconfig.Settings) error {
+	ref := settings.AlertmanagerGeneratedConfigSecret
+	if err := r.collect(ctx, ref); err != nil {
+		return err
+	}
+	if ref == nil {
+		return nil
+	}
+
+	base := settings.AlertmanagerConfigSecret
+	var secret corev1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: base.Namespace, Name: base.Name}, &secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			// written once the Secret is created
+			log.FromContext(ctx).Error(err, "missing Alertmanager configuration", "secret", base.Namespace+"/"+base.Name)
+			return nil
+		}
+		return fmt.Errorf("unable to get Alertmanager configuration %v/%v: %w", base.Namespace, base.Name, err)
+	}
+	var inhibitRules monitoringv1alpha1.InhibitRuleList
+	if err := r.List(ctx, &inhibitRules); err != nil {
+		return fmt.Errorf("unable to list inhibit rules: %w", err)
+	}
+	sort.Slice(inhibitRules.Items, func(i, j int) bool {
+		a, b := &inhibitRules.Items[i], &inhibitRules.Items[j]
+		return a.Namespace < b.Namespace || a.Namespace == b.Namespace && a.Name < b.Name
+	})
+	rules := make([]alertmanager.InhibitRule, 0, len(inhibitRules.Items))
+	for i := range inhibitRules.Items {
+		rule := &inhibitRules.Items[i]
+		if len(inhibitRuleProblems(rule)) > 0 {
+			continue
+		}
+		rules = append(rules, alertmanager.InhibitRule{
+			SourceMatchers: matcherStrings(rule.Spec.SourceMatchers),
+			TargetMatchers: matcherStrings(rule.Spec.TargetMatchers),
+			Equal:          rule.Spec.Equal,
+		})
+	}
+	data, err := alertmanager.WithInhibitRules(secret.Data[base.Key], rules)
+	if err != nil {
+		// retrying won't help until the configuration changes
+		log.FromContext(ctx).Error(err, "invalid Alertmanager configuration",
+			"secret", base.Namespace+"/"+base.Name, "key", base.Key)
+		return nil
+	}
+
+	generated := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: ref.Namespace, Name: ref.Name}}
+	drifted := false
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, generated, func() error {
+		if generated.ResourceVersion != "" && generated.Labels[output.OutputLabel] != output.AlertmanagerOutput {
+			return fmt.Errorf("secret is not written by the operator")
+		}
+		if generated.Labels == nil {
+			generated.Labels = make(map[string]string, 2)
+		}
+		generated.Labels[output.OutputLabel] = output.AlertmanagerOutput
+		// read back by the operator
+		generated.Labels[monitoringv1alpha1.SecretLabel] = "true"
+		if hash, ok := generated.Annotations[output.HashAnnotation]; ok {
+			drifted = hash != output.Hash(generated.Data[ref.Key])
+		}
+		if generated.Annotations == nil {
+			generated.Annotations = make(map[string]string, 1)
+		}
+		generated.Annotations[output.HashAnnotation] = output.Hash(data)
+		generated.Data = make(map[string][]byte, len(secret.Data)+1)
+		for key, value := range secret.Data {
+			generated.Data[key] = value
+		}
+		generated.Data[ref.Key] = data
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write Alertmanager configuration %v/%v: %w", ref.Namespace, ref.Name, err)
+	}
+	switch {
+	case result == controllerutil.OperationResultNone:
+	case drifted:
+		log.FromContext(ctx).Info("Alertmanager configuration changed outside of the operator, restored",
+			"secret", ref.Namespace+"/"+ref.Name)
+		r.Recorder.Event(generated, corev1.EventTypeWarning, "DriftCorrected",
+			"Alertmanager configuration was changed outside of the operator and restored")
+		metrics.DriftCorrections.WithLabelValues("Secret").Inc()
+	default:
+		log.FromContext(ctx).Info("Alertmanager configuration written", "secret", ref.Namespace+"/"+ref.Name,
+			"operation", result, "inhibitRules", len(rules))
+	}
+	return nil
+}
+
+// collect deletes the Secrets of the Alertmanager configuration written by
+// the operator, other than ref
+func (r *InhibitRuleReconciler) collect(ctx context.Context, ref *configv1alpha1.SecretKeyReference) error {
+	var secrets corev1.SecretList
+	if err := r.List(ctx, &secrets, client.MatchingLabels{output.OutputLabel: output.AlertmanagerOutput}); err != nil {
+		return fmt.Errorf("unable to list secrets: %w", err)
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if ref != nil && secret.Namespace == ref.Namespace && secret.Name == ref.Name {
+			continue
+		}
+		if err := r.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("unable to delete secret %v/%v: %w", secret.Namespace, secret.Name, err)
+		}
+		log.FromContext(ctx).Info("stale Alertmanager configuration deleted", "secret", secret.Namespace+"/"+secret.Name)
+	}
+	return nil
+}
+
+// inhibitRuleProblems returns a description of each invalid matcher of rule
+func inhibitRuleProblems(rule *monitoringv1alpha1.InhibitRule) []string {
+	var problems []string
+	for _, m := range rule.Spec.SourceMatchers {
+		if _, err := toMatcher(m); err != nil {
+			problems = append(problems, fmt.Sprintf("source matcher: %v", err))
+		}
+	}
+	for _, m := range rule.Spec.TargetMatchers {
+		if _, err := toMatcher(m); err != nil {
+			problems = append(problems, fmt.Sprintf("target matcher: %v", err))
+		}
+	}
+	for _, label := range rule.Spec.Equal {
+		if _, err := alertmanager.ParseMatcher(label + `=""`); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid equal label %q", label))
+		}
+	}
+	return problems
+}
+
+// toMatcher compiles m
+func toMatcher(m monitoringv1alpha1.Matcher) (*alertmanager.Matcher, error) {
+	t := alertmanager.MatchEqual
+	switch {
+	case m.IsRegex && m.IsNegative:
+		t = alertmanager.MatchNotRegex
+	case m.IsRegex:
+		t = alertmanager.MatchRegex
+	case m.IsNegative:
+		t = alertmanager.MatchNotEqual
+	}
+	return alertmanager.ParseMatcher(fmt.Sprintf("%v%v%q", m.Name, t, m.Value))
+}
+
+// matcherStrings returns the matchers of the Alertmanager configuration file
+// for ms, whose invalid matchers are skipped
+func matcherStrings(ms []monitoringv1alpha1.Matcher) []string {
+	matchers := make([]string, 0, len(ms))
+	for _, m := range ms {
+		if matcher, err := toMatcher(m); err == nil {
+			matchers = append(matchers, matcher.String())
+		}
+	}
+	return matchers
+}
+
+// SetupWithManager sets up the controller with the Manager. Changes of the
+// InhibitRules, of the Secrets of the Alertmanager configuration and of the
+// settings also map to alertmanagerConfigRequest.
+func (r *InhibitRuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	toConfig := func(client.Object) []reconcile.Request {
+		return []reconcile.Request{alertmanagerConfigRequest}
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&monitoringv1alpha1.InhibitRule{}).
+		WithOptions(r.Options).
+		Watches(&source.Kind{Type: &monitoringv1alpha1.InhibitRule{}}, enqueueDebouncedRequestsFromMapFunc(toConfig),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, enqueueDebouncedRequestsFromMapFunc(toConfig),
+			builder.WithPredicates(predicate.NewPredicateFuncs(r.configSecret))).
+		Watches(&source.Channel{Source: r.Config.Subscribe()}, enqueueCoalescedRequestsFromMapFunc(toConfig)).
+		Complete(r)
+}
+
+// configSecret reports whether obj is a Secret of the Alertmanager
+// configuration, read or written by the operator
+func (r *InhibitRuleReconciler) configSecret(obj client.Object) bool {
+	if obj.GetLabels()[output.OutputLabel] == output.AlertmanagerOutput {
+		return true
+	}
+	settings := r.Config.Get()
+	for _, ref := range []*configv1alpha1.SecretKeyReference{
+		settings.AlertmanagerConfigSecret, settings.AlertmanagerGeneratedConfigSecret,
+	} {
+		if ref != nil && obj.GetNamespace() == ref.Namespace && obj.GetName() == ref.Name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+)
+
+func TestAlertmanagerConfig(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := monitoringv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	inhibitRule := func(name string, target monitoringv1alpha1.Matcher) *monitoringv1alpha1.InhibitRule {
+		return &monitoringv1alpha1.InhibitRule{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: name},
+			Spec: monitoringv1alpha1.InhibitRuleSpec{
+				SourceMatchers: []monitoringv1alpha1.Matcher{{Name: "alertname", Value: "ClusterDown"}},
+				TargetMatchers: []monitoringv1alpha1.Matcher{target},
+				Equal:          []string{"cluster"},
+			},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "alertmanager"},
+			Data: map[string][]byte{
+				"alertmanager.yaml": []byte("route:\n  receiver: default\nreceivers:\n- name: default\n"),
+				"default.tmpl":      []byte(`{{ define "title" }}alert{{ end }}`),
+			},
+		},
+		// generated before the secret was renamed
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "monitoring",
+			Name:      "renamed",
+			Labels:    map[string]string{output.OutputLabel: output.AlertmanagerOutput},
+		}},
+		inhibitRule("warnings", monitoringv1alpha1.Matcher{Name: "severity", Value: "warning|info", IsRegex: true}),
+		inhibitRule("invalid", monitoringv1alpha1.Matcher{Name: "severity", Value: "(", IsRegex: true}),
+	).Build()
+
+	settings, err := operatorconfig.New(&configv1alpha1.RulesConfig{Alertmanager: configv1alpha1.AlertmanagerConfig{
+		ConfigSecret:          &configv1alpha1.SecretKeyReference{Namespace: "monitoring", Name: "alertmanager"},
+		GeneratedConfigSecret: &configv1alpha1.SecretKeyReference{Namespace: "monitoring", Name: "generated"},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &InhibitRuleReconciler{Client: c, Scheme: scheme, Recorder: recorder, Config: operatorconfig.NewStore(settings)}
+	key := types.NamespacedName{Namespace: "monitoring", Name: "generated"}
+
+	if _, err := r.Reconcile(ctx, alertmanagerConfigRequest); err != nil {
+		t.Fatal(err)
+	}
+	var generated corev1.Secret
+	if err := c.Get(ctx, key, &generated); err != nil {
+		t.Fatal(err)
+	}
+	config := string(generated.Data["alertmanager.yaml"])
+	if !strings.Contains(config, "inhibit_rules:\n- equal:\n  - cluster\n  source_matchers:\n  - alertname=\"ClusterDown\"\n"+
+		"  target_matchers:\n  - severity=~\"warning|info\"\nreceivers:") {
+		t.Errorf("generated configuration %q, expected the valid inhibit rule only", config)
+	}
+	if len(generated.Data["default.tmpl"]) == 0 {
+		t.Errorf("templates of the configuration not copied")
+	}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "monitoring", Name: "renamed"}, &corev1.Secret{}); !apierrors.IsNotFound(err) {
+		t.Errorf("stale generated configuration not deleted: %v", err)
+	}
+
+	// a configuration edited by hand is restored
+	generated.Data["alertmanager.yaml"] = []byte("route:\n  receiver: other\n")
+	if err := c.Update(ctx, &generated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, alertmanagerConfigRequest); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, &generated); err != nil {
+		t.Fatal(err)
+	}
+	if string(generated.Data["alertmanager.yaml"]) != config {
+		t.Errorf("configuration edited by hand not restored")
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning DriftCorrected") {
+			t.Errorf("event %q, expected a DriftCorrected warning", event)
+		}
+	default:
+		t.Errorf("restored configuration not reported")
+	}
+
+	// secrets not written by the operator are left alone
+	delete(generated.Labels, output.OutputLabel)
+	if err := c.Update(ctx, &generated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, alertmanagerConfigRequest); err == nil {
+		t.Errorf("secret not written by the operator overwritten")
+	}
+}
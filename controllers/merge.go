@@ -0,0 +1,132 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/merge"
+)
+
+// ruleMergeKeysField is the cache index listing the merge keys of the groups
+// of a Rule, as namespace/key
+const ruleMergeKeysField = ".spec.groups.mergeKey"
+
+// mergeKeys returns the sorted, deduplicated merge keys of the groups of rule
+// as namespace/key
+func mergeKeys(rule *monitoringv1alpha1.Rule) []string {
+	set := make(map[string]struct{})
+	for _, group := range rule.Spec.Groups {
+		if group.MergeKey != "" {
+			set[rule.Namespace+"/"+group.MergeKey] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// indexRuleMergeKeys is the IndexerFunc backing ruleMergeKeysField
+func indexRuleMergeKeys(obj client.Object) []string {
+	rule, ok := obj.(*monitoringv1alpha1.Rule)
+	if !ok {
+		return nil
+	}
+	return mergeKeys(rule)
+}
+
+// checkMerges fills the merged groups of rule and sets its MergeConflict
+// condition
+func (r *RuleReconciler) checkMerges(ctx context.Context, rule *monitoringv1alpha1.Rule, previous *monitoringv1alpha1.RuleStatus) error {
+	contributors := []*monitoringv1alpha1.Rule{rule}
+	for _, key := range mergeKeys(rule) {
+		var rules monitoringv1alpha1.RuleList
+		if err := r.List(ctx, &rules, client.MatchingFields{ruleMergeKeysField: key}); err != nil {
+			return fmt.Errorf("unable to list rules merged into %v: %w", key, err)
+		}
+		for i := range rules.Items {
+			if other := &rules.Items[i]; other.UID != rule.UID && !contains(contributors, other) {
+				contributors = append(contributors, other)
+			}
+		}
+	}
+
+	name := types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}
+	var statuses []monitoringv1alpha1.MergedGroupStatus
+	var conflicts []string
+	for _, group := range merge.Groups(contributors...) {
+		for _, c := range group.Contributions {
+			if c.Rule != name {
+				continue
+			}
+			status := monitoringv1alpha1.MergedGroupStatus{
+				Group:       c.Group,
+				MergedGroup: group.Name,
+				Position:    int32(c.Position),
+			}
+			for _, other := range group.Contributions {
+				status.Contributors = append(status.Contributors, other.String())
+			}
+			statuses = append(statuses, status)
+		}
+		if group.Conflict != "" {
+			conflicts = append(conflicts, fmt.Sprintf("merged group %q: %v", group.Name, group.Conflict))
+		}
+	}
+	rule.Status.MergedGroups = statuses
+
+	conflict := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionMergeConflict,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "NoConflict",
+		Message:            "merged groups are evaluated at the same interval",
+	}
+	if len(conflicts) > 0 {
+		conflict.Status = metav1.ConditionTrue
+		conflict.Reason = "IntervalMismatch"
+		conflict.Message = strings.Join(conflicts, "; ")
+		if !meta.IsStatusConditionTrue(previous.Conditions, monitoringv1alpha1.ConditionMergeConflict) {
+			r.Recorder.Event(rule, corev1.EventTypeWarning, conflict.Reason, conflict.Message)
+		}
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, conflict)
+	return nil
+}
+
+// contains reports whether rules contains a Rule with the UID of rule
+func contains(rules []*monitoringv1alpha1.Rule, rule *monitoringv1alpha1.Rule) bool {
+	for _, r := range rules {
+		if r.UID == rule.UID {
+			return true
+		}
+	}
+	return false
+}
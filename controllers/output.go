@@ -0,0 +1,191 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+)
+
+// outputWriter writes the rule files of an output to its ConfigMaps
+type outputWriter struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Namespace of the ConfigMaps
+	Namespace string
+	// Kind of the output, the value of output.OutputLabel
+	Kind string
+	// Owner, when set, controls the ConfigMaps
+	Owner client.Object
+	// Locks serializes the renders of each output
+	Locks *output.Locks
+	// Recorder reports the ConfigMaps restored after a change made outside
+	// of the operator
+	Recorder record.EventRecorder
+}
+
+// lock locks the ConfigMaps of the shards of config and returns the function
+// unlocking them. Writers hold it from the list of the Rules of the output to
+// the write of its files, so that a writer rendering an older list of Rules,
+// like the full sync racing a worker, can't overwrite the files rendered from
+// a newer one. The shards are locked in order, so that writers of the same
+// output don't deadlock.
+func (w *outputWriter) lock(config *monitoringv1alpha1.ConfigMapOutput) (unlock func()) {
+	shards := output.Shards(config)
+	unlocks := make([]func(), 0, shards)
+	for shard := int32(0); shard < shards; shard++ {
+		unlocks = append(unlocks, w.Locks.Lock(types.NamespacedName{
+			Namespace: w.Namespace,
+			Name:      output.ConfigMapName(config, shard),
+		}))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// write writes files to their ConfigMaps and deletes the other ConfigMaps of
+// the output, the caller holding the lock of the output. Each ConfigMap is
+// written at most once, and not at all when its content is unchanged, so
+// that Prometheus only reloads the shards whose Rules changed. Files larger than output.MaxBytes are not written, their
+// ConfigMaps keep their previous content and a description of the problem
+// is returned for each of them. The size of every file is reported in the
+// metrics.
+func (w *outputWriter) write(ctx context.Context, files []output.File) ([]monitoringv1alpha1.ShardStatus, []string, error) {
+	var shards []monitoringv1alpha1.ShardStatus
+	var tooLarge []string
+	names := make(map[string]struct{}, len(files))
+	for _, file := range files {
+		names[file.Name] = struct{}{}
+		metrics.OutputBytes.WithLabelValues(w.Namespace + "/" + file.Name).Set(float64(len(file.Data)))
+		if len(file.Data) > output.MaxBytes {
+			tooLarge = append(tooLarge, fmt.Sprintf("rule file of ConfigMap %v is %v bytes, above the limit of %v bytes",
+				file.Name, len(file.Data), output.MaxBytes))
+			continue
+		}
+		shard, err := w.writeFile(ctx, file)
+		if err != nil {
+			return nil, nil, err
+		}
+		shards = append(shards, shard)
+	}
+
+	var configMaps corev1.ConfigMapList
+	if err := w.List(ctx, &configMaps, client.InNamespace(w.Namespace),
+		client.MatchingLabels{output.OutputLabel: w.Kind}); err != nil {
+		return nil, nil, fmt.Errorf("unable to list configmaps of namespace %v: %w", w.Namespace, err)
+	}
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		if _, ok := names[configMap.Name]; ok || !w.owns(configMap) {
+			continue
+		}
+		if err := w.delete(ctx, configMap); err != nil {
+			return nil, nil, err
+		}
+	}
+	return shards, tooLarge, nil
+}
+
+// writeFile writes file to its ConfigMap, the caller holding the lock of the
+// output. A rule file that doesn't match the hash written with it was changed
+// outside of the operator, restoring it is reported by a Warning event and in
+// the metrics.
+func (w *outputWriter) writeFile(ctx context.Context, file output.File) (monitoringv1alpha1.ShardStatus, error) {
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: w.Namespace, Name: file.Name}}
+	drifted := false
+	result, err := controllerutil.CreateOrUpdate(ctx, w.Client, configMap, func() error {
+		if kind, ok := configMap.Labels[output.OutputLabel]; ok && kind != w.Kind ||
+			metav1.GetControllerOf(configMap) != nil && !w.owns(configMap) {
+			return fmt.Errorf("configmap belongs to another output")
+		}
+		if configMap.Labels == nil {
+			configMap.Labels = make(map[string]string, 1)
+		}
+		configMap.Labels[output.OutputLabel] = w.Kind
+		if hash, ok := configMap.Annotations[output.HashAnnotation]; ok {
+			drifted = hash != output.Hash([]byte(configMap.Data[output.Key])) || len(configMap.Data) != 1 ||
+				len(configMap.BinaryData) != 0
+		}
+		if configMap.Annotations == nil {
+			configMap.Annotations = make(map[string]string, 1)
+		}
+		configMap.Annotations[output.HashAnnotation] = output.Hash(file.Data)
+		configMap.Data = map[string]string{output.Key: string(file.Data)}
+		configMap.BinaryData = nil
+		if w.Owner != nil {
+			return controllerutil.SetControllerReference(w.Owner, configMap, w.Scheme)
+		}
+		return nil
+	})
+	if err != nil {
+		return monitoringv1alpha1.ShardStatus{}, fmt.Errorf("unable to write configmap %v/%v: %w", w.Namespace, file.Name, err)
+	}
+	switch {
+	case result == controllerutil.OperationResultNone:
+	case drifted:
+		log.FromContext(ctx).Info("rule file changed outside of the operator, restored", "configMap", file.Name)
+		w.Recorder.Event(configMap, corev1.EventTypeWarning, "DriftCorrected",
+			"rule file was changed outside of the operator and restored")
+		metrics.DriftCorrections.WithLabelValues("ConfigMap").Inc()
+	default:
+		log.FromContext(ctx).Info("rule file written", "configMap", file.Name, "operation", result,
+			"groups", len(file.Groups), "bytes", len(file.Data))
+	}
+	return monitoringv1alpha1.ShardStatus{
+		Name:   file.Name,
+		Groups: int32(len(file.Groups)),
+		Bytes:  int64(len(file.Data)),
+	}, nil
+}
+
+// delete deletes the stale configMap of the output, holding its lock
+func (w *outputWriter) delete(ctx context.Context, configMap *corev1.ConfigMap) error {
+	unlock := w.Locks.Lock(types.NamespacedName{Namespace: configMap.Namespace, Name: configMap.Name})
+	defer unlock()
+
+	if err := w.Delete(ctx, configMap); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("unable to delete configmap %v/%v: %w", configMap.Namespace, configMap.Name, err)
+	}
+	metrics.OutputBytes.DeleteLabelValues(configMap.Namespace + "/" + configMap.Name)
+	log.FromContext(ctx).Info("stale rule file deleted", "namespace", configMap.Namespace, "configMap", configMap.Name)
+	return nil
+}
+
+// owns reports whether configMap belongs to the output
+func (w *outputWriter) owns(configMap *corev1.ConfigMap) bool {
+	if w.Owner == nil {
+		return metav1.GetControllerOf(configMap) == nil
+	}
+	return metav1.IsControlledBy(configMap, w.Owner)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+)
+
+// defaultOutputRequest is the only request of the OutputReconciler, the
+// default output being rendered from every Rule at once
+var defaultOutputRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: output.DefaultOutput}}
+
+// OutputReconciler renders the Rules handled by the operator into the output
+// of the operator settings
+type OutputReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Config holds the operator settings, the output is rendered again
+	// whenever they are reloaded
+	Config *operatorconfig.Store
+
+	// Locks serializes the renders of the outputs
+	Locks *output.Locks
+
+	// Options configures the concurrency and rate limiting of the controller
+	Options controller.Options
+}
+
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=rules,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile writes the Rules handled by the operator, with the injected
+// labels, to the ConfigMaps of the output of the operator settings. The
+// ConfigMaps of a previous output, in another namespace or removed from the
+// settings, are deleted.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *OutputReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	settings := r.Config.Get()
+	writer := &outputWriter{
+		Client:   r.Client,
+		Scheme:   r.Scheme,
+		Kind:     output.DefaultOutput,
+		Locks:    r.Locks,
+		Recorder: r.Recorder,
+	}
+
+	if config := settings.Output; config != nil {
+		writer.Namespace = config.Namespace
+		if err := r.renderOutput(ctx, settings, writer, &config.ConfigMap); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	var configMaps corev1.ConfigMapList
+	if err := r.List(ctx, &configMaps, client.MatchingLabels{output.OutputLabel: output.DefaultOutput}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to list configmaps: %w", err)
+	}
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		if configMap.Namespace == writer.Namespace || !writer.owns(configMap) {
+			continue
+		}
+		if err := writer.delete(ctx, configMap); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// renderOutput renders the Rules handled by the operator into the ConfigMaps
+// of config with writer. The lock of the output is held from the list of the
+// Rules to the write of the files.
+func (r *OutputReconciler) renderOutput(ctx context.Context, settings *operatorconfig.Settings,
+	writer *outputWriter, config *monitoringv1alpha1.ConfigMapOutput) error {
+	defer writer.lock(config)()
+
+	rules, err := HandledRules(ctx, r, settings)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	files, err := RenderFiles(settings, config, nil, rules)
+	if err != nil {
+		return err
+	}
+	metrics.RenderDuration.WithLabelValues(output.DefaultOutput).Observe(time.Since(start).Seconds())
+	_, tooLarge, err := writer.write(ctx, files)
+	if err != nil {
+		return err
+	}
+	if len(tooLarge) > 0 {
+		// retrying won't help until the Rules or the shards change
+		log.FromContext(ctx).Error(fmt.Errorf("%v", strings.Join(tooLarge, "; ")),
+			"rule files not written, increase the number of shards")
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager. The controller
+// has no object of its own, every event maps to its single request.
+func (r *OutputReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	options := r.Options
+	options.Reconciler = r
+	c, err := controller.New("output", mgr, options)
+	if err != nil {
+		return err
+	}
+	toOutput := enqueueDebouncedRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{defaultOutputRequest}
+	})
+	if err := c.Watch(&source.Kind{Type: &monitoringv1alpha1.Rule{}}, toOutput, renderedChanged); err != nil {
+		return err
+	}
+	if err := c.Watch(&source.Kind{Type: &corev1.Namespace{}}, toOutput, predicate.LabelChangedPredicate{}); err != nil {
+		return err
+	}
+	// ConfigMaps of the output modified or deleted by someone else are
+	// restored
+	if err := c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, toOutput,
+		predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetLabels()[output.OutputLabel] == output.DefaultOutput
+		})); err != nil {
+		return err
+	}
+	return c.Watch(&source.Channel{Source: r.Config.Subscribe()}, toOutput)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+)
+
+func TestOutputWriter(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	writer := &outputWriter{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Scheme:    scheme,
+		Namespace: "monitoring",
+		Kind:      output.DefaultOutput,
+		Locks:     output.NewLocks(),
+		Recorder:  record.NewFakeRecorder(10),
+	}
+	shards := int32(2)
+	config := &monitoringv1alpha1.ConfigMapOutput{Name: "rules", Shards: &shards}
+	// team-a and team-b are rendered into different shards
+	rules := []*monitoringv1alpha1.Rule{
+		newRule("team-a", "api", recording("job:up:sum", "sum by (job) (up)")),
+		newRule("team-b", "web", recording("job:up:max", "max by (job) (up)")),
+	}
+	resourceVersions := func() map[string]string {
+		var configMaps corev1.ConfigMapList
+		if err := writer.List(ctx, &configMaps, client.InNamespace("monitoring")); err != nil {
+			t.Fatal(err)
+		}
+		versions := map[string]string{}
+		for _, configMap := range configMaps.Items {
+			versions[configMap.Name] = configMap.ResourceVersion
+		}
+		return versions
+	}
+	write := func() {
+		files, err := output.Files(config, nil, rules...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, tooLarge, err := writer.write(ctx, files); err != nil || len(tooLarge) > 0 {
+			t.Fatalf("write() = %v, %v", tooLarge, err)
+		}
+	}
+
+	write()
+	written := resourceVersions()
+	if len(written) != 2 {
+		t.Fatalf("configmaps %v, expected 2 shards", written)
+	}
+	for name := range written {
+		if n := testutil.ToFloat64(metrics.OutputBytes.WithLabelValues("monitoring/" + name)); n == 0 {
+			t.Errorf("size of configmap %v not reported", name)
+		}
+	}
+
+	write()
+	for name, version := range resourceVersions() {
+		if version != written[name] {
+			t.Errorf("unchanged configmap %v written again", name)
+		}
+	}
+
+	rules[0].Spec.Groups[0].Rules[0].Expr = "sum by (job) (up{env=\"prod\"})"
+	write()
+	changed := output.ConfigMapName(config, output.Shard("team-a", shards))
+	for name, version := range resourceVersions() {
+		if (version != written[name]) != (name == changed) {
+			t.Errorf("configmap %v written: %v, expected only %v to be written", name, version != written[name], changed)
+		}
+	}
+
+	// a rule file edited by hand is restored
+	var configMap corev1.ConfigMap
+	if err := writer.Get(ctx, types.NamespacedName{Namespace: "monitoring", Name: changed}, &configMap); err != nil {
+		t.Fatal(err)
+	}
+	restored := configMap.Data[output.Key]
+	configMap.Data[output.Key] = "groups: []\n"
+	if err := writer.Update(ctx, &configMap); err != nil {
+		t.Fatal(err)
+	}
+	corrections := testutil.ToFloat64(metrics.DriftCorrections.WithLabelValues("ConfigMap"))
+	write()
+	if err := writer.Get(ctx, types.NamespacedName{Namespace: "monitoring", Name: changed}, &configMap); err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data[output.Key] != restored {
+		t.Errorf("rule file edited by hand not restored")
+	}
+	if n := testutil.ToFloat64(metrics.DriftCorrections.WithLabelValues("ConfigMap")); n != corrections+1 {
+		t.Errorf("%v drift corrections, expected %v", n, corrections+1)
+	}
+	select {
+	case event := <-writer.Recorder.(*record.FakeRecorder).Events:
+		if !strings.HasPrefix(event, "Warning DriftCorrected") {
+			t.Errorf("event %q, expected a DriftCorrected warning", event)
+		}
+	default:
+		t.Errorf("restored rule file not reported")
+	}
+
+	shards = 1
+	write()
+	if versions := resourceVersions(); len(versions) != 1 {
+		t.Errorf("configmaps %v, expected the removed shard to be deleted", versions)
+	}
+	if metrics.OutputBytes.DeleteLabelValues("monitoring/" + output.ConfigMapName(config, 1)) {
+		t.Errorf("size of the removed shard still reported")
+	}
+
+	other := &outputWriter{
+		Client:    writer.Client,
+		Scheme:    scheme,
+		Namespace: "monitoring",
+		Kind:      output.TargetOutput,
+		Owner:     &corev1.ConfigMap{},
+		Locks:     writer.Locks,
+	}
+	files, err := output.Files(config, nil, rules...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := other.write(ctx, files); err == nil {
+		t.Errorf("configmaps of another output overwritten")
+	}
+}
+
+func TestOutputWriterLock(t *testing.T) {
+	locks := output.NewLocks()
+	writer := &outputWriter{Namespace: "monitoring", Locks: locks}
+	shards := int32(2)
+	unlock := writer.lock(&monitoringv1alpha1.ConfigMapOutput{Name: "rules", Shards: &shards})
+
+	// a writer of the same output with more shards waits for the lock
+	locked := make(chan struct{})
+	go func() {
+		more := int32(3)
+		writer.lock(&monitoringv1alpha1.ConfigMapOutput{Name: "rules", Shards: &more})()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("output locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("output not locked once unlocked")
+	}
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/promql"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+)
+
+// PrometheusTargetReconciler reconciles a PrometheusTarget object
+type PrometheusTargetReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Config   *operatorconfig.Store
+
+	// HTTPClients are the clients of the PrometheusTargets
+	HTTPClients *httpconfig.Clients
+
+	// TargetRules caches the rule groups loaded by the PrometheusTargets,
+	// refreshed by each reconcile for the checks of the Rules
+	TargetRules *TargetRules
+
+	// Locks serializes the renders of the outputs
+	Locks *output.Locks
+
+	// Options configures the concurrency and rate limiting of the controller
+	Options controller.Options
+
+	reloads reloadTracker
+}
+
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=prometheustargets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=prometheustargets/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=prometheustargets/finalizers,verbs=update
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=rules,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile renders the Rules selected by the PrometheusTarget into the
+// ConfigMaps of its output. It reports in the PrometheusTarget status the
+// number of Rules it selects, the ConfigMaps written and whether its rules
+// API can be queried, and in the metrics the groups and rule evaluations per
+// second it is expected to run, the time taken to render them and the reloads
+// of its configuration. The target is checked again after the check
+// interval of the operator settings.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *PrometheusTargetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var target monitoringv1alpha1.PrometheusTarget
+	if err := r.Get(ctx, req.NamespacedName, &target); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.HTTPClients.Forget(targetOwner(req.Namespace, req.Name))
+			r.TargetRules.Forget(req.NamespacedName)
+			r.reloads.forget(req.NamespacedName)
+			metrics.DeleteTarget(req.String())
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	settings := r.Config.Get()
+	status := target.Status.DeepCopy()
+
+	groups, tooLarge, err := r.renderOutput(ctx, settings, &target)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	renderFailed := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionRenderFailed,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: target.Generation,
+		Reason:             "Rendered",
+		Message:            "rule files are written to the output",
+	}
+	if len(tooLarge) > 0 {
+		renderFailed.Status = metav1.ConditionTrue
+		renderFailed.Reason = "TooLarge"
+		renderFailed.Message = strings.Join(tooLarge, "; ") + ", increase the number of shards"
+		if !meta.IsStatusConditionTrue(status.Conditions, monitoringv1alpha1.ConditionRenderFailed) {
+			r.Recorder.Event(&target, corev1.EventTypeWarning, renderFailed.Reason, renderFailed.Message)
+		}
+	}
+	meta.SetStatusCondition(&target.Status.Conditions, renderFailed)
+	metrics.TargetGroups.WithLabelValues(req.String()).Set(float64(len(groups)))
+	metrics.TargetEvaluations.WithLabelValues(req.String()).Set(evaluationsPerSecond(groups, settings.DefaultInterval))
+
+	unreachable := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionUnreachable,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: target.Generation,
+		Reason:             "Reachable",
+		Message:            "rules API answered",
+	}
+	if _, err := r.TargetRules.Refresh(ctx, &target); err != nil {
+		unreachable.Status = metav1.ConditionTrue
+		unreachable.Reason = "Unreachable"
+		unreachable.Message = err.Error()
+	} else {
+		r.observeReloads(ctx, &target)
+	}
+	meta.SetStatusCondition(&target.Status.Conditions, unreachable)
+
+	result := ctrl.Result{RequeueAfter: settings.CheckInterval}
+	if equality.Semantic.DeepEqual(status, &target.Status) {
+		return result, nil
+	}
+	if err := r.Status().Update(ctx, &target); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	return result, nil
+}
+
+// renderOutput renders the Rules selected by target into the ConfigMaps of
+// its output and stores the Rules and shards in its status. It returns the
+// rendered groups, and the problems of the files too large to be written.
+// The lock of the output is held from the list of the Rules to the write of
+// the files.
+func (r *PrometheusTargetReconciler) renderOutput(ctx context.Context, settings *operatorconfig.Settings,
+	target *monitoringv1alpha1.PrometheusTarget) ([]render.Group, []string, error) {
+	writer := &outputWriter{
+		Client:    r.Client,
+		Scheme:    r.Scheme,
+		Namespace: target.Namespace,
+		Kind:      output.TargetOutput,
+		Owner:     target,
+		Locks:     r.Locks,
+		Recorder:  r.Recorder,
+	}
+	defer writer.lock(&target.Spec.Output.ConfigMap)()
+
+	selected, err := SelectedRules(ctx, r, settings, target)
+	if err != nil {
+		return nil, nil, err
+	}
+	target.Status.Rules = int32(len(selected))
+
+	start := time.Now()
+	files, err := RenderFiles(settings, &target.Spec.Output.ConfigMap, target.Spec.ExternalLabels, selected)
+	if err != nil {
+		return nil, nil, err
+	}
+	var groups []render.Group
+	for _, file := range files {
+		groups = append(groups, file.Groups...)
+	}
+	metrics.RenderDuration.WithLabelValues(output.TargetOutput + "/" + client.ObjectKeyFromObject(target).String()).
+		Observe(time.Since(start).Seconds())
+	shards, tooLarge, err := writer.write(ctx, files)
+	if err != nil {
+		return nil, nil, err
+	}
+	target.Status.Shards = shards
+	return groups, tooLarge, nil
+}
+
+// observeReloads reports in the metrics the reloads of the configuration of
+// target. Targets without runtime information are not reported.
+func (r *PrometheusTargetReconciler) observeReloads(ctx context.Context, target *monitoringv1alpha1.PrometheusTarget) {
+	c, err := targetClient(ctx, r.HTTPClients, target)
+	if err != nil {
+		return
+	}
+	info, err := c.RuntimeInfo(ctx)
+	if err != nil {
+		log.FromContext(ctx).V(1).Info("unable to get the runtime information of the target", "error", err.Error())
+		return
+	}
+	r.reloads.observe(types.NamespacedName{Namespace: target.Namespace, Name: target.Name}, info)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PrometheusTargetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&monitoringv1alpha1.PrometheusTarget{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&corev1.ConfigMap{}).
+		WithOptions(r.Options).
+		Watches(&source.Kind{Type: &monitoringv1alpha1.Rule{}}, enqueueDebouncedRequestsFromMapFunc(r.ruleTargets),
+			builder.WithPredicates(renderedChanged)).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, enqueueDebouncedRequestsFromMapFunc(r.allTargets),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.secretTargets)).
+		Watches(&source.Channel{Source: r.Config.Subscribe()}, enqueueCoalescedRequestsFromMapFunc(r.allTargets)).
+		Complete(r)
+}
+
+// allTargets maps any object to every PrometheusTarget, since their
+// selectors can match any Rule
+func (r *PrometheusTargetReconciler) allTargets(client.Object) []reconcile.Request {
+	var targets monitoringv1alpha1.PrometheusTargetList
+	if err := r.List(context.Background(), &targets); err != nil {
+		ctrl.Log.WithName("controllers").WithName("PrometheusTarget").Error(err, "unable to list prometheus targets")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(targets.Items))
+	for _, t := range targets.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: t.Namespace, Name: t.Name},
+		})
+	}
+	return requests
+}
+
+// ruleTargets maps a Rule to the PrometheusTargets selecting it, whose
+// outputs render it. Updates map both versions of the Rule, covering the
+// targets that no longer select it.
+func (r *PrometheusTargetReconciler) ruleTargets(obj client.Object) []reconcile.Request {
+	rule, ok := obj.(*monitoringv1alpha1.Rule)
+	if !ok {
+		return nil
+	}
+	var targets monitoringv1alpha1.PrometheusTargetList
+	if err := r.List(context.Background(), &targets); err != nil {
+		ctrl.Log.WithName("controllers").WithName("PrometheusTarget").Error(err, "unable to list prometheus targets")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range targets.Items {
+		target := &targets.Items[i]
+		selected, err := targetSelects(context.Background(), r, target, rule)
+		if err != nil {
+			ctrl.Log.WithName("controllers").WithName("PrometheusTarget").Error(err, "unable to check the selection of a rule",
+				"rule", rule.Namespace+"/"+rule.Name)
+			// render it again rather than miss the change
+			selected = true
+		}
+		if selected {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: target.Namespace, Name: target.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// secretTargets maps a Secret to the PrometheusTargets whose HTTP
+// configuration reads it
+func (r *PrometheusTargetReconciler) secretTargets(obj client.Object) []reconcile.Request {
+	var targets monitoringv1alpha1.PrometheusTargetList
+	if err := r.List(context.Background(), &targets, client.InNamespace(obj.GetNamespace())); err != nil {
+		ctrl.Log.WithName("controllers").WithName("PrometheusTarget").Error(err, "unable to list prometheus targets",
+			"namespace", obj.GetNamespace())
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range targets.Items {
+		t := &targets.Items[i]
+		if targetReferences(t, obj.GetNamespace(), obj.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: t.Namespace, Name: t.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// evaluationsPerSecond returns the number of rule evaluations per second of
+// groups, evaluated at defaultInterval when they have no interval. Groups
+// with an invalid interval are ignored, they are reported by their Rule.
+func evaluationsPerSecond(groups []render.Group, defaultInterval time.Duration) float64 {
+	var evaluations float64
+	for _, group := range groups {
+		interval := defaultInterval
+		if group.Interval != "" {
+			var err error
+			if interval, err = promql.ParseDuration(group.Interval); err != nil || interval <= 0 {
+				continue
+			}
+		}
+		evaluations += float64(len(group.Rules)) / interval.Seconds()
+	}
+	return evaluations
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/quota"
+)
+
+// checkQuotas sets the QuotaExceeded condition of rule. Rules of a namespace
+// are accounted oldest first, so the newest Rules are the ones exceeding the
+// RuleQuotas when the namespace is over quota.
+func (r *RuleReconciler) checkQuotas(ctx context.Context, rule *monitoringv1alpha1.Rule, previous *monitoringv1alpha1.RuleStatus) error {
+	var quotas monitoringv1alpha1.RuleQuotaList
+	if err := r.List(ctx, &quotas, client.InNamespace(rule.Namespace)); err != nil {
+		return fmt.Errorf("unable to list rule quotas of namespace %v: %w", rule.Namespace, err)
+	}
+	var rules monitoringv1alpha1.RuleList
+	if err := r.List(ctx, &rules, client.InNamespace(rule.Namespace)); err != nil {
+		return fmt.Errorf("unable to list rules of namespace %v: %w", rule.Namespace, err)
+	}
+	var older []*monitoringv1alpha1.Rule
+	for i := range rules.Items {
+		if other := &rules.Items[i]; other.UID != rule.UID && olderThan(other, rule) {
+			older = append(older, other)
+		}
+	}
+	before := quota.Usage(older...)
+	after := quota.Usage(append(older, rule)...)
+
+	var violations []string
+	for i := range quotas.Items {
+		q := &quotas.Items[i]
+		violations = append(violations, quota.Exceeded(q, before, after)...)
+		violations = append(violations, quota.IntervalViolations(q, rule)...)
+	}
+
+	exceeded := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionQuotaExceeded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "WithinQuota",
+		Message:            "Rule complies with the RuleQuotas of its namespace",
+	}
+	if len(violations) > 0 {
+		exceeded.Status = metav1.ConditionTrue
+		exceeded.Reason = "QuotaExceeded"
+		exceeded.Message = strings.Join(violations, "; ")
+		if !meta.IsStatusConditionTrue(previous.Conditions, monitoringv1alpha1.ConditionQuotaExceeded) {
+			r.Recorder.Event(rule, corev1.EventTypeWarning, exceeded.Reason, exceeded.Message)
+		}
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, exceeded)
+	return nil
+}
+
+// namespaceRules maps a RuleQuota to the Rules of its namespace
+func (r *RuleReconciler) namespaceRules(obj client.Object) []reconcile.Request {
+	return r.ruleRequests(client.InNamespace(obj.GetNamespace()))
+}
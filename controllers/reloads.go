@@ -0,0 +1,73 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/prometheus"
+)
+
+// reloadTracker follows the reloads of the configuration of the
+// PrometheusTargets through their runtime information, and reports them in
+// the metrics. It is safe for concurrent use.
+type reloadTracker struct {
+	mu   sync.Mutex
+	last map[types.NamespacedName]prometheus.RuntimeInfo
+}
+
+// observe reports the reloads of the PrometheusTarget key since its previous
+// observation, from its runtime information info. A successful reload moves
+// the last configuration time, a failed one clears the success flag:
+// consecutive failures between two observations are counted once.
+func (t *reloadTracker) observe(key types.NamespacedName, info *prometheus.RuntimeInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	target := key.String()
+	attempts := metrics.TargetReloadAttempts.WithLabelValues(target)
+	failures := metrics.TargetReloadFailures.WithLabelValues(target)
+	if info.ReloadConfigSuccess {
+		metrics.TargetReloadSuccessful.WithLabelValues(target).Set(1)
+	} else {
+		metrics.TargetReloadSuccessful.WithLabelValues(target).Set(0)
+	}
+
+	if previous, ok := t.last[key]; ok {
+		reloaded := info.LastConfigTime.After(previous.LastConfigTime)
+		if reloaded {
+			attempts.Inc()
+		}
+		if !info.ReloadConfigSuccess && (previous.ReloadConfigSuccess || reloaded) {
+			attempts.Inc()
+			failures.Inc()
+		}
+	}
+	if t.last == nil {
+		t.last = make(map[types.NamespacedName]prometheus.RuntimeInfo)
+	}
+	t.last[key] = *info
+}
+
+// forget drops the observations of the PrometheusTarget key
+func (t *reloadTracker) forget(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.last, key)
+}
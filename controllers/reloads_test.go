@@ -0,0 +1,65 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/prometheus"
+)
+
+func TestReloadTracker(t *testing.T) {
+	key := types.NamespacedName{Namespace: "monitoring", Name: "reloads"}
+	var tracker reloadTracker
+	loaded := time.Now()
+	for _, step := range []struct {
+		name               string
+		info               prometheus.RuntimeInfo
+		attempts, failures float64
+		successful         float64
+	}{
+		{"first observation", prometheus.RuntimeInfo{ReloadConfigSuccess: true, LastConfigTime: loaded}, 0, 0, 1},
+		{"no reload", prometheus.RuntimeInfo{ReloadConfigSuccess: true, LastConfigTime: loaded}, 0, 0, 1},
+		{"successful reload", prometheus.RuntimeInfo{ReloadConfigSuccess: true, LastConfigTime: loaded.Add(time.Minute)}, 1, 0, 1},
+		{"failed reload", prometheus.RuntimeInfo{ReloadConfigSuccess: false, LastConfigTime: loaded.Add(time.Minute)}, 2, 1, 0},
+		{"still failed", prometheus.RuntimeInfo{ReloadConfigSuccess: false, LastConfigTime: loaded.Add(time.Minute)}, 2, 1, 0},
+		{"fixed", prometheus.RuntimeInfo{ReloadConfigSuccess: true, LastConfigTime: loaded.Add(2 * time.Minute)}, 3, 1, 1},
+	} {
+		info := step.info
+		tracker.observe(key, &info)
+		if n := testutil.ToFloat64(metrics.TargetReloadAttempts.WithLabelValues(key.String())); n != step.attempts {
+			t.Errorf("%v: %v reload attempts, expected %v", step.name, n, step.attempts)
+		}
+		if n := testutil.ToFloat64(metrics.TargetReloadFailures.WithLabelValues(key.String())); n != step.failures {
+			t.Errorf("%v: %v reload failures, expected %v", step.name, n, step.failures)
+		}
+		if n := testutil.ToFloat64(metrics.TargetReloadSuccessful.WithLabelValues(key.String())); n != step.successful {
+			t.Errorf("%v: last reload successful %v, expected %v", step.name, n, step.successful)
+		}
+	}
+
+	tracker.forget(key)
+	metrics.DeleteTarget(key.String())
+	if metrics.TargetReloadAttempts.DeleteLabelValues(key.String()) {
+		t.Errorf("reload attempts still reported")
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/alertmanager"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+)
+
+// previewRouting stores in the status of rule the Alertmanager routes and
+// receivers of its alerts, matched on their name and static labels, with the
+// labels injected by settings as rendered. The preview is dropped when the Alertmanager configuration is missing or
+// invalid, which is logged since it is not a problem of the Rule.
+func (r *RuleReconciler) previewRouting(ctx context.Context, settings *operatorconfig.Settings, rule *monitoringv1alpha1.Rule) error {
+	rule.Status.Routing = nil
+	ref := settings.AlertmanagerConfigSecret
+	if ref == nil {
+		return nil
+	}
+
+	var secret corev1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "missing Alertmanager configuration", "secret", ref.Namespace+"/"+ref.Name)
+			return nil
+		}
+		return fmt.Errorf("unable to get Alertmanager configuration: %w", err)
+	}
+	cfg, err := alertmanager.ParseConfig(secret.Data[ref.Key])
+	if err != nil {
+		log.FromContext(ctx).Error(err, "invalid Alertmanager configuration",
+			"secret", ref.Namespace+"/"+ref.Name, "key", ref.Key)
+		return nil
+	}
+
+	for _, group := range settings.Inject(rule).Spec.Groups {
+		for _, def := range group.Rules {
+			if def.Alert == "" {
+				continue
+			}
+			labels := map[string]string{"alertname": def.Alert}
+			for name, value := range def.Labels {
+				labels[name] = value
+			}
+			routing := monitoringv1alpha1.AlertRouting{Group: group.Name, Alert: def.Alert}
+			for _, route := range cfg.Route.MatchingRoutes(labels) {
+				routing.Receivers = append(routing.Receivers, route.Receiver)
+				routing.Routes = append(routing.Routes, route.Key())
+			}
+			rule.Status.Routing = append(rule.Status.Routing, routing)
+		}
+	}
+	return nil
+}
+
+// secretRules maps a Secret to every Rule when it holds the Alertmanager
+// configuration, or credentials of Prometheus or of a PrometheusTarget, so
+// that rotated credentials are checked without waiting for the next check
+func (r *RuleReconciler) secretRules(obj client.Object) []reconcile.Request {
+	settings := r.Config.Get()
+	if ref := settings.AlertmanagerConfigSecret; ref != nil && obj.GetNamespace() == ref.Namespace && obj.GetName() == ref.Name {
+		return r.ruleRequests()
+	}
+	if settings.References(obj.GetNamespace(), obj.GetName()) {
+		return r.ruleRequests()
+	}
+
+	var targets monitoringv1alpha1.PrometheusTargetList
+	if err := r.List(context.Background(), &targets, client.InNamespace(obj.GetNamespace())); err != nil {
+		ctrl.Log.WithName("controllers").WithName("Rule").Error(err, "unable to list prometheus targets",
+			"namespace", obj.GetNamespace())
+		return nil
+	}
+	for i := range targets.Items {
+		if targetReferences(&targets.Items[i], obj.GetNamespace(), obj.GetName()) {
+			return r.ruleRequests()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+)
+
+const routingConfig = `
+route:
+  receiver: default
+  routes:
+  - matchers: [cluster="main", namespace="team-a"]
+    receiver: team-a
+receivers:
+- name: default
+- name: team-a
+`
+
+func TestPreviewRouting(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "alertmanager"},
+		Data:       map[string][]byte{operatorconfig.DefaultAlertmanagerConfigKey: []byte(routingConfig)},
+	}
+	r := &RuleReconciler{Client: fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(secret).Build()}
+	rule := newRule("team-a", "api", monitoringv1alpha1.RuleDefinition{Alert: "Down", Expr: "up == 0"})
+
+	for _, tc := range []struct {
+		name     string
+		config   configv1alpha1.RulesConfig
+		receiver string
+	}{
+		{"static labels", configv1alpha1.RulesConfig{}, "default"},
+		{"injected labels", configv1alpha1.RulesConfig{
+			Labels:         map[string]string{"cluster": "main"},
+			NamespaceLabel: "namespace",
+		}, "team-a"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.Alertmanager.ConfigSecret = &configv1alpha1.SecretKeyReference{Namespace: "monitoring", Name: "alertmanager"}
+			settings, err := operatorconfig.New(&tc.config, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.previewRouting(context.Background(), settings, rule); err != nil {
+				t.Fatal(err)
+			}
+			if len(rule.Status.Routing) != 1 {
+				t.Fatalf("routing = %v, expected the routing of a single alert", rule.Status.Routing)
+			}
+			if receivers := rule.Status.Routing[0].Receivers; !reflect.DeepEqual(receivers, []string{tc.receiver}) {
+				t.Errorf("receivers = %q, expected %q", receivers, tc.receiver)
+			}
+		})
+	}
+}
@@ -19,44 +19,280 @@ package controllers
 import (
 	"context"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
 )
 
 // RuleReconciler reconciles a Rule object
 type RuleReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Config holds the operator settings, Rules are reconciled again
+	// whenever they are reloaded
+	Config *operatorconfig.Store
+
+	// TargetRules caches the rule groups loaded by the PrometheusTargets
+	TargetRules *TargetRules
+
+	// Options configures the concurrency and rate limiting of the controller
+	Options controller.Options
+
+	// Reconciled, when set, is called after each reconcile, whatever its
+	// result
+	Reconciled func(ctrl.Request)
+
+	// files caches the rule files of the outputs
+	files fileCache
 }
 
 //+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=rules,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=rules/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=rules/finalizers,verbs=update
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=rulequotas,verbs=get;list;watch
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=prometheustargets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Rule object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+//
+// It ignores the Rules not selected by the operator settings. It checks that
+// the Rule is valid, that its record and alert names are not already defined
+// by an older Rule, resolves the recording rules its expressions depend on,
+// checks the RuleQuotas of its namespace, merges its groups with the groups of
+// other Rules sharing their merge key, previews the Alertmanager routing of
+// its alerts, checks that the PrometheusTargets selecting the Rule rendered
+// and loaded its groups and reports the results in the Rule status. When a Prometheus
+// server is configured, it also checks that the metrics used by the Rule
+// exist, measures the cardinality of its recording rules and evaluates its
+// alerts over past data when requested.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
 func (r *RuleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	var rule monitoringv1alpha1.Rule
+	if err := r.Get(ctx, req.NamespacedName, &rule); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	settings := r.Config.Get()
+	if selected, err := settings.Selects(ctx, r, &rule); err != nil || !selected {
+		if err == nil {
+			log.FromContext(ctx).V(1).Info("rule is not selected")
+		}
+		return ctrl.Result{}, err
+	}
+	status := rule.Status.DeepCopy()
+
+	r.checkValidity(ctx, &rule, status)
+	if err := r.checkConflicts(ctx, &rule); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.checkDependencies(ctx, &rule, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.checkQuotas(ctx, &rule, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.checkMerges(ctx, &rule, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.previewRouting(ctx, settings, &rule); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.checkTargets(ctx, settings, &rule); err != nil {
+		return ctrl.Result{}, err
+	}
 
-	// your logic here
+	var result ctrl.Result
+	if len(rule.Status.Targets) > 0 {
+		// targets load the groups asynchronously
+		result.RequeueAfter = settings.CheckInterval
+	}
+	if settings.Prometheus != nil {
+		if err := r.checkStaleMetrics(ctx, settings, &rule); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.dryRun(ctx, settings, &rule, status); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.checkCardinality(ctx, settings, &rule, status); err != nil {
+			return ctrl.Result{}, err
+		}
+		result.RequeueAfter = settings.CheckInterval
+	}
+	if pendingLoad(rule.Status.Targets) && loadCheckInterval < result.RequeueAfter {
+		result.RequeueAfter = loadCheckInterval
+	}
 
-	return ctrl.Result{}, nil
+	if equality.Semantic.DeepEqual(status, &rule.Status) {
+		return result, nil
+	}
+	if err := r.Status().Update(ctx, &rule); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	return result, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *RuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &monitoringv1alpha1.Rule{},
+		ruleNamesField, indexRuleNames); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &monitoringv1alpha1.Rule{},
+		ruleMetricsField, indexRuleMetrics); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &monitoringv1alpha1.Rule{},
+		ruleMergeKeysField, indexRuleMergeKeys); err != nil {
+		return err
+	}
+
+	// status updates, including the ones of the reconciler itself, don't
+	// change the result of a reconcile. Labels select the Rule and the dry-run
+	// annotation requests an evaluation, neither changes the generation.
+	ruleChanged := builder.WithPredicates(predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		predicate.LabelChangedPredicate{},
+		predicate.AnnotationChangedPredicate{},
+	))
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&monitoringv1alpha1.Rule{}).
-		Complete(r)
+		For(&monitoringv1alpha1.Rule{}, ruleChanged).
+		WithOptions(r.Options).
+		Watches(&source.Kind{Type: &monitoringv1alpha1.Rule{}}, enqueueCoalescedRequestsFromMapFunc(r.relatedRules),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &monitoringv1alpha1.RuleQuota{}}, enqueueCoalescedRequestsFromMapFunc(r.namespaceRules),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, enqueueCoalescedRequestsFromMapFunc(r.namespaceObjectRules),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, enqueueCoalescedRequestsFromMapFunc(r.secretRules)).
+		Watches(&source.Kind{Type: &monitoringv1alpha1.PrometheusTarget{}}, enqueueCoalescedRequestsFromMapFunc(r.allRules),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, enqueueCoalescedRequestsFromMapFunc(r.shardRules),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isOutputConfigMap))).
+		Watches(&source.Channel{Source: r.Config.Subscribe()}, enqueueCoalescedRequestsFromMapFunc(r.allRules)).
+		Complete(reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+			result, err := r.Reconcile(ctx, req)
+			if r.Reconciled != nil {
+				r.Reconciled(req)
+			}
+			return result, err
+		}))
+}
+
+// namespaceObjectRules maps a Namespace to its Rules, whose selection depends
+// on the namespace labels
+func (r *RuleReconciler) namespaceObjectRules(obj client.Object) []reconcile.Request {
+	return r.ruleRequests(client.InNamespace(obj.GetName()))
+}
+
+// isOutputConfigMap reports whether obj is a ConfigMap written by an output
+func isOutputConfigMap(obj client.Object) bool {
+	_, ok := obj.GetLabels()[output.OutputLabel]
+	return ok
+}
+
+// shardRules maps a ConfigMap of the output of a PrometheusTarget to the Rules
+// of the namespaces rendered into it, whose render status depends on it
+func (r *RuleReconciler) shardRules(obj client.Object) []reconcile.Request {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.Kind != "PrometheusTarget" {
+		return nil
+	}
+	var target monitoringv1alpha1.PrometheusTarget
+	if err := r.Get(context.Background(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: owner.Name}, &target); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			ctrl.Log.WithName("controllers").WithName("Rule").Error(err, "unable to get prometheus target")
+		}
+		return nil
+	}
+	config := &target.Spec.Output.ConfigMap
+	shards := output.Shards(config)
+	var requests []reconcile.Request
+	for _, request := range r.ruleRequests() {
+		if output.ConfigMapName(config, output.Shard(request.Namespace, shards)) == obj.GetName() {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// allRules maps a reload of the operator settings to every Rule
+func (r *RuleReconciler) allRules(client.Object) []reconcile.Request {
+	return r.ruleRequests()
+}
+
+// ruleRequests returns a request for each Rule listed with opts
+func (r *RuleReconciler) ruleRequests(opts ...client.ListOption) []reconcile.Request {
+	var rules monitoringv1alpha1.RuleList
+	if err := r.List(context.Background(), &rules, opts...); err != nil {
+		ctrl.Log.WithName("controllers").WithName("Rule").Error(err, "unable to list rules")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(rules.Items))
+	for _, rule := range rules.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name},
+		})
+	}
+	return requests
+}
+
+// relatedRules maps a Rule to the other Rules whose status depends on it: the
+// Rules sharing one of its record or alert names, the Rules using one of its
+// recorded metrics and the Rules sharing one of its merge keys.
+func (r *RuleReconciler) relatedRules(obj client.Object) []reconcile.Request {
+	rule, ok := obj.(*monitoringv1alpha1.Rule)
+	if !ok {
+		return nil
+	}
+	lookups := map[string][]string{
+		ruleNamesField:     ruleNames(rule),
+		ruleMetricsField:   recordNames(rule),
+		ruleMergeKeysField: mergeKeys(rule),
+	}
+	seen := make(map[types.NamespacedName]struct{})
+	var requests []reconcile.Request
+	for field, values := range lookups {
+		for _, value := range values {
+			var rules monitoringv1alpha1.RuleList
+			if err := r.List(context.Background(), &rules, client.MatchingFields{field: value}); err != nil {
+				ctrl.Log.WithName("controllers").WithName("Rule").Error(err, "unable to list related rules",
+					"field", field, "value", value)
+				continue
+			}
+			for _, other := range rules.Items {
+				key := types.NamespacedName{Namespace: other.Namespace, Name: other.Name}
+				if other.UID == rule.UID {
+					continue
+				}
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+				requests = append(requests, reconcile.Request{NamespacedName: key})
+			}
+		}
+	}
+	return requests
 }
@@ -0,0 +1,427 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+	promfake "github.com/cyrilix/prometheus-rules-operator/pkg/prometheus/fake"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+)
+
+// newRule returns a Rule of namespace with a group defining definitions
+func newRule(namespace, name string, definitions ...monitoringv1alpha1.RuleDefinition) *monitoringv1alpha1.Rule {
+	return &monitoringv1alpha1.Rule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: monitoringv1alpha1.RuleSpec{Groups: []monitoringv1alpha1.RuleGroup{{
+			Name:  name,
+			Rules: definitions,
+		}}},
+	}
+}
+
+func recording(name, expr string) monitoringv1alpha1.RuleDefinition {
+	return monitoringv1alpha1.RuleDefinition{Record: name, Expr: expr}
+}
+
+func keyOf(rule *monitoringv1alpha1.Rule) types.NamespacedName {
+	return types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}
+}
+
+// shardGroups returns a function reading the rule groups written to the
+// ConfigMap named key, for Eventually
+func shardGroups(key types.NamespacedName) func() []render.Group {
+	return func() []render.Group {
+		var configMap corev1.ConfigMap
+		if err := k8sClient.Get(context.Background(), key, &configMap); err != nil {
+			return nil
+		}
+		file, err := render.Unmarshal([]byte(configMap.Data[output.Key]))
+		if err != nil {
+			return nil
+		}
+		return file.Groups
+	}
+}
+
+// targetStatus returns a function getting the status of the Rule named key
+// for target, for Eventually
+func targetStatus(key types.NamespacedName, target string) func() *monitoringv1alpha1.TargetStatus {
+	return func() *monitoringv1alpha1.TargetStatus {
+		var rule monitoringv1alpha1.Rule
+		if err := k8sClient.Get(context.Background(), key, &rule); err != nil {
+			return nil
+		}
+		for i := range rule.Status.Targets {
+			if status := &rule.Status.Targets[i]; status.Target == target && status.ObservedGeneration == rule.Generation {
+				return status
+			}
+		}
+		return nil
+	}
+}
+
+// loadedGroups returns groups as loaded by the fake Prometheus from file
+func loadedGroups(file string, groups []render.Group) []promfake.RuleGroup {
+	loaded := make([]promfake.RuleGroup, 0, len(groups))
+	for _, group := range groups {
+		l := promfake.RuleGroup{Name: group.Name, File: file}
+		for _, rule := range group.Rules {
+			r := promfake.Rule{Name: rule.Record, Query: rule.Expr, Labels: rule.Labels, Type: "recording"}
+			if rule.Alert != "" {
+				r.Name, r.Type = rule.Alert, "alerting"
+			}
+			l.Rules = append(l.Rules, r)
+		}
+		loaded = append(loaded, l)
+	}
+	return loaded
+}
+
+var _ = Describe("Rule controller", func() {
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = newNamespace()
+	})
+
+	It("should report a valid Rule", func() {
+		rule := newRule(namespace, "valid", recording("job:up:sum", "sum by (job) (up)"))
+		Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+
+		for _, conditionType := range []string{
+			monitoringv1alpha1.ConditionInvalid,
+			monitoringv1alpha1.ConditionConflict,
+			monitoringv1alpha1.ConditionMissingDependency,
+			monitoringv1alpha1.ConditionDependencyCycle,
+			monitoringv1alpha1.ConditionQuotaExceeded,
+			monitoringv1alpha1.ConditionMergeConflict,
+			monitoringv1alpha1.ConditionStaleMetrics,
+			monitoringv1alpha1.ConditionCardinalityBudgetExceeded,
+		} {
+			Eventually(ruleCondition(keyOf(rule), conditionType), timeout).Should(Equal(metav1.ConditionFalse),
+				"condition %v", conditionType)
+		}
+		Expect(k8sClient.Get(ctx, keyOf(rule), rule)).To(Succeed())
+		Expect(rule.Status.Cardinality).NotTo(BeNil())
+		Expect(rule.Status.Cardinality.ObservedGeneration).To(Equal(rule.Generation))
+	})
+
+	It("should report an invalid Rule with an event", func() {
+		rule := newRule(namespace, "invalid", recording("job-up", "sum by (job) (up)"))
+		Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionInvalid), timeout).
+			Should(Equal(metav1.ConditionTrue))
+		Eventually(eventReasons(keyOf(rule)), timeout).Should(ContainElement("InvalidSpec"))
+
+		rule.Spec.Groups[0].Rules[0].Record = "job:up:sum"
+		Expect(k8sClient.Update(ctx, rule)).To(Succeed())
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionInvalid), timeout).
+			Should(Equal(metav1.ConditionFalse))
+	})
+
+	It("should report names already defined by an older Rule", func() {
+		older := newRule(namespace, "first", recording("job:up:sum", "sum by (job) (up)"))
+		Expect(k8sClient.Create(ctx, older)).To(Succeed())
+		Eventually(ruleCondition(keyOf(older), monitoringv1alpha1.ConditionConflict), timeout).
+			Should(Equal(metav1.ConditionFalse))
+
+		newer := newRule(namespace, "second", recording("job:up:sum", "sum by (job) (up)"))
+		Expect(k8sClient.Create(ctx, newer)).To(Succeed())
+		Eventually(ruleCondition(keyOf(newer), monitoringv1alpha1.ConditionConflict), timeout).
+			Should(Equal(metav1.ConditionTrue))
+		Consistently(ruleCondition(keyOf(older), monitoringv1alpha1.ConditionConflict)).
+			Should(Equal(metav1.ConditionFalse))
+
+		Expect(k8sClient.Delete(ctx, older)).To(Succeed())
+		Eventually(ruleCondition(keyOf(newer), monitoringv1alpha1.ConditionConflict), timeout).
+			Should(Equal(metav1.ConditionFalse))
+	})
+
+	It("should only report alert names defined with the same labels", func() {
+		alert := func(severity string) monitoringv1alpha1.RuleDefinition {
+			return monitoringv1alpha1.RuleDefinition{Alert: "Down", Expr: "up == 0",
+				Labels: map[string]string{"severity": severity}}
+		}
+		page := newRule(namespace, "page", alert("page"))
+		Expect(k8sClient.Create(ctx, page)).To(Succeed())
+		Eventually(ruleCondition(keyOf(page), monitoringv1alpha1.ConditionConflict), timeout).
+			Should(Equal(metav1.ConditionFalse))
+
+		ticket := newRule(namespace, "ticket", alert("ticket"))
+		Expect(k8sClient.Create(ctx, ticket)).To(Succeed())
+		Eventually(ruleCondition(keyOf(ticket), monitoringv1alpha1.ConditionConflict), timeout).
+			Should(Equal(metav1.ConditionFalse))
+
+		duplicate := newRule(namespace, "duplicate", alert("page"))
+		Expect(k8sClient.Create(ctx, duplicate)).To(Succeed())
+		Eventually(ruleCondition(keyOf(duplicate), monitoringv1alpha1.ConditionConflict), timeout).
+			Should(Equal(metav1.ConditionTrue))
+	})
+
+	It("should resolve the recording rules a Rule depends on", func() {
+		recorder := newRule(namespace, "recorder", recording("job:up:sum", "sum by (job) (up)"))
+		Expect(k8sClient.Create(ctx, recorder)).To(Succeed())
+		user := newRule(namespace, "user", recording("job:up:max", "max(job:up:sum) + colon:exporter:metric"))
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+		Eventually(ruleCondition(keyOf(user), monitoringv1alpha1.ConditionMissingDependency), timeout).
+			Should(Equal(metav1.ConditionFalse))
+		Expect(k8sClient.Get(ctx, keyOf(user), user)).To(Succeed())
+		Expect(user.Status.Dependencies).To(Equal([]monitoringv1alpha1.RecordReference{
+			{Record: "job:up:sum", Namespace: namespace, Name: "recorder"},
+		}))
+
+		Expect(k8sClient.Delete(ctx, recorder)).To(Succeed())
+		Eventually(ruleCondition(keyOf(user), monitoringv1alpha1.ConditionMissingDependency), timeout).
+			Should(Equal(metav1.ConditionTrue))
+		Eventually(eventReasons(keyOf(user)), timeout).Should(ContainElement("UndefinedRecord"))
+		Expect(k8sClient.Get(ctx, keyOf(user), user)).To(Succeed())
+		Expect(user.Status.MissingRecords).To(Equal([]string{"job:up:sum"}))
+	})
+
+	It("should report recording rules depending on each other", func() {
+		rule := newRule(namespace, "cycle",
+			recording("job:a:sum", "sum(job:b:sum)"),
+			recording("job:b:sum", "sum(job:a:sum)"),
+		)
+		Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionDependencyCycle), timeout).
+			Should(Equal(metav1.ConditionTrue))
+	})
+
+	It("should report metrics absent from Prometheus", func() {
+		rule := newRule(namespace, "stale", recording("job:absent_total:sum", "sum by (job) (absent_total)"))
+		Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionStaleMetrics), timeout).
+			Should(Equal(metav1.ConditionTrue))
+
+		prom.SetMetrics("up", "absent_total")
+		defer prom.SetMetrics("up")
+		// metrics are checked again at each check interval
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionStaleMetrics), timeout+checkInterval).
+			Should(Equal(metav1.ConditionFalse))
+	})
+
+	It("should enforce the RuleQuotas of the namespace", func() {
+		maxRules := int32(1)
+		quota := &monitoringv1alpha1.RuleQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "quota"},
+			Spec:       monitoringv1alpha1.RuleQuotaSpec{MaxRules: &maxRules},
+		}
+		Expect(k8sClient.Create(ctx, quota)).To(Succeed())
+
+		rule := newRule(namespace, "big",
+			recording("job:up:sum", "sum by (job) (up)"),
+			recording("job:up:max", "max by (job) (up)"),
+		)
+		Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionQuotaExceeded), timeout).
+			Should(Equal(metav1.ConditionTrue))
+		Eventually(eventReasons(keyOf(rule)), timeout).Should(ContainElement("QuotaExceeded"))
+		Eventually(func() monitoringv1alpha1.RuleQuotaUsage {
+			_ = k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "quota"}, quota)
+			return quota.Status.Used
+		}, timeout).Should(Equal(monitoringv1alpha1.RuleQuotaUsage{Groups: 1, Rules: 2}))
+
+		maxRules = 2
+		quota.Spec.MaxRules = &maxRules
+		Expect(k8sClient.Update(ctx, quota)).To(Succeed())
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionQuotaExceeded), timeout).
+			Should(Equal(metav1.ConditionFalse))
+	})
+
+	It("should measure the cardinality against the budget", func() {
+		prom.SetResult("count(sum by (instance) (up))",
+			promfake.Series{Points: []promfake.Point{{Timestamp: time.Now(), Value: 150}}})
+		rule := newRule(namespace, "cardinality", recording("instance:up:sum", "sum by (instance) (up)"))
+		Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionCardinalityBudgetExceeded), timeout).
+			Should(Equal(metav1.ConditionTrue))
+		Expect(k8sClient.Get(ctx, keyOf(rule), rule)).To(Succeed())
+		Expect(rule.Status.Cardinality.Series).To(Equal(150))
+		Eventually(eventReasons(keyOf(rule)), timeout).Should(ContainElement("BudgetExceeded"))
+	})
+
+	It("should reconcile the Rules when the settings are reloaded", func() {
+		prom.SetResult("count(sum by (pod) (up))",
+			promfake.Series{Points: []promfake.Point{{Timestamp: time.Now(), Value: 50}}})
+		rule := newRule(namespace, "reload", recording("pod:up:sum", "sum by (pod) (up)"))
+		Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionCardinalityBudgetExceeded), timeout).
+			Should(Equal(metav1.ConditionFalse))
+
+		previous := config.Get()
+		defer config.Set(previous)
+		reloaded := rulesConfig()
+		reloaded.Prometheus.NamespaceCardinalityBudgets = map[string]int{namespace: 10}
+		// a long check interval ensures the reload triggers the reconciliation
+		reloaded.Prometheus.CheckInterval = &metav1.Duration{Duration: time.Hour}
+		settings, err := operatorconfig.New(reloaded, nil)
+		Expect(err).NotTo(HaveOccurred())
+		config.Set(settings)
+
+		Eventually(ruleCondition(keyOf(rule), monitoringv1alpha1.ConditionCardinalityBudgetExceeded), timeout).
+			Should(Equal(metav1.ConditionTrue))
+	})
+
+	It("should merge the groups sharing a merge key", func() {
+		first := newRule(namespace, "first", recording("job:up:sum", "sum by (job) (up)"))
+		first.Spec.Groups[0].MergeKey = "shared"
+		second := newRule(namespace, "second", recording("job:up:max", "max by (job) (up)"))
+		second.Spec.Groups[0].MergeKey = "shared"
+		second.Spec.Groups[0].Priority = -1
+		second.Spec.Groups[0].Interval = "30s"
+		Expect(k8sClient.Create(ctx, first)).To(Succeed())
+		Expect(k8sClient.Create(ctx, second)).To(Succeed())
+
+		Eventually(func() []monitoringv1alpha1.MergedGroupStatus {
+			_ = k8sClient.Get(ctx, keyOf(first), first)
+			return first.Status.MergedGroups
+		}, timeout).Should(Equal([]monitoringv1alpha1.MergedGroupStatus{{
+			Group:        "first",
+			MergedGroup:  "shared",
+			Position:     1,
+			Contributors: []string{namespace + "/second/second", namespace + "/first/first"},
+		}}))
+		Eventually(ruleCondition(keyOf(first), monitoringv1alpha1.ConditionMergeConflict), timeout).
+			Should(Equal(metav1.ConditionTrue))
+
+		Expect(k8sClient.Get(ctx, keyOf(second), second)).To(Succeed())
+		second.Spec.Groups[0].Interval = ""
+		Expect(k8sClient.Update(ctx, second)).To(Succeed())
+		Eventually(ruleCondition(keyOf(first), monitoringv1alpha1.ConditionMergeConflict), timeout).
+			Should(Equal(metav1.ConditionFalse))
+	})
+
+	Context("with a PrometheusTarget", func() {
+		var target *monitoringv1alpha1.PrometheusTarget
+
+		BeforeEach(func() {
+			// the selector keeps the Rules of the other specs out
+			target = &monitoringv1alpha1.PrometheusTarget{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "main"},
+				Spec: monitoringv1alpha1.PrometheusTargetSpec{
+					URL:            prom.URL,
+					Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"target": namespace}},
+					Output:         monitoringv1alpha1.Output{ConfigMap: monitoringv1alpha1.ConfigMapOutput{Name: "rules"}},
+					ExternalLabels: map[string]string{"cluster": "test"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, target)).To(Succeed())
+		})
+
+		It("should render the selected Rules and report them loaded", func() {
+			rule := newRule(namespace, "selected", recording("job:up:sum", "sum by (job) (up)"))
+			rule.Labels = map[string]string{"target": namespace}
+			Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+			ignored := newRule(namespace, "ignored", recording("job:up:max", "max by (job) (up)"))
+			Expect(k8sClient.Create(ctx, ignored)).To(Succeed())
+
+			shard := types.NamespacedName{Namespace: namespace, Name: "rules-0"}
+			expected := render.WithExternalLabels(render.Groups(rule), target.Spec.ExternalLabels)
+			Eventually(shardGroups(shard), timeout).Should(Equal(expected))
+			var configMap corev1.ConfigMap
+			Expect(k8sClient.Get(ctx, shard, &configMap)).To(Succeed())
+			Expect(metav1.IsControlledBy(&configMap, target)).To(BeTrue())
+			Expect(configMap.Labels).To(HaveKeyWithValue(output.OutputLabel, output.TargetOutput))
+
+			targetName := namespace + "/main"
+			Eventually(targetStatus(keyOf(rule), targetName), timeout+checkInterval).Should(And(
+				Not(BeNil()),
+				WithTransform(func(s *monitoringv1alpha1.TargetStatus) bool { return s.Rendered }, BeTrue()),
+			))
+			status := targetStatus(keyOf(rule), targetName)()
+			Expect(status.Shard).To(Equal(shard.String()))
+			Expect(status.Loaded).To(BeFalse())
+			Expect(status.Message).To(HavePrefix("not loaded: "))
+			Expect(targetStatus(keyOf(ignored), targetName)()).To(BeNil())
+
+			rulesRequests := prom.Requests("/api/v1/rules")
+			prom.SetRuleGroups(loadedGroups("/etc/prometheus/rules/rules.yaml", expected)...)
+			defer prom.SetRuleGroups()
+			prom.SetReload(true)
+			Eventually(func() bool {
+				status := targetStatus(keyOf(rule), targetName)()
+				return status != nil && status.Loaded
+			}, timeout+checkInterval).Should(BeTrue())
+			Expect(prom.Requests("/api/v1/rules")).To(BeNumerically(">", rulesRequests))
+			// the reloads of the target are observed at each of its checks
+			Expect(prom.Requests("/api/v1/status/runtimeinfo")).To(BeNumerically(">", 0))
+		})
+
+		It("should delete a Rule without finalizer and remove its groups", func() {
+			rule := newRule(namespace, "deleted", recording("job:up:sum", "sum by (job) (up)"))
+			rule.Labels = map[string]string{"target": namespace}
+			Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+			shard := types.NamespacedName{Namespace: namespace, Name: "rules-0"}
+			Eventually(shardGroups(shard), timeout).Should(HaveLen(1))
+			Eventually(targetStatus(keyOf(rule), namespace+"/main"), timeout+checkInterval).ShouldNot(BeNil())
+
+			// no controller sets a finalizer on Rules, nothing holds their
+			// deletion
+			Expect(k8sClient.Get(ctx, keyOf(rule), rule)).To(Succeed())
+			Expect(rule.Finalizers).To(BeEmpty())
+			Expect(k8sClient.Delete(ctx, rule)).To(Succeed())
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, keyOf(rule), rule))
+			}, timeout).Should(BeTrue())
+			Eventually(shardGroups(shard), timeout).Should(BeEmpty())
+		})
+	})
+
+	It("should render every Rule into the default output", func() {
+		rule := newRule(namespace, "default", recording("job:up:sum", "sum by (job) (up)"))
+		Expect(k8sClient.Create(ctx, rule)).To(Succeed())
+
+		previous := config.Get()
+		defer config.Set(previous)
+		reloaded := rulesConfig()
+		reloaded.Labels = map[string]string{"source": "operator"}
+		reloaded.Output = &configv1alpha1.OutputConfig{
+			Namespace: namespace,
+			Output:    monitoringv1alpha1.Output{ConfigMap: monitoringv1alpha1.ConfigMapOutput{Name: "default-rules"}},
+		}
+		settings, err := operatorconfig.New(reloaded, nil)
+		Expect(err).NotTo(HaveOccurred())
+		config.Set(settings)
+
+		shard := types.NamespacedName{Namespace: namespace, Name: "default-rules-0"}
+		expected := render.Groups(settings.Inject(rule))
+		Eventually(shardGroups(shard), timeout).Should(ContainElement(expected[0]))
+		var configMap corev1.ConfigMap
+		Expect(k8sClient.Get(ctx, shard, &configMap)).To(Succeed())
+		Expect(configMap.Labels).To(HaveKeyWithValue(output.OutputLabel, output.DefaultOutput))
+	})
+})
@@ -0,0 +1,105 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/quota"
+)
+
+// RuleQuotaReconciler reconciles a RuleQuota object
+type RuleQuotaReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Options configures the concurrency and rate limiting of the controller
+	Options controller.Options
+}
+
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=rulequotas,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=rulequotas/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=rulequotas/finalizers,verbs=update
+
+// Reconcile reports in the RuleQuota status the number of groups and rules
+// defined by the Rules of its namespace.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *RuleQuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var q monitoringv1alpha1.RuleQuota
+	if err := r.Get(ctx, req.NamespacedName, &q); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var rules monitoringv1alpha1.RuleList
+	if err := r.List(ctx, &rules, client.InNamespace(q.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	items := make([]*monitoringv1alpha1.Rule, 0, len(rules.Items))
+	for i := range rules.Items {
+		items = append(items, &rules.Items[i])
+	}
+
+	used := quota.Usage(items...)
+	if used == q.Status.Used {
+		return ctrl.Result{}, nil
+	}
+	q.Status.Used = used
+	if err := r.Status().Update(ctx, &q); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RuleQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&monitoringv1alpha1.RuleQuota{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WithOptions(r.Options).
+		Watches(&source.Kind{Type: &monitoringv1alpha1.Rule{}}, enqueueCoalescedRequestsFromMapFunc(r.namespaceQuotas),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
+
+// namespaceQuotas maps a Rule to the RuleQuotas of its namespace
+func (r *RuleQuotaReconciler) namespaceQuotas(obj client.Object) []reconcile.Request {
+	var quotas monitoringv1alpha1.RuleQuotaList
+	if err := r.List(context.Background(), &quotas, client.InNamespace(obj.GetNamespace())); err != nil {
+		ctrl.Log.WithName("controllers").WithName("RuleQuota").Error(err, "unable to list rule quotas",
+			"namespace", obj.GetNamespace())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(quotas.Items))
+	for _, q := range quotas.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: q.Namespace, Name: q.Name},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/alertmanager"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+)
+
+// silenceFinalizer expires the silence in Alertmanager before the Silence is
+// deleted
+const silenceFinalizer = "monitoring.cyrilix.fr/silence"
+
+// silenceResyncPeriod is the period at which silences are checked in
+// Alertmanager, to recreate the ones lost by a restart without persistence
+const silenceResyncPeriod = 10 * time.Minute
+
+// SilenceReconciler reconciles a Silence object
+type SilenceReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Config holds the operator settings, Silences are reconciled again
+	// whenever they are reloaded
+	Config *operatorconfig.Store
+
+	// Options configures the concurrency and rate limiting of the controller
+	Options controller.Options
+}
+
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=silences,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=silences/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=monitoring.cyrilix.fr,resources=silences/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile creates or updates the silence in Alertmanager whenever the
+// Silence changes, tracks its ID and state in the Silence status and expires
+// it when the Silence is deleted. Expired Silences are only deleted when a
+// retention is configured, they are left to their owner otherwise.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *SilenceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var silence monitoringv1alpha1.Silence
+	if err := r.Get(ctx, req.NamespacedName, &silence); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	settings := r.Config.Get()
+
+	if !silence.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&silence, silenceFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if silence.Status.ID != "" {
+			if settings.Alertmanager == nil {
+				// Keep the finalizer until the silence can be expired, it
+				// would be left active in Alertmanager otherwise
+				r.Recorder.Eventf(&silence, corev1.EventTypeWarning, "AlertmanagerNotConfigured",
+					"silence %v can't be expired until an Alertmanager is configured for the operator", silence.Status.ID)
+				return ctrl.Result{RequeueAfter: time.Minute}, nil
+			}
+			if err := expireSilence(ctx, settings.Alertmanager, silence.Status.ID); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(&silence, silenceFinalizer)
+		return ctrl.Result{}, client.IgnoreNotFound(r.Update(ctx, &silence))
+	}
+	if !controllerutil.ContainsFinalizer(&silence, silenceFinalizer) {
+		controllerutil.AddFinalizer(&silence, silenceFinalizer)
+		if err := r.Update(ctx, &silence); err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+
+	now := time.Now()
+	if retention := settings.ExpiredSilenceRetention; retention != nil &&
+		silence.Status.State == monitoringv1alpha1.SilenceStateExpired &&
+		now.After(silence.Spec.EndsAt.Add(*retention)) {
+		log.FromContext(ctx).Info("deleting expired silence")
+		return ctrl.Result{}, client.IgnoreNotFound(r.Delete(ctx, &silence))
+	}
+
+	status := silence.Status.DeepCopy()
+	result, err := r.sync(ctx, settings, &silence, now)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !equality.Semantic.DeepEqual(status, &silence.Status) {
+		if err := r.Status().Update(ctx, &silence); err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+	return result, nil
+}
+
+// sync sends silence to Alertmanager when it changed, refreshes its state and
+// sets its SyncFailed condition. The result requeues silence at its next
+// state change.
+func (r *SilenceReconciler) sync(ctx context.Context, settings *operatorconfig.Settings, silence *monitoringv1alpha1.Silence, now time.Time) (ctrl.Result, error) {
+	failed := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionSyncFailed,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: silence.Generation,
+		Reason:             "Synced",
+	}
+	am := settings.Alertmanager
+	if am == nil {
+		failed.Status = metav1.ConditionTrue
+		failed.Reason = "AlertmanagerNotConfigured"
+		failed.Message = "no Alertmanager is configured for the operator"
+		meta.SetStatusCondition(&silence.Status.Conditions, failed)
+		return ctrl.Result{}, nil
+	}
+	failed.Message = "silence is synchronized with " + am.Address()
+
+	var apiErr *alertmanager.APIError
+	if silence.Status.ID != "" {
+		s, err := am.GetSilence(ctx, silence.Status.ID)
+		switch {
+		case alertmanager.IsNotFound(err):
+			log.FromContext(ctx).Info("silence not found in Alertmanager, creating it again", "id", silence.Status.ID)
+			silence.Status.ID = ""
+			silence.Status.ObservedGeneration = 0
+		case err != nil:
+			return r.syncFailed(silence, failed, "AlertmanagerUnavailable", err)
+		default:
+			silence.Status.State = monitoringv1alpha1.SilenceState(s.Status.State)
+		}
+	}
+
+	endsAt := silence.Spec.EndsAt.Time
+	if silence.Status.ObservedGeneration != silence.Generation {
+		if endsAt.After(now) {
+			id, err := am.PostSilence(ctx, toAlertmanagerSilence(silence))
+			switch {
+			case errors.As(err, &apiErr):
+				return r.syncFailed(silence, failed, "Rejected", errors.New(apiErr.Message))
+			case err != nil:
+				return r.syncFailed(silence, failed, "AlertmanagerUnavailable", err)
+			}
+			silence.Status.ID = id
+			silence.Status.State = monitoringv1alpha1.SilenceStatePending
+			if !silenceStartsAt(silence).After(now) {
+				silence.Status.State = monitoringv1alpha1.SilenceStateActive
+			}
+		} else {
+			if silence.Status.ID != "" {
+				if err := expireSilence(ctx, am, silence.Status.ID); err != nil {
+					return r.syncFailed(silence, failed, "AlertmanagerUnavailable", err)
+				}
+			}
+			silence.Status.State = monitoringv1alpha1.SilenceStateExpired
+		}
+		silence.Status.ObservedGeneration = silence.Generation
+	}
+	meta.SetStatusCondition(&silence.Status.Conditions, failed)
+
+	var next time.Time
+	switch silence.Status.State {
+	case monitoringv1alpha1.SilenceStatePending:
+		next = silenceStartsAt(silence)
+	case monitoringv1alpha1.SilenceStateActive:
+		next = endsAt
+	default:
+		if retention := settings.ExpiredSilenceRetention; retention != nil {
+			next = endsAt.Add(*retention)
+		}
+	}
+	result := ctrl.Result{RequeueAfter: silenceResyncPeriod}
+	if wait := next.Sub(now); !next.IsZero() && wait < result.RequeueAfter {
+		if wait < 0 {
+			wait = 0
+		}
+		result.RequeueAfter = wait + time.Second
+	}
+	return result, nil
+}
+
+// syncFailed sets the SyncFailed condition of silence for err, retrying
+// later when Alertmanager is unavailable
+func (r *SilenceReconciler) syncFailed(silence *monitoringv1alpha1.Silence, failed metav1.Condition, reason string, err error) (ctrl.Result, error) {
+	failed.Status = metav1.ConditionTrue
+	failed.Reason = reason
+	failed.Message = err.Error()
+	if !meta.IsStatusConditionTrue(silence.Status.Conditions, monitoringv1alpha1.ConditionSyncFailed) {
+		r.Recorder.Event(silence, corev1.EventTypeWarning, reason, failed.Message)
+	}
+	meta.SetStatusCondition(&silence.Status.Conditions, failed)
+	if reason == "AlertmanagerUnavailable" {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// expireSilence expires the silence with id unless it is already expired or
+// missing
+func expireSilence(ctx context.Context, am *alertmanager.Client, id string) error {
+	s, err := am.GetSilence(ctx, id)
+	switch {
+	case alertmanager.IsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	case s.Status != nil && s.Status.State == string(monitoringv1alpha1.SilenceStateExpired):
+		return nil
+	}
+	if err := am.DeleteSilence(ctx, id); err != nil && !alertmanager.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// silenceStartsAt returns when silence starts, defaulting to its creation
+func silenceStartsAt(silence *monitoringv1alpha1.Silence) time.Time {
+	if silence.Spec.StartsAt != nil {
+		return silence.Spec.StartsAt.Time
+	}
+	return silence.CreationTimestamp.Time
+}
+
+// toAlertmanagerSilence returns the silence of the Alertmanager API for
+// silence, updating the one it tracks
+func toAlertmanagerSilence(silence *monitoringv1alpha1.Silence) *alertmanager.Silence {
+	s := &alertmanager.Silence{
+		ID:        silence.Status.ID,
+		StartsAt:  silenceStartsAt(silence),
+		EndsAt:    silence.Spec.EndsAt.Time,
+		CreatedBy: silence.Spec.CreatedBy,
+		Comment:   silence.Spec.Comment,
+	}
+	for _, m := range silence.Spec.Matchers {
+		matcher := alertmanager.APIMatcher{Name: m.Name, Value: m.Value, IsRegex: m.IsRegex}
+		if m.IsNegative {
+			isEqual := false
+			matcher.IsEqual = &isEqual
+		}
+		s.Matchers = append(s.Matchers, matcher)
+	}
+	return s
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SilenceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&monitoringv1alpha1.Silence{}).
+		WithOptions(r.Options).
+		Watches(&source.Channel{Source: r.Config.Subscribe()}, handler.EnqueueRequestsFromMapFunc(r.allSilences)).
+		Complete(r)
+}
+
+// allSilences maps a reload of the operator settings to every Silence
+func (r *SilenceReconciler) allSilences(client.Object) []reconcile.Request {
+	var silences monitoringv1alpha1.SilenceList
+	if err := r.List(context.Background(), &silences); err != nil {
+		ctrl.Log.WithName("controllers").WithName("Silence").Error(err, "unable to list silences")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(silences.Items))
+	for _, s := range silences.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: s.Namespace, Name: s.Name},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	amfake "github.com/cyrilix/prometheus-rules-operator/pkg/alertmanager/fake"
+)
+
+// amSilence returns a function getting the silence of the fake Alertmanager
+// with id, for Eventually
+func amSilence(id string) func() *amfake.Silence {
+	return func() *amfake.Silence {
+		for _, s := range am.Silences() {
+			if s.ID == id {
+				return &s
+			}
+		}
+		return nil
+	}
+}
+
+var _ = Describe("Silence controller", func() {
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = newNamespace()
+	})
+
+	It("should create the silence in Alertmanager and expire it on deletion", func() {
+		silence := &monitoringv1alpha1.Silence{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "maintenance"},
+			Spec: monitoringv1alpha1.SilenceSpec{
+				Matchers:  []monitoringv1alpha1.Matcher{{Name: "alertname", Value: "Down"}},
+				EndsAt:    metav1.NewTime(time.Now().Add(time.Hour)),
+				CreatedBy: "tests",
+				Comment:   "maintenance",
+			},
+		}
+		key := types.NamespacedName{Namespace: namespace, Name: "maintenance"}
+		Expect(k8sClient.Create(ctx, silence)).To(Succeed())
+
+		Eventually(func() monitoringv1alpha1.SilenceState {
+			_ = k8sClient.Get(ctx, key, silence)
+			return silence.Status.State
+		}, timeout).Should(Equal(monitoringv1alpha1.SilenceStateActive))
+		Expect(controllerutil.ContainsFinalizer(silence, silenceFinalizer)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(silence.Status.Conditions, monitoringv1alpha1.ConditionSyncFailed)).
+			To(BeTrue())
+		id := silence.Status.ID
+		Expect(amSilence(id)()).NotTo(BeNil())
+		Expect(amSilence(id)().Comment).To(Equal("maintenance"))
+
+		silence.Spec.Comment = "extended maintenance"
+		Expect(k8sClient.Update(ctx, silence)).To(Succeed())
+		Eventually(func() string {
+			_ = k8sClient.Get(ctx, key, silence)
+			if s := amSilence(silence.Status.ID)(); s != nil && s.State(time.Now()) == "active" {
+				return s.Comment
+			}
+			return ""
+		}, timeout).Should(Equal("extended maintenance"))
+
+		id = silence.Status.ID
+		Expect(k8sClient.Delete(ctx, silence)).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, silence))
+		}, timeout).Should(BeTrue())
+		Expect(amSilence(id)().State(time.Now())).To(Equal("expired"))
+	})
+
+	It("should recreate the silence lost by Alertmanager", func() {
+		silence := &monitoringv1alpha1.Silence{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "lost"},
+			Spec: monitoringv1alpha1.SilenceSpec{
+				Matchers:  []monitoringv1alpha1.Matcher{{Name: "alertname", Value: "Lost"}},
+				EndsAt:    metav1.NewTime(time.Now().Add(time.Hour)),
+				CreatedBy: "tests",
+				Comment:   "lost",
+			},
+		}
+		key := types.NamespacedName{Namespace: namespace, Name: "lost"}
+		Expect(k8sClient.Create(ctx, silence)).To(Succeed())
+		Eventually(func() string {
+			_ = k8sClient.Get(ctx, key, silence)
+			return silence.Status.ID
+		}, timeout).ShouldNot(BeEmpty())
+
+		am.Clear()
+		// touching the Silence triggers its reconciliation
+		silence.Labels = map[string]string{"touched": "true"}
+		Expect(k8sClient.Update(ctx, silence)).To(Succeed())
+		Eventually(func() *amfake.Silence {
+			_ = k8sClient.Get(ctx, key, silence)
+			return amSilence(silence.Status.ID)()
+		}, timeout).ShouldNot(BeNil())
+	})
+
+	It("should keep expired Silences by default", func() {
+		silence := &monitoringv1alpha1.Silence{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "expired"},
+			Spec: monitoringv1alpha1.SilenceSpec{
+				Matchers:  []monitoringv1alpha1.Matcher{{Name: "alertname", Value: "Expired"}},
+				EndsAt:    metav1.NewTime(time.Now().Add(-time.Hour)),
+				CreatedBy: "tests",
+				Comment:   "expired",
+			},
+		}
+		key := types.NamespacedName{Namespace: namespace, Name: "expired"}
+		Expect(k8sClient.Create(ctx, silence)).To(Succeed())
+		Eventually(func() monitoringv1alpha1.SilenceState {
+			_ = k8sClient.Get(ctx, key, silence)
+			return silence.Status.State
+		}, timeout).Should(Equal(monitoringv1alpha1.SilenceStateExpired))
+		Consistently(func() error {
+			return k8sClient.Get(ctx, key, silence)
+		}).Should(Succeed())
+	})
+})
+
+var _ = Describe("InhibitRule controller", func() {
+	It("should report invalid matchers", func() {
+		ctx := context.Background()
+		namespace := newNamespace()
+		inhibitRule := &monitoringv1alpha1.InhibitRule{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "inhibit"},
+			Spec: monitoringv1alpha1.InhibitRuleSpec{
+				SourceMatchers: []monitoringv1alpha1.Matcher{{Name: "severity", Value: "critical"}},
+				TargetMatchers: []monitoringv1alpha1.Matcher{{Name: "severity", Value: "(", IsRegex: true}},
+			},
+		}
+		key := types.NamespacedName{Namespace: namespace, Name: "inhibit"}
+		Expect(k8sClient.Create(ctx, inhibitRule)).To(Succeed())
+
+		Eventually(func() bool {
+			_ = k8sClient.Get(ctx, key, inhibitRule)
+			return meta.IsStatusConditionTrue(inhibitRule.Status.Conditions, monitoringv1alpha1.ConditionInvalid)
+		}, timeout).Should(BeTrue())
+
+		inhibitRule.Spec.TargetMatchers[0].Value = "warning|info"
+		Expect(k8sClient.Update(ctx, inhibitRule)).To(Succeed())
+		Eventually(func() bool {
+			_ = k8sClient.Get(ctx, key, inhibitRule)
+			return meta.IsStatusConditionFalse(inhibitRule.Status.Conditions, monitoringv1alpha1.ConditionInvalid)
+		}, timeout).Should(BeTrue())
+	})
+})
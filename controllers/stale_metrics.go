@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+)
+
+// syntheticMetrics are generated by Prometheus for alerting rules and only
+// exist while alerts are pending or firing
+var syntheticMetrics = map[string]struct{}{
+	"ALERTS":           {},
+	"ALERTS_FOR_STATE": {},
+}
+
+// checkStaleMetrics sets the StaleMetrics condition of rule according to the
+// metrics known by the Prometheus server of settings. Metrics recorded by a Rule are not checked
+// since they are only available once the Rule is loaded.
+func (r *RuleReconciler) checkStaleMetrics(ctx context.Context, settings *operatorconfig.Settings, rule *monitoringv1alpha1.Rule) error {
+	_, undefined, err := r.resolveDependencies(ctx, rule)
+	if err != nil {
+		return err
+	}
+	var metrics []string
+	for _, m := range undefined {
+		if _, ok := syntheticMetrics[m]; !ok {
+			metrics = append(metrics, m)
+		}
+	}
+
+	staleMetrics := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionStaleMetrics,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "MetricsPresent",
+		Message:            "all metrics used by expressions exist in " + settings.Prometheus.Address(),
+	}
+	if len(metrics) > 0 {
+		existing, err := settings.Prometheus.LabelValues(ctx, "__name__",
+			fmt.Sprintf(`{__name__=~%q}`, strings.Join(metrics, "|")))
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to check metrics", "prometheus", settings.Prometheus.Address())
+			staleMetrics.Status = metav1.ConditionUnknown
+			staleMetrics.Reason = "PrometheusUnavailable"
+			staleMetrics.Message = err.Error()
+			meta.SetStatusCondition(&rule.Status.Conditions, staleMetrics)
+			return nil
+		}
+
+		found := make(map[string]struct{}, len(existing))
+		for _, m := range existing {
+			found[m] = struct{}{}
+		}
+		var absent []string
+		for _, m := range metrics {
+			if _, ok := found[m]; !ok {
+				absent = append(absent, m)
+			}
+		}
+		if len(absent) > 0 {
+			staleMetrics.Status = metav1.ConditionTrue
+			staleMetrics.Reason = "AbsentMetrics"
+			staleMetrics.Message = fmt.Sprintf("metrics absent from %v: %v",
+				settings.Prometheus.Address(), strings.Join(absent, ", "))
+		}
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, staleMetrics)
+	return nil
+}
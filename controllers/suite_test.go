@@ -17,29 +17,60 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
 	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	monitoringv1beta1 "github.com/cyrilix/prometheus-rules-operator/api/v1beta1"
+	amfake "github.com/cyrilix/prometheus-rules-operator/pkg/alertmanager/fake"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+	promfake "github.com/cyrilix/prometheus-rules-operator/pkg/prometheus/fake"
 	//+kubebuilder:scaffold:imports
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
 // http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
 
+const (
+	// timeout is how long the specs wait for the controllers
+	timeout = 10 * time.Second
+	// checkInterval is the period at which Rules are checked against the
+	// fake Prometheus
+	checkInterval = 2 * time.Second
+)
+
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var cancel context.CancelFunc
+
+// prom and am are the fake servers the controllers are configured with,
+// config holds the controllers settings
+var (
+	prom   *promfake.Prometheus
+	am     *amfake.Alertmanager
+	config *operatorconfig.Store
+)
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -49,6 +80,19 @@ func TestAPIs(t *testing.T) {
 		[]Reporter{printer.NewlineReporter{}})
 }
 
+// rulesConfig returns the configuration of the controllers settings
+func rulesConfig() *configv1alpha1.RulesConfig {
+	budget := 100
+	return &configv1alpha1.RulesConfig{
+		Prometheus: configv1alpha1.PrometheusConfig{
+			URL:               prom.URL,
+			CheckInterval:     &metav1.Duration{Duration: checkInterval},
+			CardinalityBudget: &budget,
+		},
+		Alertmanager: configv1alpha1.AlertmanagerConfig{URL: am.URL},
+	}
+}
+
 var _ = BeforeSuite(func() {
 	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
 
@@ -58,12 +102,15 @@ var _ = BeforeSuite(func() {
 		ErrorIfCRDPathMissing: true,
 	}
 
-	cfg, err := testEnv.Start()
+	var err error
+	cfg, err = testEnv.Start()
 	Expect(err).NotTo(HaveOccurred())
 	Expect(cfg).NotTo(BeNil())
 
 	err = monitoringv1alpha1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = monitoringv1beta1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:scheme
 
@@ -71,10 +118,119 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	By("starting the controllers")
+	prom = promfake.NewPrometheus("up")
+	am = amfake.NewAlertmanager()
+	settings, err := operatorconfig.New(rulesConfig(), nil)
+	Expect(err).NotTo(HaveOccurred())
+	config = operatorconfig.NewStore(settings)
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{Scheme: scheme.Scheme, MetricsBindAddress: "0"})
+	Expect(err).NotTo(HaveOccurred())
+	httpClients := httpconfig.NewClients(mgr.GetClient())
+	targetRules := NewTargetRules(httpClients)
+	outputLocks := output.NewLocks()
+	Expect((&RuleReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("rule-controller"),
+		Config:      config,
+		TargetRules: targetRules,
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&RuleQuotaReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&PrometheusTargetReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("prometheustarget-controller"),
+		Config:      config,
+		HTTPClients: httpClients,
+		TargetRules: targetRules,
+		Locks:       outputLocks,
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&OutputReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("output-controller"),
+		Config:   config,
+		Locks:    outputLocks,
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&SilenceReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("silence-controller"),
+		Config:   config,
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&InhibitRuleReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("inhibitrule-controller"),
+		Config:   config,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	// BeforeSuite may have failed before starting everything
+	if cancel != nil {
+		cancel()
+	}
+	if prom != nil {
+		prom.Close()
+	}
+	if am != nil {
+		am.Close()
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+// newNamespace creates a namespace isolating the objects of a spec
+func newNamespace() string {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"}}
+	ExpectWithOffset(1, k8sClient.Create(context.Background(), ns)).To(Succeed())
+	return ns.Name
+}
+
+// ruleCondition returns a function getting the status of the condition of
+// the Rule named key, for Eventually
+func ruleCondition(key types.NamespacedName, conditionType string) func() metav1.ConditionStatus {
+	return func() metav1.ConditionStatus {
+		var rule monitoringv1alpha1.Rule
+		if err := k8sClient.Get(context.Background(), key, &rule); err != nil {
+			return ""
+		}
+		condition := meta.FindStatusCondition(rule.Status.Conditions, conditionType)
+		if condition == nil || condition.ObservedGeneration != rule.Generation {
+			return ""
+		}
+		return condition.Status
+	}
+}
+
+// eventReasons returns a function listing the reasons of the events of the
+// object named key, for Eventually
+func eventReasons(key types.NamespacedName) func() []string {
+	return func() []string {
+		var events corev1.EventList
+		if err := k8sClient.List(context.Background(), &events, client.InNamespace(key.Namespace)); err != nil {
+			return nil
+		}
+		var reasons []string
+		for _, event := range events.Items {
+			if event.InvolvedObject.Name == key.Name {
+				reasons = append(reasons, event.Reason)
+			}
+		}
+		return reasons
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/prometheus"
+)
+
+// TargetRules caches the rule groups loaded by each PrometheusTarget, so that
+// the Rules it selects are checked against a single call of its rules API
+// instead of one call per Rule. Entries are refreshed by the reconciles of the
+// PrometheusTarget and expire after a TTL. It is safe for concurrent use.
+type TargetRules struct {
+	clients *httpconfig.Clients
+
+	mu      sync.Mutex
+	entries map[types.NamespacedName]*targetRulesEntry
+}
+
+// targetRulesEntry holds the result of the last call of the rules API of a
+// PrometheusTarget. Its mutex is held while calling the API so that
+// concurrent readers wait for a single call.
+type targetRulesEntry struct {
+	mu         sync.Mutex
+	generation int64
+	fetched    time.Time
+	groups     []prometheus.RuleGroup
+	err        error
+}
+
+// NewTargetRules returns TargetRules calling the rules APIs with the HTTP
+// clients of clients
+func NewTargetRules(clients *httpconfig.Clients) *TargetRules {
+	return &TargetRules{clients: clients, entries: map[types.NamespacedName]*targetRulesEntry{}}
+}
+
+// Get returns the rule groups loaded by target, or the error of its rules API,
+// fetched less than ttl ago for the current generation of target
+func (t *TargetRules) Get(ctx context.Context, target *monitoringv1alpha1.PrometheusTarget, ttl time.Duration) ([]prometheus.RuleGroup, error) {
+	entry := t.entry(target)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.fetched.IsZero() || entry.generation != target.Generation || time.Since(entry.fetched) >= ttl {
+		entry.fetch(ctx, t.clients, target)
+	}
+	return entry.groups, entry.err
+}
+
+// Refresh calls the rules API of target and caches its result
+func (t *TargetRules) Refresh(ctx context.Context, target *monitoringv1alpha1.PrometheusTarget) ([]prometheus.RuleGroup, error) {
+	entry := t.entry(target)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.fetch(ctx, t.clients, target)
+	return entry.groups, entry.err
+}
+
+// Forget drops the rule groups of the PrometheusTarget key
+func (t *TargetRules) Forget(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+// entry returns the entry of target, creating it when missing
+func (t *TargetRules) entry(target *monitoringv1alpha1.PrometheusTarget) *targetRulesEntry {
+	key := types.NamespacedName{Namespace: target.Namespace, Name: target.Name}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.entries[key]
+	if !ok {
+		entry = &targetRulesEntry{}
+		t.entries[key] = entry
+	}
+	return entry
+}
+
+// fetch calls the rules API of target, the mutex of the entry must be held
+func (e *targetRulesEntry) fetch(ctx context.Context, clients *httpconfig.Clients, target *monitoringv1alpha1.PrometheusTarget) {
+	e.groups, e.err = nil, nil
+	client, err := targetClient(ctx, clients, target)
+	if err == nil {
+		e.groups, err = client.Rules(ctx)
+	}
+	e.err = err
+	e.generation = target.Generation
+	e.fetched = time.Now()
+}
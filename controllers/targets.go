@@ -0,0 +1,468 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/prometheus"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/validation"
+)
+
+// targetSelects reports whether target selects rule, reading the labels of
+// its namespace with c when target has a namespace selector
+func targetSelects(ctx context.Context, c client.Reader, target *monitoringv1alpha1.PrometheusTarget, rule *monitoringv1alpha1.Rule) (bool, error) {
+	for _, s := range []struct {
+		selector *metav1.LabelSelector
+		labels   func() (map[string]string, error)
+	}{
+		{target.Spec.Selector, func() (map[string]string, error) { return rule.Labels, nil }},
+		{target.Spec.NamespaceSelector, func() (map[string]string, error) {
+			var namespace corev1.Namespace
+			if err := c.Get(ctx, types.NamespacedName{Name: rule.Namespace}, &namespace); err != nil {
+				return nil, fmt.Errorf("unable to get namespace %v: %w", rule.Namespace, err)
+			}
+			return namespace.Labels, nil
+		}},
+	} {
+		if s.selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(s.selector)
+		if err != nil {
+			return false, fmt.Errorf("invalid selector of target %v/%v: %w", target.Namespace, target.Name, err)
+		}
+		values, err := s.labels()
+		if err != nil {
+			return false, err
+		}
+		if !selector.Matches(labels.Set(values)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// HandledRules returns the Rules selected by the operator settings
+func HandledRules(ctx context.Context, c client.Reader, settings *operatorconfig.Settings) ([]*monitoringv1alpha1.Rule, error) {
+	var rules monitoringv1alpha1.RuleList
+	if err := c.List(ctx, &rules); err != nil {
+		return nil, fmt.Errorf("unable to list rules: %w", err)
+	}
+	var handled []*monitoringv1alpha1.Rule
+	for i := range rules.Items {
+		rule := &rules.Items[i]
+		ok, err := settings.Selects(ctx, c, rule)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			handled = append(handled, rule)
+		}
+	}
+	return handled, nil
+}
+
+// SelectedRules returns the Rules selected by the operator settings and by
+// target
+func SelectedRules(ctx context.Context, c client.Reader, settings *operatorconfig.Settings, target *monitoringv1alpha1.PrometheusTarget) ([]*monitoringv1alpha1.Rule, error) {
+	handled, err := HandledRules(ctx, c, settings)
+	if err != nil {
+		return nil, err
+	}
+	var selected []*monitoringv1alpha1.Rule
+	for _, rule := range handled {
+		ok, err := targetSelects(ctx, c, target, rule)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, rule)
+		}
+	}
+	return selected, nil
+}
+
+// renderedRules returns rules as rendered into the outputs: with the labels
+// of settings injected, without the Rules refused by settings
+func renderedRules(settings *operatorconfig.Settings, rules []*monitoringv1alpha1.Rule) []*monitoringv1alpha1.Rule {
+	result := make([]*monitoringv1alpha1.Rule, 0, len(rules))
+	for _, rule := range rules {
+		if refusal(settings, rule) != "" {
+			continue
+		}
+		result = append(result, settings.Inject(rule))
+	}
+	return result
+}
+
+// RenderFiles returns the rule files of the output config defining rules,
+// as the controllers write them: without the Rules refused by settings, with
+// the labels of settings injected and externalLabels added
+func RenderFiles(settings *operatorconfig.Settings, config *monitoringv1alpha1.ConfigMapOutput,
+	externalLabels map[string]string, rules []*monitoringv1alpha1.Rule) ([]output.File, error) {
+	return output.Files(config, externalLabels, renderedRules(settings, rules)...)
+}
+
+// refusal returns why rule is left out of the outputs, empty when it is
+// rendered. Invalid Rules are always left out, a single one would fail the
+// reload of the whole shard.
+func refusal(settings *operatorconfig.Settings, rule *monitoringv1alpha1.Rule) string {
+	switch {
+	case len(validation.Validate(rule)) > 0:
+		return "invalid Rules are refused"
+	case settings.RefuseConflicts && meta.IsStatusConditionTrue(rule.Status.Conditions, monitoringv1alpha1.ConditionConflict):
+		return "conflicting Rules are refused"
+	case settings.EnforceQuotas && meta.IsStatusConditionTrue(rule.Status.Conditions, monitoringv1alpha1.ConditionQuotaExceeded):
+		return "Rules exceeding a RuleQuota are refused"
+	case settings.CardinalityBudget.Refuse &&
+		meta.IsStatusConditionTrue(rule.Status.Conditions, monitoringv1alpha1.ConditionCardinalityBudgetExceeded):
+		return "Rules exceeding the cardinality budget are refused"
+	}
+	return ""
+}
+
+// refusalConditions are the conditions of a Rule that may leave it out of the
+// outputs
+var refusalConditions = []string{
+	monitoringv1alpha1.ConditionConflict,
+	monitoringv1alpha1.ConditionQuotaExceeded,
+	monitoringv1alpha1.ConditionCardinalityBudgetExceeded,
+}
+
+// renderedChanged is true for the updates of a Rule changing what is
+// rendered from it: its generation, its labels selecting it or a condition
+// that may refuse it
+var renderedChanged = predicate.Or(
+	predicate.GenerationChangedPredicate{},
+	predicate.LabelChangedPredicate{},
+	predicate.Funcs{UpdateFunc: func(e event.UpdateEvent) bool {
+		old, ok := e.ObjectOld.(*monitoringv1alpha1.Rule)
+		if !ok {
+			return false
+		}
+		rule, ok := e.ObjectNew.(*monitoringv1alpha1.Rule)
+		if !ok {
+			return false
+		}
+		for _, condition := range refusalConditions {
+			if meta.IsStatusConditionTrue(old.Status.Conditions, condition) !=
+				meta.IsStatusConditionTrue(rule.Status.Conditions, condition) {
+				return true
+			}
+		}
+		return false
+	}},
+)
+
+// targetOwner identifies the HTTP client of the PrometheusTarget
+// namespace/name in Clients
+func targetOwner(namespace, name string) string {
+	return "prometheustarget/" + namespace + "/" + name
+}
+
+// targetClient returns the client of the rules API of target
+func targetClient(ctx context.Context, clients *httpconfig.Clients, target *monitoringv1alpha1.PrometheusTarget) (*prometheus.Client, error) {
+	httpClient, err := clients.Get(ctx, targetOwner(target.Namespace, target.Name), target.Namespace, &target.Spec.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	return prometheus.NewClient(target.Spec.URL, httpClient)
+}
+
+// targetReferences reports whether the HTTP configuration of target reads
+// the Secret namespace/name
+func targetReferences(target *monitoringv1alpha1.PrometheusTarget, namespace, name string) bool {
+	if target.Namespace != namespace {
+		return false
+	}
+	for _, secret := range httpconfig.SecretNames(&target.Spec.HTTPConfig) {
+		if secret == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCheckInterval is how often the loaded groups of a target are checked
+// while it has not loaded the rendered groups of a Rule yet, at most the
+// check interval of the operator settings
+const loadCheckInterval = 30 * time.Second
+
+// checkTargets stores in the status of rule whether each PrometheusTarget
+// selecting it rendered its groups into the ConfigMap of its output and
+// loaded them, with the labels injected by settings. The loaded groups are
+// read from TargetRules, fetched at most a check interval ago, or
+// loadCheckInterval ago while the groups are not loaded yet. The time taken
+// by each target to load a new generation of the Rule is reported in the
+// metrics. Targets that can't be queried are reported in the status, they
+// are not a problem of the Rule.
+func (r *RuleReconciler) checkTargets(ctx context.Context, settings *operatorconfig.Settings, rule *monitoringv1alpha1.Rule) error {
+	var targets monitoringv1alpha1.PrometheusTargetList
+	if err := r.List(ctx, &targets); err != nil {
+		return fmt.Errorf("unable to list prometheus targets: %w", err)
+	}
+	sort.Slice(targets.Items, func(i, j int) bool {
+		a, b := &targets.Items[i], &targets.Items[j]
+		return a.Namespace < b.Namespace || a.Namespace == b.Namespace && a.Name < b.Name
+	})
+
+	previous := make(map[string]*monitoringv1alpha1.TargetStatus, len(rule.Status.Targets))
+	for i := range rule.Status.Targets {
+		previous[rule.Status.Targets[i].Target] = &rule.Status.Targets[i]
+	}
+	now := metav1.Now()
+	var statuses []monitoringv1alpha1.TargetStatus
+	for i := range targets.Items {
+		target := &targets.Items[i]
+		selected, err := targetSelects(ctx, r, target, rule)
+		if err != nil {
+			return err
+		}
+		if !selected {
+			continue
+		}
+
+		// the external labels are rendered into the rule files of the target,
+		// the rules API reports them as rule labels
+		groups := render.WithExternalLabels(render.Groups(settings.Inject(rule)), target.Spec.ExternalLabels)
+		config := &target.Spec.Output.ConfigMap
+		shard := types.NamespacedName{
+			Namespace: target.Namespace,
+			Name:      output.ConfigMapName(config, output.Shard(rule.Namespace, output.Shards(config))),
+		}
+		status := monitoringv1alpha1.TargetStatus{
+			Target:             target.Namespace + "/" + target.Name,
+			ObservedGeneration: rule.Generation,
+			Groups:             make([]string, 0, len(groups)),
+			Shard:              shard.String(),
+			Since:              &now,
+		}
+		last, checked := previous[status.Target]
+		if checked && last.ObservedGeneration != rule.Generation {
+			checked, last = false, nil
+		}
+		if checked && last.Since != nil {
+			status.Since = last.Since
+		}
+		for _, group := range groups {
+			status.Groups = append(status.Groups, group.Name)
+		}
+
+		if reason := refusal(settings, rule); reason != "" {
+			status.Message = "not rendered: " + reason
+			statuses = append(statuses, status)
+			continue
+		}
+		file, err := r.files.get(ctx, r, shard)
+		switch {
+		case err != nil:
+			status.Message = err.Error()
+		case file == nil:
+			status.Message = "not rendered: configmap " + shard.String() + " not found"
+		default:
+			if unrendered := unrenderedRules(groups, file.Groups); len(unrendered) > 0 {
+				status.Message = "not rendered: " + strings.Join(unrendered, ", ")
+			} else {
+				status.Rendered = true
+			}
+		}
+		if !status.Rendered {
+			statuses = append(statuses, status)
+			continue
+		}
+
+		ttl := settings.CheckInterval
+		if (!checked || !last.Loaded) && loadCheckInterval < ttl {
+			ttl = loadCheckInterval
+		}
+		loaded, err := r.TargetRules.Get(ctx, target, ttl)
+		if err != nil {
+			status.Message = err.Error()
+		} else if missing := missingRules(groups, loaded); len(missing) > 0 {
+			status.Message = "not loaded: " + strings.Join(missing, ", ")
+		} else {
+			status.Loaded = true
+			if !checked || !last.Loaded {
+				metrics.RuleLoadDuration.WithLabelValues(status.Target).Observe(now.Sub(status.Since.Time).Seconds())
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	rule.Status.Targets = statuses
+	return nil
+}
+
+// pendingLoad reports whether a target rendered the groups of a Rule but has
+// not loaded them yet, from the statuses of the targets
+func pendingLoad(statuses []monitoringv1alpha1.TargetStatus) bool {
+	for _, status := range statuses {
+		if status.Rendered && !status.Loaded {
+			return true
+		}
+	}
+	return false
+}
+
+// unrenderedRules returns the rules of groups missing from the groups of a
+// rule file, as group/rule. Merged groups of the file hold the rules of
+// several Rules.
+func unrenderedRules(groups, rendered []render.Group) []string {
+	byName := make(map[string]*render.Group, len(rendered))
+	for i := range rendered {
+		byName[rendered[i].Name] = &rendered[i]
+	}
+	var missing []string
+	for _, group := range groups {
+		renderedGroup, ok := byName[group.Name]
+		if !ok || renderedGroup.Interval != group.Interval || renderedGroup.QueryOffset != group.QueryOffset {
+			missing = append(missing, group.Name)
+			continue
+		}
+		for _, rule := range group.Rules {
+			found := false
+			for _, r := range renderedGroup.Rules {
+				if sameRenderedRule(r, rule) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				missing = append(missing, group.Name+"/"+rule.Record+rule.Alert)
+			}
+		}
+	}
+	return missing
+}
+
+// sameRenderedRule reports whether a and b are the same rule, nil and empty
+// labels or annotations being the same
+func sameRenderedRule(a, b render.Rule) bool {
+	return a.Record == b.Record && a.Alert == b.Alert && a.Expr == b.Expr && a.For == b.For &&
+		sameLabels(a.Labels, b.Labels) && sameLabels(a.Annotations, b.Annotations)
+}
+
+// missingRules returns the rules of groups that are not loaded, as
+// group/rule. Loaded groups may hold other rules, merged groups hold the
+// rules of several Rules.
+func missingRules(groups []render.Group, loaded []prometheus.RuleGroup) []string {
+	byName := make(map[string]*prometheus.RuleGroup, len(loaded))
+	for i := range loaded {
+		byName[loaded[i].Name] = &loaded[i]
+	}
+	var missing []string
+	for _, group := range groups {
+		loadedGroup, ok := byName[group.Name]
+		if !ok {
+			missing = append(missing, group.Name)
+			continue
+		}
+		for _, rule := range group.Rules {
+			name := rule.Record + rule.Alert
+			found := false
+			for _, l := range loadedGroup.Rules {
+				if l.Name == name && sameLabels(l.Labels, rule.Labels) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				missing = append(missing, group.Name+"/"+name)
+			}
+		}
+	}
+	return missing
+}
+
+// sameLabels reports whether a and b hold the same labels, nil and empty
+// being the same
+func sameLabels(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return equality.Semantic.DeepEqual(a, b)
+}
+
+// fileCache caches the rule files parsed from the ConfigMaps of the outputs
+// by resource version. It is safe for concurrent use.
+type fileCache struct {
+	mu    sync.Mutex
+	files map[types.NamespacedName]*cachedFile
+}
+
+// cachedFile is a rule file parsed from a ConfigMap at resourceVersion
+type cachedFile struct {
+	resourceVersion string
+	file            *render.File
+}
+
+// get returns the rule file of the ConfigMap key read with c, nil when the
+// ConfigMap doesn't exist
+func (f *fileCache) get(ctx context.Context, c client.Reader, key types.NamespacedName) (*render.File, error) {
+	var configMap corev1.ConfigMap
+	if err := c.Get(ctx, key, &configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			f.forget(key)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get configmap %v: %w", key, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cached, ok := f.files[key]; ok && cached.resourceVersion == configMap.ResourceVersion {
+		return cached.file, nil
+	}
+	file, err := render.Unmarshal([]byte(configMap.Data[output.Key]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule file in configmap %v: %w", key, err)
+	}
+	if f.files == nil {
+		f.files = make(map[types.NamespacedName]*cachedFile)
+	}
+	f.files[key] = &cachedFile{resourceVersion: configMap.ResourceVersion, file: file}
+	return file, nil
+}
+
+// forget drops the rule file of the ConfigMap key
+func (f *fileCache) forget(key types.NamespacedName) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, key)
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	promclient "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/prometheus"
+	promfake "github.com/cyrilix/prometheus-rules-operator/pkg/prometheus/fake"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+)
+
+func TestUnrenderedRules(t *testing.T) {
+	groups := []render.Group{{
+		Name:     "api",
+		Interval: "1m",
+		Rules: []render.Rule{
+			{Record: "job:up:sum", Expr: "sum by (job) (up)", Labels: map[string]string{}},
+			{Alert: "Down", Expr: "up == 0", Labels: map[string]string{"severity": "page"}},
+		},
+	}}
+
+	for _, tc := range []struct {
+		name     string
+		rendered []render.Group
+		expected []string
+	}{
+		{"rendered in a merged group", []render.Group{{
+			Name:     "api",
+			Interval: "1m",
+			Rules: []render.Rule{
+				{Record: "job:up:sum", Expr: "sum by (job) (up)"},
+				{Alert: "Other", Expr: "vector(1)"},
+				{Alert: "Down", Expr: "up == 0", Labels: map[string]string{"severity": "page"}},
+			},
+		}}, nil},
+		{"missing group", []render.Group{{Name: "other"}}, []string{"api"}},
+		{"other interval", []render.Group{{Name: "api", Interval: "5m", Rules: groups[0].Rules}}, []string{"api"}},
+		{"previous generation", []render.Group{{
+			Name:     "api",
+			Interval: "1m",
+			Rules: []render.Rule{
+				{Record: "job:up:sum", Expr: "sum by (job) (up)"},
+				{Alert: "Down", Expr: "up == 0", Labels: map[string]string{"severity": "ticket"}},
+			},
+		}}, []string{"api/Down"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if missing := unrenderedRules(groups, tc.rendered); !reflect.DeepEqual(missing, tc.expected) {
+				t.Errorf("unrenderedRules() = %q, expected %q", missing, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTargetRules(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"status":"success","data":{"groups":[{"name":"api","file":"rules.yaml","rules":[]}]}}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	rules := NewTargetRules(httpconfig.NewClients(nil))
+	target := &monitoringv1alpha1.PrometheusTarget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "main", Generation: 1},
+		Spec:       monitoringv1alpha1.PrometheusTargetSpec{URL: server.URL},
+	}
+	for _, step := range []struct {
+		name  string
+		get   func() ([]prometheus.RuleGroup, error)
+		calls int32
+	}{
+		{"first get", func() ([]prometheus.RuleGroup, error) { return rules.Get(ctx, target, time.Hour) }, 1},
+		{"cached get", func() ([]prometheus.RuleGroup, error) { return rules.Get(ctx, target, time.Hour) }, 1},
+		{"refresh", func() ([]prometheus.RuleGroup, error) { return rules.Refresh(ctx, target) }, 2},
+		{"expired get", func() ([]prometheus.RuleGroup, error) { return rules.Get(ctx, target, 0) }, 3},
+		{"new generation", func() ([]prometheus.RuleGroup, error) {
+			target.Generation++
+			return rules.Get(ctx, target, time.Hour)
+		}, 4},
+	} {
+		groups, err := step.get()
+		if err != nil {
+			t.Fatalf("%v: unexpected error %v", step.name, err)
+		}
+		if len(groups) != 1 || groups[0].Name != "api" {
+			t.Errorf("%v: groups = %v, expected the api group", step.name, groups)
+		}
+		if n := atomic.LoadInt32(&calls); n != step.calls {
+			t.Errorf("%v: %v calls of the rules API, expected %v", step.name, n, step.calls)
+		}
+	}
+}
+
+func TestRenderedRules(t *testing.T) {
+	older := newRule("team-a", "older", recording("job:up:sum", "sum by (job) (up)"))
+	conflicting := newRule("team-b", "conflicting", recording("job:up:sum", "sum by (job) (up{env=\"prod\"})"))
+	meta.SetStatusCondition(&conflicting.Status.Conditions, metav1.Condition{
+		Type:   monitoringv1alpha1.ConditionConflict,
+		Status: metav1.ConditionTrue,
+		Reason: "Conflict",
+	})
+	invalid := newRule("team-c", "invalid", recording("job:up:min", "min by (job) (up"))
+	overQuota := newRule("team-a", "over-quota", recording("job:up:max", "max by (job) (up)"))
+	meta.SetStatusCondition(&overQuota.Status.Conditions, metav1.Condition{
+		Type:   monitoringv1alpha1.ConditionQuotaExceeded,
+		Status: metav1.ConditionTrue,
+		Reason: "QuotaExceeded",
+	})
+	overBudget := newRule("team-b", "over-budget", recording("instance:up:max", "max by (instance) (up)"))
+	meta.SetStatusCondition(&overBudget.Status.Conditions, metav1.Condition{
+		Type:   monitoringv1alpha1.ConditionCardinalityBudgetExceeded,
+		Status: metav1.ConditionTrue,
+		Reason: "BudgetExceeded",
+	})
+	rules := []*monitoringv1alpha1.Rule{older, conflicting, invalid, overQuota, overBudget}
+
+	for _, tc := range []struct {
+		name     string
+		config   configv1alpha1.RulesConfig
+		expected []string
+	}{
+		{"problems reported", configv1alpha1.RulesConfig{}, []string{"older", "conflicting", "over-quota", "over-budget"}},
+		{"conflicts refused", configv1alpha1.RulesConfig{
+			Validation: configv1alpha1.ValidationConfig{RefuseConflicts: true},
+		}, []string{"older", "over-quota", "over-budget"}},
+		{"quotas enforced", configv1alpha1.RulesConfig{
+			Validation: configv1alpha1.ValidationConfig{EnforceQuotas: true},
+		}, []string{"older", "conflicting", "over-budget"}},
+		{"over budget refused", configv1alpha1.RulesConfig{
+			Prometheus: configv1alpha1.PrometheusConfig{RefuseOverBudget: true},
+		}, []string{"older", "conflicting", "over-quota"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := operatorconfig.New(&tc.config, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, rule := range renderedRules(settings, rules) {
+				names = append(names, rule.Name)
+			}
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("renderedRules() = %q, expected %q", names, tc.expected)
+			}
+		})
+	}
+}
+
+// loadDurations returns the number of load durations observed for target
+func loadDurations(t *testing.T, target string) uint64 {
+	var m dto.Metric
+	if err := metrics.RuleLoadDuration.WithLabelValues(target).(promclient.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestCheckTargets(t *testing.T) {
+	server := promfake.NewPrometheus()
+	defer server.Close()
+
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := monitoringv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	target := &monitoringv1alpha1.PrometheusTarget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "main", UID: "main"},
+		Spec: monitoringv1alpha1.PrometheusTargetSpec{
+			URL:    server.URL,
+			Output: monitoringv1alpha1.Output{ConfigMap: monitoringv1alpha1.ConfigMapOutput{Name: "main-rules"}},
+		},
+	}
+	rule := newRule("team-a", "api", recording("job:up:sum", "sum by (job) (up)"))
+	rule.Generation = 1
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(target, rule).Build()
+	clients := httpconfig.NewClients(c)
+	r := &RuleReconciler{Client: c, Scheme: scheme, TargetRules: NewTargetRules(clients)}
+	settings, err := operatorconfig.New(&configv1alpha1.RulesConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := output.Files(&target.Spec.Output.ConfigMap, nil, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &outputWriter{
+		Client:    c,
+		Scheme:    scheme,
+		Namespace: target.Namespace,
+		Kind:      output.TargetOutput,
+		Owner:     target,
+		Locks:     output.NewLocks(),
+		Recorder:  record.NewFakeRecorder(10),
+	}
+	if _, _, err := writer.write(ctx, files); err != nil {
+		t.Fatal(err)
+	}
+
+	observed := loadDurations(t, "monitoring/main")
+	if err := r.checkTargets(ctx, settings, rule); err != nil {
+		t.Fatal(err)
+	}
+	if len(rule.Status.Targets) != 1 {
+		t.Fatalf("target statuses %v, expected the main target", rule.Status.Targets)
+	}
+	status := rule.Status.Targets[0]
+	if !status.Rendered || status.Loaded || status.Since == nil {
+		t.Errorf("status %+v, expected the groups rendered and not loaded since the check", status)
+	}
+	if !pendingLoad(rule.Status.Targets) {
+		t.Errorf("rendered groups not pending load")
+	}
+	since := status.Since
+
+	var loaded []promfake.RuleGroup
+	for _, group := range files[0].Groups {
+		loadedGroup := promfake.RuleGroup{Name: group.Name, File: "rules.yaml"}
+		for _, rule := range group.Rules {
+			loadedGroup.Rules = append(loadedGroup.Rules, promfake.Rule{
+				Name: rule.Record, Query: rule.Expr, Labels: rule.Labels, Type: "recording",
+			})
+		}
+		loaded = append(loaded, loadedGroup)
+	}
+	server.SetRuleGroups(loaded...)
+	if _, err := r.TargetRules.Refresh(ctx, target); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.checkTargets(ctx, settings, rule); err != nil {
+			t.Fatal(err)
+		}
+		status = rule.Status.Targets[0]
+		if !status.Loaded || !status.Since.Equal(since) {
+			t.Errorf("status %+v, expected the groups loaded since %v", status, since)
+		}
+		if n := loadDurations(t, "monitoring/main"); n != observed+1 {
+			t.Errorf("%v load durations observed, expected %v", n, observed+1)
+		}
+	}
+	if pendingLoad(rule.Status.Targets) {
+		t.Errorf("loaded groups pending load")
+	}
+
+	rule.Generation++
+	if err := r.checkTargets(ctx, settings, rule); err != nil {
+		t.Fatal(err)
+	}
+	if status = rule.Status.Targets[0]; status.Since.Equal(since) {
+		t.Errorf("status %+v, expected the new generation checked since now", status)
+	}
+	if n := loadDurations(t, "monitoring/main"); n != observed+2 {
+		t.Errorf("%v load durations observed, expected %v", n, observed+2)
+	}
+	rule.Spec.Groups[0].Rules[0].Expr = "sum by (job) (up"
+	if err := r.checkTargets(ctx, settings, rule); err != nil {
+		t.Fatal(err)
+	}
+	if status = rule.Status.Targets[0]; status.Rendered || status.Message != "not rendered: invalid Rules are refused" {
+		t.Errorf("status %+v, expected the invalid Rule not rendered", status)
+	}
+}
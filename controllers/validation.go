@@ -0,0 +1,54 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/validation"
+)
+
+// checkValidity sets the Invalid condition of rule. Invalid Rules are only
+// rejected on admission when validation is strict, so they are reported here.
+func (r *RuleReconciler) checkValidity(ctx context.Context, rule *monitoringv1alpha1.Rule, previous *monitoringv1alpha1.RuleStatus) {
+	invalid := metav1.Condition{
+		Type:               monitoringv1alpha1.ConditionInvalid,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rule.Generation,
+		Reason:             "Valid",
+		Message:            "Rule is valid",
+	}
+	if problems := validation.Validate(rule); len(problems) > 0 {
+		log.FromContext(ctx).Info("rule is invalid", "problems", problems)
+		invalid.Status = metav1.ConditionTrue
+		invalid.Reason = "InvalidSpec"
+		invalid.Message = strings.Join(problems, "; ")
+		if !meta.IsStatusConditionTrue(previous.Conditions, monitoringv1alpha1.ConditionInvalid) {
+			r.Recorder.Event(rule, corev1.EventTypeWarning, invalid.Reason, invalid.Message)
+			metrics.ValidationFailures.WithLabelValues(invalid.Reason).Inc()
+		}
+	}
+	meta.SetStatusCondition(&rule.Status.Conditions, invalid)
+}
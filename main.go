@@ -18,21 +18,40 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
 	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	monitoringv1beta1 "github.com/cyrilix/prometheus-rules-operator/api/v1beta1"
 	"github.com/cyrilix/prometheus-rules-operator/controllers"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/health"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/output"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/secretcache"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/selfmonitoring"
+	"github.com/cyrilix/prometheus-rules-operator/webhooks"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,18 +64,57 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(monitoringv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(monitoringv1beta1.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var prometheusURL string
+	var staleMetricsCheckInterval time.Duration
+	var cardinalityBudget int
+	var namespaceCardinalityBudgets string
+	var maxConcurrentReconciles, rateLimiterQPS, rateLimiterBurst, kubeAPIQPS, kubeAPIBurst int
+	var rateLimiterBaseDelay, rateLimiterMaxDelay time.Duration
+	var watchdogTimeout time.Duration
+	flag.StringVar(&configFile, "config", "",
+		"The controller will load its configuration from this file and reload the rules settings when it changes. "+
+			"Omit this flag to use the default configuration values. "+
+			"Command-line flags override configuration from this file.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&prometheusURL, "prometheus-url", "",
+		"URL of the Prometheus server used to check that metrics used by rule expressions exist. "+
+			"The check is disabled when empty.")
+	flag.DurationVar(&staleMetricsCheckInterval, "stale-metrics-check-interval", operatorconfig.DefaultCheckInterval,
+		"The period at which rules are checked against the Prometheus server.")
+	flag.IntVar(&cardinalityBudget, "cardinality-budget", 0,
+		"The number of series the recording rules of a namespace may produce, 0 means unlimited.")
+	flag.StringVar(&namespaceCardinalityBudgets, "namespace-cardinality-budgets", "",
+		"Comma separated list of namespace=budget overriding --cardinality-budget for specific namespaces.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", operatorconfig.DefaultMaxConcurrentReconciles,
+		"The number of objects each controller reconciles concurrently.")
+	flag.DurationVar(&rateLimiterBaseDelay, "rate-limiter-base-delay", operatorconfig.DefaultRateLimiterBaseDelay,
+		"The delay of the first retry of an object failing to reconcile.")
+	flag.DurationVar(&rateLimiterMaxDelay, "rate-limiter-max-delay", operatorconfig.DefaultRateLimiterMaxDelay,
+		"The maximum delay between the retries of an object failing to reconcile.")
+	flag.IntVar(&rateLimiterQPS, "rate-limiter-qps", operatorconfig.DefaultRateLimiterQPS,
+		"The number of requests per second each controller reconciles.")
+	flag.IntVar(&rateLimiterBurst, "rate-limiter-burst", operatorconfig.DefaultRateLimiterBurst,
+		"The number of requests each controller reconciles above --rate-limiter-qps during bursts.")
+	flag.IntVar(&kubeAPIQPS, "kube-api-qps", operatorconfig.DefaultClientQPS,
+		"The number of queries per second sent to the Kubernetes API server.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", operatorconfig.DefaultClientBurst,
+		"The number of queries sent to the Kubernetes API server above --kube-api-qps during bursts.")
+	flag.DurationVar(&watchdogTimeout, "watchdog-timeout", 10*time.Minute,
+		"The time a controller may go without reconciling while requests are queued before the liveness check fails.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,40 +123,285 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "6feb0362.cyrilix.fr",
-	})
+	budgets, err := parseBudgets(namespaceCardinalityBudgets)
+	if err != nil {
+		setupLog.Error(err, "invalid namespace cardinality budgets")
+		os.Exit(1)
+	}
+
+	// flags take precedence over the configuration file only when they are
+	// set on the command line, their defaults apply when the file omits them
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	flagOverrides := func(cfg *configv1alpha1.OperatorConfig) {
+		prom := &cfg.Rules.Prometheus
+		if prom.URL == "" || explicit["prometheus-url"] {
+			prom.URL = prometheusURL
+		}
+		if prom.CheckInterval == nil || explicit["stale-metrics-check-interval"] {
+			prom.CheckInterval = &metav1.Duration{Duration: staleMetricsCheckInterval}
+		}
+		if prom.CardinalityBudget == nil || explicit["cardinality-budget"] {
+			prom.CardinalityBudget = &cardinalityBudget
+		}
+		if prom.NamespaceCardinalityBudgets == nil || explicit["namespace-cardinality-budgets"] {
+			prom.NamespaceCardinalityBudgets = budgets
+		}
+	}
+
+	// AndFrom only fills the options left empty by the flags
+	options := ctrl.Options{Scheme: scheme, LeaderElection: enableLeaderElection}
+	if explicit["metrics-bind-address"] {
+		options.MetricsBindAddress = metricsAddr
+	}
+	if explicit["health-probe-bind-address"] {
+		options.HealthProbeBindAddress = probeAddr
+	}
+	var operatorConfig configv1alpha1.OperatorConfig
+	if configFile != "" {
+		options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFile).OfKind(&operatorConfig))
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+	}
+	if options.MetricsBindAddress == "" {
+		options.MetricsBindAddress = metricsAddr
+	}
+	if options.HealthProbeBindAddress == "" {
+		options.HealthProbeBindAddress = probeAddr
+	}
+	if options.Port == 0 {
+		options.Port = 9443
+	}
+	if options.LeaderElectionID == "" {
+		options.LeaderElectionID = "6feb0362.cyrilix.fr"
+	}
+
+	// the controllers settings are only read at startup
+	controllersConfig := &operatorConfig.Controllers
+	if explicit["max-concurrent-reconciles"] {
+		controllersConfig.MaxConcurrentReconciles = &maxConcurrentReconciles
+	}
+	if explicit["rate-limiter-base-delay"] {
+		controllersConfig.RateLimiter.BaseDelay = &metav1.Duration{Duration: rateLimiterBaseDelay}
+	}
+	if explicit["rate-limiter-max-delay"] {
+		controllersConfig.RateLimiter.MaxDelay = &metav1.Duration{Duration: rateLimiterMaxDelay}
+	}
+	if explicit["rate-limiter-qps"] {
+		controllersConfig.RateLimiter.QPS = &rateLimiterQPS
+	}
+	if explicit["rate-limiter-burst"] {
+		controllersConfig.RateLimiter.Burst = &rateLimiterBurst
+	}
+	if explicit["kube-api-qps"] {
+		controllersConfig.Client.QPS = &kubeAPIQPS
+	}
+	if explicit["kube-api-burst"] {
+		controllersConfig.Client.Burst = &kubeAPIBurst
+	}
+	controllersSettings, err := operatorconfig.NewControllers(controllersConfig)
+	if err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+
+	restConfig := ctrl.GetConfigOrDie()
+	controllersSettings.ConfigureClient(restConfig)
+	// only the Secrets labeled for the operator are cached and watched
+	options.NewCache = secretcache.New(labels.SelectorFromSet(labels.Set{monitoringv1alpha1.SecretLabel: "true"}))
+	mgr, err := ctrl.NewManager(restConfig, options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// the Secrets of the integrations are read from the cache of the manager,
+	// so that rotated credentials are picked up
+	httpClients := httpconfig.NewClients(mgr.GetClient())
+	targetRules := controllers.NewTargetRules(httpClients)
+	// shared by the controllers writing the ConfigMaps of the outputs
+	outputLocks := output.NewLocks()
+	flagOverrides(&operatorConfig)
+	settings, err := operatorconfig.New(&operatorConfig.Rules, httpClients)
+	if err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+	config := operatorconfig.NewStore(settings)
+	if configFile != "" {
+		if err := mgr.Add(&operatorconfig.Watcher{
+			Path:     configFile,
+			Scheme:   scheme,
+			Store:    config,
+			Override: flagOverrides,
+			Clients:  httpClients,
+		}); err != nil {
+			setupLog.Error(err, "unable to watch the config file")
+			os.Exit(1)
+		}
+	}
+
+	installer := &selfmonitoring.Installer{
+		Client:   mgr.GetClient(),
+		Store:    config,
+		Recorder: mgr.GetEventRecorderFor("self-monitoring"),
+	}
+	if err := mgr.Add(installer); err != nil {
+		setupLog.Error(err, "unable to install the self monitoring rule")
+		os.Exit(1)
+	}
+
+	initialReconcile := &health.InitialReconcile{Reader: mgr.GetClient()}
+	if err := mgr.Add(initialReconcile); err != nil {
+		setupLog.Error(err, "unable to track the initial reconcile")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.RuleReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("rule-controller"),
+		Config:      config,
+		TargetRules: targetRules,
+		Options:     controllersSettings.Options(),
+		Reconciled:  initialReconcile.Reconciled,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Rule")
 		os.Exit(1)
 	}
+	if err = (&controllers.RuleQuotaReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Options: controllersSettings.Options(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RuleQuota")
+		os.Exit(1)
+	}
+	targetReconciler := &controllers.PrometheusTargetReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("prometheustarget-controller"),
+		Config:      config,
+		HTTPClients: httpClients,
+		TargetRules: targetRules,
+		Locks:       outputLocks,
+		Options:     controllersSettings.Options(),
+	}
+	if err = targetReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "PrometheusTarget")
+		os.Exit(1)
+	}
+	outputReconciler := &controllers.OutputReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("output-controller"),
+		Config:   config,
+		Locks:    outputLocks,
+		Options:  controllersSettings.Options(),
+	}
+	if err = outputReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Output")
+		os.Exit(1)
+	}
+	outputSync := &controllers.OutputSync{
+		Client:  mgr.GetClient(),
+		Targets: targetReconciler,
+		Output:  outputReconciler,
+	}
+	if err := mgr.Add(outputSync); err != nil {
+		setupLog.Error(err, "unable to sync the outputs")
+		os.Exit(1)
+	}
+	if err = (&controllers.SilenceReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("silence-controller"),
+		Config:   config,
+		Options:  controllersSettings.Options(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Silence")
+		os.Exit(1)
+	}
+	if err = (&controllers.InhibitRuleReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("inhibitrule-controller"),
+		Config:   config,
+		Options:  controllersSettings.Options(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "InhibitRule")
+		os.Exit(1)
+	}
+	webhooksEnabled := os.Getenv("ENABLE_WEBHOOKS") != "false"
+	if webhooksEnabled {
+		mgr.GetWebhookServer().Register(webhooks.RuleValidatorPath,
+			&webhook.Admission{Handler: &webhooks.RuleValidator{Client: mgr.GetClient(), Config: config}})
+		// serves the conversion of Rules between their API versions on /convert
+		if err = ctrl.NewWebhookManagedBy(mgr).For(&monitoringv1beta1.Rule{}).Complete(); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Rule")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
+	ctrlmetrics.Registry.MustRegister(&metrics.RuleCollector{Reader: mgr.GetClient()})
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
+	watchdog := &health.Watchdog{Gatherer: ctrlmetrics.Registry, Timeout: watchdogTimeout}
+	if err := mgr.AddHealthzCheck("reconcile", watchdog.Check); err != nil {
+		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
 
+	readyChecks := map[string]healthz.Checker{
+		"cache": health.CacheSynced(mgr.GetCache()),
+		// the controllers and the installer only run on the elected leader
+		"self-monitoring": health.Elected(mgr.Elected(), installer.Check),
+		"rules":           health.Elected(mgr.Elected(), initialReconcile.Check),
+		"outputs":         health.Elected(mgr.Elected(), outputSync.Check),
+	}
+	if webhooksEnabled {
+		host := options.Host
+		if host == "" {
+			host = "localhost"
+		}
+		readyChecks["webhook"] = health.TLSServing(net.JoinHostPort(host, strconv.Itoa(options.Port)))
+	}
+	for name, check := range readyChecks {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			setupLog.Error(err, "unable to set up ready check")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// parseBudgets parses a comma separated list of namespace=budget
+func parseBudgets(value string) (map[string]int, error) {
+	budgets := make(map[string]int)
+	if value == "" {
+		return budgets, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid budget %q, expected namespace=budget", item)
+		}
+		budget, err := strconv.Atoi(kv[1])
+		if err != nil || budget < 0 {
+			return nil, fmt.Errorf("invalid budget %q for namespace %v", kv[1], kv[0])
+		}
+		budgets[kv[0]] = budget
+	}
+	return budgets, nil
+}
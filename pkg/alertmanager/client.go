@@ -0,0 +1,181 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+)
+
+// Client calls the v2 HTTP API of an Alertmanager server
+type Client struct {
+	address    *url.URL
+	httpClient *http.Client
+}
+
+// NewClient returns a Client for the Alertmanager server listening at
+// address, using httpClient or a client with httpconfig.DefaultTimeout when nil
+func NewClient(address string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Alertmanager address %q: %w", address, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Alertmanager address %q: scheme must be http or https", address)
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: httpconfig.DefaultTimeout}
+	}
+	return &Client{address: u, httpClient: httpClient}, nil
+}
+
+// Address returns the address of the Alertmanager server
+func (c *Client) Address() string {
+	return c.address.String()
+}
+
+// APIError is returned when Alertmanager fails to process a request
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("status %v: %v", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is an APIError for a missing resource
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// APIMatcher is a matcher of the Alertmanager API
+type APIMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	// IsEqual defaults to true, only Alertmanager 0.22 and later support
+	// negative matchers
+	IsEqual *bool `json:"isEqual,omitempty"`
+}
+
+// Silence is a silence of the Alertmanager API
+type Silence struct {
+	ID        string       `json:"id,omitempty"`
+	Matchers  []APIMatcher `json:"matchers"`
+	StartsAt  time.Time    `json:"startsAt"`
+	EndsAt    time.Time    `json:"endsAt"`
+	CreatedBy string       `json:"createdBy"`
+	Comment   string       `json:"comment"`
+
+	// Status is only returned by Alertmanager
+	Status *SilenceStatus `json:"status,omitempty"`
+}
+
+// SilenceStatus is the state of a silence returned by Alertmanager
+type SilenceStatus struct {
+	State string `json:"state"`
+}
+
+// PostSilence creates silence, or updates it when its ID is set, and returns
+// its ID. Alertmanager replaces silences whose matchers change by a new one,
+// so the returned ID may differ.
+func (c *Client) PostSilence(ctx context.Context, silence *Silence) (string, error) {
+	body, err := json.Marshal(silence)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v2/silences", bytes.NewReader(body), &resp); err != nil {
+		return "", err
+	}
+	return resp.SilenceID, nil
+}
+
+// GetSilence returns the silence with id
+func (c *Client) GetSilence(ctx context.Context, id string) (*Silence, error) {
+	var silence Silence
+	if err := c.do(ctx, http.MethodGet, "/api/v2/silence/"+url.PathEscape(id), nil, &silence); err != nil {
+		return nil, err
+	}
+	return &silence, nil
+}
+
+// DeleteSilence expires the silence with id
+func (c *Client) DeleteSilence(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v2/silence/"+url.PathEscape(id), nil, nil)
+}
+
+// do calls the API endpoint and decodes the response into result when not nil
+func (c *Client) do(ctx context.Context, method, endpoint string, body io.Reader, result interface{}) error {
+	u := *c.address
+	u.Path = path.Join(u.Path, endpoint)
+
+	if c.httpClient.Timeout == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, httpconfig.DefaultTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("unable to build request for %v: %w", endpoint, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to call %v: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response of %v: %w", endpoint, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		// errors are JSON strings, or plain text for some of them
+		var message string
+		if err := json.Unmarshal(data, &message); err != nil {
+			message = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("%v %v failed: %w", method, endpoint,
+			&APIError{StatusCode: resp.StatusCode, Message: message})
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("unable to decode response of %v: %w", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertmanager
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cyrilix/prometheus-rules-operator/pkg/alertmanager/fake"
+)
+
+var _ = Describe("Client", func() {
+	var server *fake.Alertmanager
+	var client *Client
+	var silence *Silence
+
+	BeforeEach(func() {
+		server = fake.NewAlertmanager()
+		var err error
+		client, err = NewClient(server.URL, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		now := time.Now().UTC().Truncate(time.Second)
+		silence = &Silence{
+			Matchers:  []APIMatcher{{Name: "alertname", Value: "Down"}},
+			StartsAt:  now,
+			EndsAt:    now.Add(time.Hour),
+			CreatedBy: "ops",
+			Comment:   "maintenance",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should reject invalid addresses", func() {
+		_, err := NewClient("localhost:9093", nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should create, get and expire silences", func() {
+		ctx := context.Background()
+		id, err := client.PostSilence(ctx, silence)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id).NotTo(BeEmpty())
+
+		got, err := client.GetSilence(ctx, id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.ID).To(Equal(id))
+		Expect(got.Matchers).To(Equal(silence.Matchers))
+		Expect(got.EndsAt.Equal(silence.EndsAt)).To(BeTrue())
+		Expect(got.Status).To(Equal(&SilenceStatus{State: "active"}))
+
+		Expect(client.DeleteSilence(ctx, id)).To(Succeed())
+		got, err = client.GetSilence(ctx, id)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.Status.State).To(Equal("expired"))
+	})
+
+	It("should update silences", func() {
+		ctx := context.Background()
+		id, err := client.PostSilence(ctx, silence)
+		Expect(err).NotTo(HaveOccurred())
+
+		silence.ID = id
+		silence.Comment = "longer maintenance"
+		Expect(client.PostSilence(ctx, silence)).To(Equal(id))
+
+		silence.Matchers[0].Value = "Up"
+		replaced, err := client.PostSilence(ctx, silence)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replaced).NotTo(Equal(id))
+		Expect(server.Silences()).To(HaveLen(2))
+	})
+
+	It("should report API errors", func() {
+		silence.Comment = ""
+		_, err := client.PostSilence(context.Background(), silence)
+		var apiErr *APIError
+		Expect(errors.As(err, &apiErr)).To(BeTrue())
+		Expect(apiErr.Message).To(Equal("comment missing"))
+
+		_, err = client.GetSilence(context.Background(), "unknown")
+		Expect(IsNotFound(err)).To(BeTrue())
+	})
+})
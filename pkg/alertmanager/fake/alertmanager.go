@@ -0,0 +1,206 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake provides an in-memory Alertmanager server implementing the
+// silences of the v2 HTTP API, for tests.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alertmanager is a fake Alertmanager server. It must be closed once done.
+type Alertmanager struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	silences map[string]*Silence
+	lastID   int
+}
+
+// Matcher is a matcher of a Silence
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual *bool  `json:"isEqual,omitempty"`
+}
+
+// Silence is a silence stored by the fake server
+type Silence struct {
+	ID        string    `json:"id"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+}
+
+// State returns the state of the silence at now
+func (s *Silence) State(now time.Time) string {
+	switch {
+	case now.Before(s.StartsAt):
+		return "pending"
+	case now.Before(s.EndsAt):
+		return "active"
+	default:
+		return "expired"
+	}
+}
+
+// NewAlertmanager starts a fake Alertmanager server without silences
+func NewAlertmanager() *Alertmanager {
+	a := &Alertmanager{silences: make(map[string]*Silence)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/silences", a.handleSilences)
+	mux.HandleFunc("/api/v2/silence/", a.handleSilence)
+	a.Server = httptest.NewServer(mux)
+	return a
+}
+
+// Silences returns a copy of the silences stored by the server, including
+// expired ones
+func (a *Alertmanager) Silences() []Silence {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	silences := make([]Silence, 0, len(a.silences))
+	for i := 1; i <= a.lastID; i++ {
+		if s, ok := a.silences[silenceID(i)]; ok {
+			silences = append(silences, *s)
+		}
+	}
+	return silences
+}
+
+// Clear drops every silence, like an Alertmanager restarting without
+// persistent storage
+func (a *Alertmanager) Clear() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.silences = make(map[string]*Silence)
+}
+
+func silenceID(i int) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// validate mirrors the checks of Alertmanager on posted silences
+func validate(s *Silence, now time.Time) string {
+	switch {
+	case len(s.Matchers) == 0:
+		return "at least one matcher required"
+	case s.CreatedBy == "":
+		return "creator information missing"
+	case s.Comment == "":
+		return "comment missing"
+	case s.EndsAt.Before(s.StartsAt):
+		return "end time must not be before start time"
+	case s.EndsAt.Before(now):
+		return "end time can't be in the past"
+	}
+	return ""
+}
+
+func (a *Alertmanager) handleSilences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var s Silence
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		writeJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	now := time.Now()
+	if msg := validate(&s, now); msg != "" {
+		writeJSON(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s.ID != "" {
+		previous, ok := a.silences[s.ID]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, "silence not found")
+			return
+		}
+		// silences are only updated in place while their matchers don't
+		// change and they aren't expired, otherwise they are replaced
+		if previous.State(now) != "expired" && reflect.DeepEqual(previous.Matchers, s.Matchers) {
+			*previous = s
+			writeJSON(w, http.StatusOK, map[string]string{"silenceID": s.ID})
+			return
+		}
+		a.expire(previous, now)
+	}
+	a.lastID++
+	s.ID = silenceID(a.lastID)
+	a.silences[s.ID] = &s
+	writeJSON(w, http.StatusOK, map[string]string{"silenceID": s.ID})
+}
+
+func (a *Alertmanager) expire(s *Silence, now time.Time) {
+	if s.StartsAt.After(now) {
+		s.StartsAt = now
+	}
+	if s.EndsAt.After(now) {
+		s.EndsAt = now
+	}
+}
+
+func (a *Alertmanager) handleSilence(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v2/silence/")
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.silences[id]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, "silence not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, struct {
+			*Silence
+			Status map[string]string `json:"status"`
+		}{s, map[string]string{"state": s.State(now)}})
+	case http.MethodDelete:
+		if s.State(now) == "expired" {
+			writeJSON(w, http.StatusInternalServerError, fmt.Sprintf("silence %v already expired", id))
+			return
+		}
+		a.expire(s, now)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertmanager
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// InhibitRule is an Alertmanager inhibition rule, muting the alerts matching
+// TargetMatchers while alerts matching SourceMatchers fire
+type InhibitRule struct {
+	SourceMatchers []string `json:"source_matchers"`
+	TargetMatchers []string `json:"target_matchers"`
+	Equal          []string `json:"equal,omitempty"`
+}
+
+// WithInhibitRules returns the Alertmanager configuration file config with
+// rules appended to its inhibition rules. The keys of the file are sorted.
+func WithInhibitRules(config []byte, rules []InhibitRule) ([]byte, error) {
+	if _, err := ParseConfig(config); err != nil {
+		return nil, err
+	}
+	var file map[string]interface{}
+	if err := yaml.Unmarshal(config, &file); err != nil {
+		return nil, err
+	}
+	inhibitRules, ok := file["inhibit_rules"].([]interface{})
+	if !ok && file["inhibit_rules"] != nil {
+		return nil, fmt.Errorf("inhibit_rules must be a list")
+	}
+	for _, rule := range rules {
+		inhibitRules = append(inhibitRules, rule)
+	}
+	if len(inhibitRules) > 0 {
+		file["inhibit_rules"] = inhibitRules
+	}
+	return yaml.Marshal(file)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertmanager
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WithInhibitRules", func() {
+	rule := InhibitRule{
+		SourceMatchers: []string{`alertname="ClusterDown"`},
+		TargetMatchers: []string{`severity=~"warning|info"`},
+		Equal:          []string{"cluster"},
+	}
+
+	It("should append the inhibition rules", func() {
+		data, err := WithInhibitRules([]byte(config+`inhibit_rules:
+- source_matchers: [severity="critical"]
+  target_matchers: [severity="warning"]
+`), []InhibitRule{rule})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ParseConfig(data)).NotTo(BeNil())
+		Expect(string(data)).To(ContainSubstring(`inhibit_rules:
+- source_matchers:
+  - severity="critical"
+  target_matchers:
+  - severity="warning"
+- equal:
+  - cluster
+  source_matchers:
+  - alertname="ClusterDown"
+  target_matchers:
+  - severity=~"warning|info"
+`))
+		Expect(string(data)).To(ContainSubstring("resolve_timeout: 5m"))
+	})
+
+	It("should keep the configuration without inhibition rules", func() {
+		data, err := WithInhibitRules([]byte(config), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).NotTo(ContainSubstring("inhibit_rules"))
+		Expect(ParseConfig(data)).NotTo(BeNil())
+	})
+
+	It("should refuse invalid configurations", func() {
+		_, err := WithInhibitRules([]byte("receivers: []"), []InhibitRule{rule})
+		Expect(err).To(MatchError("no route provided in config"))
+		_, err = WithInhibitRules([]byte(config+"inhibit_rules: {}\n"), []InhibitRule{rule})
+		Expect(err).To(MatchError("inhibit_rules must be a list"))
+	})
+})
@@ -0,0 +1,229 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package alertmanager reads Alertmanager configurations to preview the
+// routing of alerts, adds inhibition rules to them and provides a minimal
+// client for the Alertmanager v2 HTTP API, see
+// https://github.com/prometheus/alertmanager/blob/main/api/v2/openapi.yaml
+package alertmanager
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the part of an Alertmanager configuration used to route alerts
+type Config struct {
+	Route     *Route     `json:"route"`
+	Receivers []Receiver `json:"receivers,omitempty"`
+}
+
+// Receiver is an Alertmanager receiver, only its name matters for routing
+type Receiver struct {
+	Name string `json:"name"`
+}
+
+// Route is a node of the Alertmanager routing tree
+type Route struct {
+	Receiver string            `json:"receiver,omitempty"`
+	Match    map[string]string `json:"match,omitempty"`
+	MatchRE  map[string]string `json:"match_re,omitempty"`
+	Matchers []string          `json:"matchers,omitempty"`
+	Continue bool              `json:"continue,omitempty"`
+	Routes   []*Route          `json:"routes,omitempty"`
+
+	matchers []*Matcher
+	key      string
+}
+
+// ParseConfig parses an Alertmanager configuration file and checks its
+// routing tree
+func ParseConfig(data []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Route == nil {
+		return nil, fmt.Errorf("no route provided in config")
+	}
+	if cfg.Route.Receiver == "" {
+		return nil, fmt.Errorf("root route must specify a default receiver")
+	}
+	if len(cfg.Route.Match) > 0 || len(cfg.Route.MatchRE) > 0 || len(cfg.Route.Matchers) > 0 {
+		return nil, fmt.Errorf("root route must not have any matchers")
+	}
+
+	receivers := make(map[string]struct{}, len(cfg.Receivers))
+	for _, r := range cfg.Receivers {
+		receivers[r.Name] = struct{}{}
+	}
+	if err := cfg.Route.compile("", "", receivers); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// compile parses the matchers of r and its children, which inherit the
+// receiver of their parent when they don't set one
+func (r *Route) compile(parentKey, parentReceiver string, receivers map[string]struct{}) error {
+	if r.Receiver == "" {
+		r.Receiver = parentReceiver
+	}
+	if _, ok := receivers[r.Receiver]; !ok {
+		return fmt.Errorf("undefined receiver %q used in route", r.Receiver)
+	}
+
+	for name, value := range r.Match {
+		r.matchers = append(r.matchers, &Matcher{Name: name, Type: MatchEqual, Value: value})
+	}
+	for name, value := range r.MatchRE {
+		m, err := NewMatcher(MatchRegex, name, value)
+		if err != nil {
+			return err
+		}
+		r.matchers = append(r.matchers, m)
+	}
+	for _, s := range r.Matchers {
+		m, err := ParseMatcher(s)
+		if err != nil {
+			return err
+		}
+		r.matchers = append(r.matchers, m)
+	}
+	sort.Slice(r.matchers, func(i, j int) bool { return r.matchers[i].String() < r.matchers[j].String() })
+
+	parts := make([]string, 0, len(r.matchers))
+	for _, m := range r.matchers {
+		parts = append(parts, m.String())
+	}
+	r.key = "{" + strings.Join(parts, ",") + "}"
+	if parentKey != "" {
+		r.key = parentKey + "/" + r.key
+	}
+
+	for _, child := range r.Routes {
+		if err := child.compile(r.key, r.Receiver, receivers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key identifies the route by the matchers of each level of the tree, as
+// Alertmanager does in its logs and API
+func (r *Route) Key() string {
+	return r.key
+}
+
+// MatchingRoutes returns the routes alerts with labels are sent to, following the
+// Alertmanager algorithm: the deepest matching routes are used, and
+// siblings are only evaluated after a matching route with continue set.
+func (r *Route) MatchingRoutes(labels map[string]string) []*Route {
+	for _, m := range r.matchers {
+		if !m.Matches(labels[m.Name]) {
+			return nil
+		}
+	}
+	var all []*Route
+	for _, child := range r.Routes {
+		matches := child.MatchingRoutes(labels)
+		all = append(all, matches...)
+		if matches != nil && !child.Continue {
+			break
+		}
+	}
+	if len(all) == 0 {
+		all = append(all, r)
+	}
+	return all
+}
+
+// MatchType is the comparison of a Matcher
+type MatchType string
+
+const (
+	MatchEqual    MatchType = "="
+	MatchNotEqual MatchType = "!="
+	MatchRegex    MatchType = "=~"
+	MatchNotRegex MatchType = "!~"
+)
+
+// Matcher matches the value of a label
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+
+	re *regexp.Regexp
+}
+
+// NewMatcher returns a Matcher, compiling its anchored regular expression
+// for regexp types
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: t, Value: value}
+	if t == MatchRegex || t == MatchNotRegex {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q for label %v: %w", value, name, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+var matcherRE = regexp.MustCompile(`^\s*([a-zA-Z_:][a-zA-Z0-9_:]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+
+// ParseMatcher parses a matcher like `severity=~"critical|warning"`, the value
+// may be unquoted
+func ParseMatcher(s string) (*Matcher, error) {
+	parts := matcherRE.FindStringSubmatch(s)
+	if parts == nil {
+		return nil, fmt.Errorf("invalid matcher %q", s)
+	}
+	value := parts[3]
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in matcher %q: %w", s, err)
+		}
+		value = unquoted
+	}
+	return NewMatcher(MatchType(parts[2]), parts[1], value)
+}
+
+// Matches reports whether value, empty for missing labels, matches
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegex:
+		return m.re.MatchString(value)
+	case MatchNotRegex:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertmanager
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const config = `
+global:
+  resolve_timeout: 5m
+route:
+  receiver: default
+  group_by: [alertname]
+  routes:
+  - match:
+      severity: critical
+    receiver: pager
+    continue: true
+  - matchers:
+    - team=~"api|web"
+    - env!="dev"
+    receiver: team
+    routes:
+    - match_re:
+        alertname: .*Down
+      receiver: team-pager
+  - match:
+      team: db
+receivers:
+- name: default
+- name: pager
+- name: team
+- name: team-pager
+`
+
+var _ = Describe("Route", func() {
+	var route *Route
+
+	BeforeEach(func() {
+		cfg, err := ParseConfig([]byte(config))
+		Expect(err).NotTo(HaveOccurred())
+		route = cfg.Route
+	})
+
+	table.DescribeTable("routes alerts",
+		func(labels map[string]string, receivers, keys []string) {
+			var gotReceivers, gotKeys []string
+			for _, r := range route.MatchingRoutes(labels) {
+				gotReceivers = append(gotReceivers, r.Receiver)
+				gotKeys = append(gotKeys, r.Key())
+			}
+			Expect(gotReceivers).To(Equal(receivers))
+			Expect(gotKeys).To(Equal(keys))
+		},
+		table.Entry("to the default receiver",
+			map[string]string{"alertname": "Up"},
+			[]string{"default"}, []string{"{}"}),
+		table.Entry("to nested routes",
+			map[string]string{"alertname": "APIDown", "team": "api"},
+			[]string{"team-pager"}, []string{`{}/{env!="dev",team=~"api|web"}/{alertname=~".*Down"}`}),
+		table.Entry("to the parent when no child matches",
+			map[string]string{"alertname": "Slow", "team": "web"},
+			[]string{"team"}, []string{`{}/{env!="dev",team=~"api|web"}`}),
+		table.Entry("to siblings after a continue",
+			map[string]string{"alertname": "Slow", "team": "web", "severity": "critical"},
+			[]string{"pager", "team"}, []string{`{}/{severity="critical"}`, `{}/{env!="dev",team=~"api|web"}`}),
+		table.Entry("with the inherited receiver",
+			map[string]string{"alertname": "Slow", "team": "db"},
+			[]string{"default"}, []string{`{}/{team="db"}`}),
+		table.Entry("skipping negative matchers",
+			map[string]string{"alertname": "Slow", "team": "web", "env": "dev"},
+			[]string{"default"}, []string{"{}"}),
+	)
+
+	table.DescribeTable("rejects invalid configurations",
+		func(config string) {
+			_, err := ParseConfig([]byte(config))
+			Expect(err).To(HaveOccurred())
+		},
+		table.Entry("without route", "receivers: [{name: default}]"),
+		table.Entry("without default receiver", "route: {}"),
+		table.Entry("with undefined receiver", "route: {receiver: default}"),
+		table.Entry("with invalid matcher",
+			"route: {receiver: a, routes: [{matchers: ['team']}]}\nreceivers: [{name: a}]"),
+		table.Entry("with invalid regexp",
+			"route: {receiver: a, routes: [{match_re: {team: '('}}]}\nreceivers: [{name: a}]"),
+	)
+})
@@ -0,0 +1,229 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package convert converts Prometheus rule files into Rules, verifying that
+// the operator renders them back into the same rules.
+package convert
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/promql"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+)
+
+// Mode selects how the groups of a rule file are split into Rules
+type Mode string
+
+const (
+	// PerFile converts each rule file into a Rule named after the file
+	PerFile Mode = "file"
+	// PerGroup converts each group into a Rule named after the group
+	PerGroup Mode = "group"
+)
+
+// Options configure the Rules resulting from a conversion
+type Options struct {
+	// Mode splits the rule files into Rules, defaults to PerFile
+	Mode Mode
+	// Namespace of the Rules
+	Namespace string
+	// Labels of the Rules
+	Labels map[string]string
+}
+
+// invalidNameChars are the characters not allowed in the name of a Rule
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// Name turns s into a valid Rule name: a lowercase RFC 1123 subdomain
+func Name(s string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(s), "-")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	name = strings.Trim(name, "-.")
+	if name == "" {
+		return "rules"
+	}
+	return name
+}
+
+// Parse parses a Prometheus rule file, refusing the fields Rules can't
+// represent
+func Parse(data []byte) (*render.File, error) {
+	return render.Unmarshal(data)
+}
+
+// Converter converts rule files into Rules with unique names
+type Converter struct {
+	Options Options
+
+	names map[string]int
+}
+
+// Convert returns the Rules defining the groups of file, read from path. It
+// returns an error when the Rules don't render back into the same rules.
+func (c *Converter) Convert(path string, file *render.File) ([]*monitoringv1alpha1.Rule, error) {
+	var rules []*monitoringv1alpha1.Rule
+	switch c.Options.Mode {
+	case PerGroup:
+		for _, group := range file.Groups {
+			rules = append(rules, c.newRule(group.Name, group))
+		}
+	case PerFile, "":
+		if len(file.Groups) > 0 {
+			base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			rules = append(rules, c.newRule(base, file.Groups...))
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode %q", c.Options.Mode)
+	}
+	if err := Verify(file, rules); err != nil {
+		return nil, fmt.Errorf("%v does not convert back into the same rules: %w", path, err)
+	}
+	return rules, nil
+}
+
+// newRule returns a Rule named after name, made unique, defining groups
+func (c *Converter) newRule(name string, groups ...render.Group) *monitoringv1alpha1.Rule {
+	if c.names == nil {
+		c.names = make(map[string]int)
+	}
+	name = Name(name)
+	c.names[name]++
+	if n := c.names[name]; n > 1 {
+		name = Name(name + "-" + strconv.Itoa(n))
+	}
+
+	rule := &monitoringv1alpha1.Rule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: monitoringv1alpha1.GroupVersion.String(),
+			Kind:       "Rule",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: c.Options.Namespace,
+			Name:      name,
+			Labels:    c.Options.Labels,
+		},
+	}
+	for _, group := range groups {
+		g := monitoringv1alpha1.RuleGroup{Name: group.Name, Interval: group.Interval, QueryOffset: group.QueryOffset}
+		for _, r := range group.Rules {
+			g.Rules = append(g.Rules, monitoringv1alpha1.RuleDefinition{
+				Record:      r.Record,
+				Alert:       r.Alert,
+				Expr:        r.Expr,
+				For:         r.For,
+				Labels:      r.Labels,
+				Annotations: r.Annotations,
+			})
+		}
+		rule.Spec.Groups = append(rule.Spec.Groups, g)
+	}
+	return rule
+}
+
+// Verify checks that the rule file rendered from rules defines the groups of
+// file, durations being compared once parsed
+func Verify(file *render.File, rules []*monitoringv1alpha1.Rule) error {
+	data, err := render.Render(rules...)
+	if err != nil {
+		return err
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("unable to parse the rendered rules: %w", err)
+	}
+	rendered := make(map[string]render.Group)
+	for _, group := range parsed.Groups {
+		rendered[group.Name] = group
+	}
+	owners := make(map[string]*monitoringv1alpha1.Rule)
+	for _, rule := range rules {
+		for _, group := range rule.Spec.Groups {
+			owners[group.Name] = rule
+		}
+	}
+
+	if len(rendered) != len(file.Groups) {
+		return fmt.Errorf("%v groups rendered instead of %v", len(rendered), len(file.Groups))
+	}
+	for _, group := range file.Groups {
+		owner, ok := owners[group.Name]
+		if !ok {
+			return fmt.Errorf("group %q is not converted", group.Name)
+		}
+		got, ok := rendered[render.GroupName(owner.Namespace, owner.Name, group.Name)]
+		if !ok {
+			return fmt.Errorf("group %q is not rendered", group.Name)
+		}
+		if !sameDuration(got.Interval, group.Interval) {
+			return fmt.Errorf("group %q: interval %q rendered as %q", group.Name, group.Interval, got.Interval)
+		}
+		if !sameDuration(got.QueryOffset, group.QueryOffset) {
+			return fmt.Errorf("group %q: query offset %q rendered as %q", group.Name, group.QueryOffset, got.QueryOffset)
+		}
+		if len(got.Rules) != len(group.Rules) {
+			return fmt.Errorf("group %q: %v rules rendered instead of %v", group.Name, len(got.Rules), len(group.Rules))
+		}
+		for i := range group.Rules {
+			if !sameRule(got.Rules[i], group.Rules[i]) {
+				return fmt.Errorf("group %q: rule %d rendered differently", group.Name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// sameRule reports whether a and b are the same rule, empty and missing
+// maps being equal
+func sameRule(a, b render.Rule) bool {
+	if !sameDuration(a.For, b.For) {
+		return false
+	}
+	a.For, b.For = "", ""
+	for _, r := range []*render.Rule{&a, &b} {
+		if len(r.Labels) == 0 {
+			r.Labels = nil
+		}
+		if len(r.Annotations) == 0 {
+			r.Annotations = nil
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// sameDuration reports whether durations a and b are equal, or the same
+// string when they can't be parsed
+func sameDuration(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if a == "" || b == "" {
+		return false
+	}
+	da, errA := promql.ParseDuration(a)
+	db, errB := promql.ParseDuration(b)
+	return errA == nil && errB == nil && da == db
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package convert
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+)
+
+const ruleFile = `
+groups:
+- name: api
+  interval: 30s
+  rules:
+  - record: job:http_requests:rate5m
+    expr: sum by (job) (rate(http_requests_total[5m]))
+  - alert: HighErrorRate
+    expr: job:http_errors:rate5m / job:http_requests:rate5m > 0.05
+    for: 10m
+    labels:
+      severity: page
+    annotations:
+      summary: High error rate on {{ $labels.job }}
+- name: Node Exporter
+  rules:
+  - alert: NodeDown
+    expr: up{job="node"} == 0
+`
+
+func names(rules []*monitoringv1alpha1.Rule) []string {
+	var result []string
+	for _, rule := range rules {
+		result = append(result, rule.Name)
+	}
+	return result
+}
+
+var _ = Describe("Convert", func() {
+	table.DescribeTable("Name",
+		func(s, expected string) {
+			Expect(Name(s)).To(Equal(expected))
+		},
+		table.Entry("valid", "api-rules", "api-rules"),
+		table.Entry("invalid characters", "Node Exporter_rules", "node-exporter-rules"),
+		table.Entry("trimmed", "_rules.", "rules"),
+		table.Entry("empty", "!", "rules"),
+	)
+
+	It("should refuse unsupported fields", func() {
+		_, err := Parse([]byte("groups:\n- name: api\n  limit: 10\n  rules: []\n"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should convert a rule file into a Rule", func() {
+		file, err := Parse([]byte(ruleFile))
+		Expect(err).NotTo(HaveOccurred())
+		c := Converter{Options: Options{Namespace: "monitoring", Labels: map[string]string{"team": "api"}}}
+		rules, err := c.Convert("legacy/api_rules.yml", file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(rules)).To(Equal([]string{"api-rules"}))
+
+		rule := rules[0]
+		Expect(rule.APIVersion).To(Equal("monitoring.cyrilix.fr/v1alpha1"))
+		Expect(rule.Kind).To(Equal("Rule"))
+		Expect(rule.Namespace).To(Equal("monitoring"))
+		Expect(rule.Labels).To(Equal(map[string]string{"team": "api"}))
+		Expect(rule.Spec.Groups).To(HaveLen(2))
+		Expect(rule.Spec.Groups[0].Interval).To(Equal("30s"))
+		Expect(rule.Spec.Groups[0].Rules[1]).To(Equal(monitoringv1alpha1.RuleDefinition{
+			Alert:       "HighErrorRate",
+			Expr:        "job:http_errors:rate5m / job:http_requests:rate5m > 0.05",
+			For:         "10m",
+			Labels:      map[string]string{"severity": "page"},
+			Annotations: map[string]string{"summary": "High error rate on {{ $labels.job }}"},
+		}))
+	})
+
+	It("should convert each group into a Rule with a unique name", func() {
+		file, err := Parse([]byte(ruleFile))
+		Expect(err).NotTo(HaveOccurred())
+		c := Converter{Options: Options{Mode: PerGroup, Namespace: "monitoring"}}
+		rules, err := c.Convert("a.yml", file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(rules)).To(Equal([]string{"api", "node-exporter"}))
+		rules, err = c.Convert("b.yml", file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(rules)).To(Equal([]string{"api-2", "node-exporter-2"}))
+	})
+
+	It("should refuse an unknown mode", func() {
+		c := Converter{Options: Options{Mode: "namespace"}}
+		_, err := c.Convert("a.yml", &render.File{})
+		Expect(err).To(MatchError(`unknown mode "namespace"`))
+	})
+
+	It("should detect Rules rendering differently", func() {
+		file, err := Parse([]byte(ruleFile))
+		Expect(err).NotTo(HaveOccurred())
+		c := Converter{Options: Options{Namespace: "monitoring"}}
+		rules, err := c.Convert("api.yml", file)
+		Expect(err).NotTo(HaveOccurred())
+
+		rules[0].Spec.Groups[0].Rules[1].For = "5m"
+		Expect(Verify(file, rules)).To(MatchError(`group "api": rule 1 rendered differently`))
+		rules[0].Spec.Groups[0].Rules[1].For = "600s"
+		Expect(Verify(file, rules)).To(Succeed())
+		rules[0].Spec.Groups[1].MergeKey = "nodes"
+		Expect(Verify(file, rules)).To(MatchError(`group "Node Exporter" is not rendered`))
+	})
+})
@@ -0,0 +1,248 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health provides the readiness and liveness checks of the manager.
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+// checkTimeout bounds the time a check waits, below the default timeout of
+// the probes
+const checkTimeout = 500 * time.Millisecond
+
+// CacheSynced returns a healthz.Checker failing until the informers of c are
+// synced
+func CacheSynced(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return errors.New("caches are not synced")
+		}
+		return nil
+	}
+}
+
+// TLSServing returns a healthz.Checker failing until a TLS handshake succeeds
+// with the server at addr, the webhook server only listens once its
+// certificate is loaded
+func TLSServing(addr string) healthz.Checker {
+	return func(*http.Request) error {
+		dialer := &net.Dialer{Timeout: checkTimeout}
+		// only the handshake matters, not who the certificate is issued to
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return fmt.Errorf("webhook server is not serving: %w", err)
+		}
+		return conn.Close()
+	}
+}
+
+// InitialReconcile is a manager.Runnable tracking the reconciliation of the
+// Rules existing when the manager is elected. Its Check fails until each of
+// them was reconciled once, whatever the result.
+type InitialReconcile struct {
+	Reader client.Reader
+
+	mu sync.Mutex
+	// listed is set once the Rules to reconcile are listed in pending
+	listed  bool
+	pending map[types.NamespacedName]struct{}
+	// done are the Rules reconciled before the listing
+	done map[types.NamespacedName]struct{}
+}
+
+// Start implements manager.Runnable, it lists the Rules once the cache is
+// synced
+func (r *InitialReconcile) Start(ctx context.Context) error {
+	var rules monitoringv1alpha1.RuleList
+	if err := r.Reader.List(ctx, &rules); err != nil {
+		return fmt.Errorf("unable to list rules: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = make(map[types.NamespacedName]struct{}, len(rules.Items))
+	for _, rule := range rules.Items {
+		key := types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}
+		if _, ok := r.done[key]; !ok {
+			r.pending[key] = struct{}{}
+		}
+	}
+	r.done = nil
+	r.listed = true
+	return nil
+}
+
+// Reconciled records that the Rule of req was reconciled
+func (r *InitialReconcile) Reconciled(req reconcile.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.listed {
+		delete(r.pending, req.NamespacedName)
+		return
+	}
+	if r.done == nil {
+		r.done = make(map[types.NamespacedName]struct{})
+	}
+	r.done[req.NamespacedName] = struct{}{}
+}
+
+// Check fails until the Rules existing at the election were reconciled
+func (r *InitialReconcile) Check(*http.Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case !r.listed:
+		return errors.New("rules are not listed yet")
+	case len(r.pending) > 0:
+		return fmt.Errorf("%v rules are not reconciled yet", len(r.pending))
+	}
+	return nil
+}
+
+// Elected returns a healthz.Checker running check once elected is closed,
+// when this replica is elected leader. The other replicas only serve the
+// webhooks, the checks of the controllers don't apply to them.
+func Elected(elected <-chan struct{}, check healthz.Checker) healthz.Checker {
+	return func(req *http.Request) error {
+		select {
+		case <-elected:
+			return check(req)
+		default:
+			return nil
+		}
+	}
+}
+
+// Metrics of the controllers read by the Watchdog, see
+// sigs.k8s.io/controller-runtime/pkg/metrics
+const (
+	reconcileTotalMetric = "controller_runtime_reconcile_total"
+	queueDepthMetric     = "workqueue_depth"
+	longestRunningMetric = "workqueue_longest_running_processor_seconds"
+	controllerLabel      = "controller"
+	queueLabel           = "name"
+)
+
+// progress is the progress of a controller seen by the Watchdog
+type progress struct {
+	reconciles float64
+	since      time.Time
+}
+
+// Watchdog is a liveness check failing when a controller is wedged: it made
+// no reconcile for Timeout while its queue isn't empty, or a reconcile has
+// been running for Timeout. It reads the metrics of the controllers from
+// Gatherer, the controller-runtime registry.
+type Watchdog struct {
+	Gatherer prometheus.Gatherer
+	Timeout  time.Duration
+
+	// now returns the current time, time.Now when nil
+	now func() time.Time
+
+	mu       sync.Mutex
+	progress map[string]progress
+}
+
+// Check implements healthz.Checker
+func (w *Watchdog) Check(*http.Request) error {
+	families, err := w.Gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("unable to gather metrics: %w", err)
+	}
+	reconciles := sumBy(families, reconcileTotalMetric, controllerLabel)
+	depths := sumBy(families, queueDepthMetric, queueLabel)
+	running := sumBy(families, longestRunningMetric, queueLabel)
+
+	now := time.Now()
+	if w.now != nil {
+		now = w.now()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.progress == nil {
+		w.progress = make(map[string]progress)
+	}
+	var wedged []string
+	for name, depth := range depths {
+		p, ok := w.progress[name]
+		if !ok || p.reconciles != reconciles[name] || depth == 0 {
+			p = progress{reconciles: reconciles[name], since: now}
+			w.progress[name] = p
+		}
+		switch {
+		case now.Sub(p.since) >= w.Timeout:
+			wedged = append(wedged, fmt.Sprintf("%v made no progress for %v with %v queued requests",
+				name, now.Sub(p.since).Round(time.Second), depth))
+		case running[name] >= w.Timeout.Seconds():
+			wedged = append(wedged, fmt.Sprintf("%v has been reconciling for %vs", name, running[name]))
+		}
+	}
+	if len(wedged) > 0 {
+		sort.Strings(wedged)
+		return fmt.Errorf("controllers are wedged: %v", wedged)
+	}
+	return nil
+}
+
+// sumBy returns the values of the gauges or counters of the metric name of
+// families, summed by the value of label
+func sumBy(families []*dto.MetricFamily, name, label string) map[string]float64 {
+	sums := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var key string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					key = l.GetValue()
+				}
+			}
+			switch {
+			case m.Gauge != nil:
+				sums[key] += m.Gauge.GetValue()
+			case m.Counter != nil:
+				sums[key] += m.Counter.GetValue()
+			}
+		}
+	}
+	return sums
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+func request(namespace, name string) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+}
+
+var _ = Describe("InitialReconcile", func() {
+	It("should be ready once the listed rules are reconciled", func() {
+		scheme := runtime.NewScheme()
+		Expect(monitoringv1alpha1.AddToScheme(scheme)).To(Succeed())
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&monitoringv1alpha1.Rule{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}},
+			&monitoringv1alpha1.Rule{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b"}},
+		).Build()
+		r := &InitialReconcile{Reader: c}
+		Expect(r.Check(nil)).NotTo(Succeed())
+
+		r.Reconciled(request("default", "a"))
+		Expect(r.Start(context.Background())).To(Succeed())
+		Expect(r.Check(nil)).To(MatchError("1 rules are not reconciled yet"))
+		r.Reconciled(request("default", "b"))
+		Expect(r.Check(nil)).To(Succeed())
+		r.Reconciled(request("default", "c"))
+		Expect(r.Check(nil)).To(Succeed())
+	})
+})
+
+var _ = Describe("Elected", func() {
+	It("should only check once elected", func() {
+		elected := make(chan struct{})
+		check := Elected(elected, func(*http.Request) error { return errors.New("failed") })
+		Expect(check(nil)).To(Succeed())
+		close(elected)
+		Expect(check(nil)).To(MatchError("failed"))
+	})
+})
+
+var _ = Describe("Watchdog", func() {
+	var (
+		registry   *prometheus.Registry
+		reconciles *prometheus.CounterVec
+		depth      *prometheus.GaugeVec
+		running    *prometheus.GaugeVec
+		now        time.Time
+		watchdog   *Watchdog
+	)
+
+	BeforeEach(func() {
+		registry = prometheus.NewRegistry()
+		reconciles = prometheus.NewCounterVec(prometheus.CounterOpts{Name: reconcileTotalMetric},
+			[]string{controllerLabel, "result"})
+		depth = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: queueDepthMetric}, []string{queueLabel})
+		running = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: longestRunningMetric}, []string{queueLabel})
+		registry.MustRegister(reconciles, depth, running)
+		now = time.Now()
+		watchdog = &Watchdog{Gatherer: registry, Timeout: time.Minute, now: func() time.Time { return now }}
+	})
+
+	It("should fail when a controller makes no progress with queued requests", func() {
+		depth.WithLabelValues("rule").Set(3)
+		reconciles.WithLabelValues("rule", "success").Add(5)
+		Expect(watchdog.Check(nil)).To(Succeed())
+
+		now = now.Add(30 * time.Second)
+		reconciles.WithLabelValues("rule", "error").Inc()
+		Expect(watchdog.Check(nil)).To(Succeed())
+		now = now.Add(45 * time.Second)
+		Expect(watchdog.Check(nil)).To(Succeed())
+		now = now.Add(15 * time.Second)
+		Expect(watchdog.Check(nil)).To(MatchError(ContainSubstring("rule made no progress for 1m0s with 3 queued requests")))
+
+		reconciles.WithLabelValues("rule", "success").Inc()
+		Expect(watchdog.Check(nil)).To(Succeed())
+	})
+
+	It("should not fail when the queue is empty", func() {
+		depth.WithLabelValues("rule").Set(0)
+		Expect(watchdog.Check(nil)).To(Succeed())
+		now = now.Add(time.Hour)
+		Expect(watchdog.Check(nil)).To(Succeed())
+	})
+
+	It("should fail when a reconcile is running for too long", func() {
+		depth.WithLabelValues("silence").Set(0)
+		running.WithLabelValues("silence").Set(90)
+		Expect(watchdog.Check(nil)).To(MatchError(ContainSubstring("silence has been reconciling for 90s")))
+	})
+})
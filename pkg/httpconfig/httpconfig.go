@@ -0,0 +1,341 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package httpconfig builds the HTTP clients of the operator integrations
+// from their HTTPConfig, reading the credentials from Secrets.
+package httpconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+// DefaultTimeout bounds the requests of the configurations without timeout
+const DefaultTimeout = 30 * time.Second
+
+// Timeout returns the timeout of the requests configured by config
+func Timeout(config *monitoringv1alpha1.HTTPConfig) time.Duration {
+	if config.Timeout != nil {
+		return config.Timeout.Duration
+	}
+	return DefaultTimeout
+}
+
+// Validate checks the parts of config that don't depend on its Secrets
+func Validate(config *monitoringv1alpha1.HTTPConfig) error {
+	var auth []string
+	if config.BasicAuth != nil {
+		auth = append(auth, "basicAuth")
+	}
+	if config.BearerTokenSecret != nil {
+		auth = append(auth, "bearerTokenSecret")
+	}
+	if config.OAuth2 != nil {
+		auth = append(auth, "oauth2")
+	}
+	if len(auth) > 1 {
+		return fmt.Errorf("%v are mutually exclusive", strings.Join(auth, " and "))
+	}
+	if t := config.TLSConfig; t != nil && (t.Cert == nil) != (t.Key == nil) {
+		return errors.New("tlsConfig cert and key must be set together")
+	}
+	if config.Timeout != nil && config.Timeout.Duration <= 0 {
+		return fmt.Errorf("invalid timeout %v, must be positive", config.Timeout.Duration)
+	}
+	return nil
+}
+
+// SecretNames returns the sorted names of the Secrets referenced by config
+func SecretNames(config *monitoringv1alpha1.HTTPConfig) []string {
+	var selectors []*corev1.SecretKeySelector
+	if a := config.BasicAuth; a != nil {
+		selectors = append(selectors, &a.Username, &a.Password)
+	}
+	if config.BearerTokenSecret != nil {
+		selectors = append(selectors, config.BearerTokenSecret)
+	}
+	if o := config.OAuth2; o != nil {
+		selectors = append(selectors, &o.ClientID, &o.ClientSecret)
+	}
+	if t := config.TLSConfig; t != nil {
+		selectors = append(selectors, t.CA, t.Cert, t.Key)
+	}
+
+	seen := make(map[string]bool, len(selectors))
+	var names []string
+	for _, selector := range selectors {
+		if selector == nil || seen[selector.Name] {
+			continue
+		}
+		seen[selector.Name] = true
+		names = append(names, selector.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Client returns the http.Client configured by config, reading its Secrets
+// from namespace with c
+func Client(ctx context.Context, c client.Reader, namespace string, config *monitoringv1alpha1.HTTPConfig) (*http.Client, error) {
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.TLSConfig != nil {
+		tlsConfig, err := newTLSConfig(ctx, c, namespace, config.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	var rt http.RoundTripper = transport
+	switch {
+	case config.BasicAuth != nil:
+		username, err := secretValue(ctx, c, namespace, &config.BasicAuth.Username)
+		if err != nil {
+			return nil, err
+		}
+		password, err := secretValue(ctx, c, namespace, &config.BasicAuth.Password)
+		if err != nil {
+			return nil, err
+		}
+		rt = &basicAuthRoundTripper{username: username, password: password, next: rt}
+	case config.BearerTokenSecret != nil:
+		token, err := secretValue(ctx, c, namespace, config.BearerTokenSecret)
+		if err != nil {
+			return nil, err
+		}
+		rt = &headerRoundTripper{name: "Authorization", value: "Bearer " + token, next: rt}
+	case config.OAuth2 != nil:
+		clientID, err := secretValue(ctx, c, namespace, &config.OAuth2.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		clientSecret, err := secretValue(ctx, c, namespace, &config.OAuth2.ClientSecret)
+		if err != nil {
+			return nil, err
+		}
+		credentials := clientcredentials.Config{
+			ClientID:       clientID,
+			ClientSecret:   clientSecret,
+			TokenURL:       config.OAuth2.TokenURL,
+			Scopes:         config.OAuth2.Scopes,
+			EndpointParams: url.Values{},
+		}
+		for name, value := range config.OAuth2.EndpointParams {
+			credentials.EndpointParams.Set(name, value)
+		}
+		// the token source outlives ctx, and requests tokens with the TLS
+		// configuration of the integration
+		tokenCtx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+		rt = &oauth2.Transport{Source: credentials.TokenSource(tokenCtx), Base: rt}
+	}
+	return &http.Client{Transport: rt, Timeout: Timeout(config)}, nil
+}
+
+// Clients caches the http.Clients of the integrations by owner, the object or
+// the section of the operator configuration holding their HTTPConfig. The
+// client of an owner is rebuilt when its configuration or the Secrets it
+// references change. With a Reader backed by the cache of the manager,
+// rotated credentials are picked up from its watches without a restart. It
+// is safe for concurrent use.
+type Clients struct {
+	reader client.Reader
+
+	mu      sync.Mutex
+	clients map[string]*cachedClient
+}
+
+// cachedClient is an http.Client built from a configuration and Secrets at
+// versions, identified by version
+type cachedClient struct {
+	version string
+	client  *http.Client
+}
+
+// NewClients returns Clients reading the Secrets with reader
+func NewClients(reader client.Reader) *Clients {
+	return &Clients{reader: reader, clients: map[string]*cachedClient{}}
+}
+
+// Get returns the http.Client of owner configured by config, reading its
+// Secrets from namespace. The client is reused until config or one of the
+// Secrets changes, it then replaces the previous client of owner.
+func (c *Clients) Get(ctx context.Context, owner, namespace string, config *monitoringv1alpha1.HTTPConfig) (*http.Client, error) {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	version := []string{namespace, string(encoded)}
+	for _, name := range SecretNames(config) {
+		var secret corev1.Secret
+		if err := getSecret(ctx, c.reader, namespace, name, &secret); err != nil {
+			return nil, err
+		}
+		version = append(version, name+"="+secret.ResourceVersion)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the idle connections of a replaced client time out
+	if cached, ok := c.clients[owner]; ok && cached.version == strings.Join(version, ",") {
+		return cached.client, nil
+	}
+	httpClient, err := Client(ctx, c.reader, namespace, config)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[owner] = &cachedClient{version: strings.Join(version, ","), client: httpClient}
+	return httpClient, nil
+}
+
+// Forget drops the client of owner, once owner is deleted
+func (c *Clients) Forget(owner string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clients, owner)
+}
+
+// Transport returns an http.RoundTripper sending each request with the
+// current client of owner for config, for the integrations whose client is
+// created before the Secrets can be read
+func Transport(clients *Clients, owner, namespace string, config *monitoringv1alpha1.HTTPConfig) http.RoundTripper {
+	return &clientsRoundTripper{clients: clients, owner: owner, namespace: namespace, config: config.DeepCopy()}
+}
+
+// clientsRoundTripper sends the requests with the current client of clients
+type clientsRoundTripper struct {
+	clients   *Clients
+	owner     string
+	namespace string
+	config    *monitoringv1alpha1.HTTPConfig
+}
+
+func (rt *clientsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	httpClient, err := rt.clients.Get(req.Context(), rt.owner, rt.namespace, rt.config)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return httpClient.Transport.RoundTrip(req)
+}
+
+// newTLSConfig returns the tls.Config configured by config
+func newTLSConfig(ctx context.Context, c client.Reader, namespace string, config *monitoringv1alpha1.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CA != nil {
+		ca, err := secretValue(ctx, c, namespace, config.CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(ca)) {
+			return nil, fmt.Errorf("no certificate found in key %v of secret %v", config.CA.Key, config.CA.Name)
+		}
+	}
+	if config.Cert != nil {
+		cert, err := secretValue(ctx, c, namespace, config.Cert)
+		if err != nil {
+			return nil, err
+		}
+		key, err := secretValue(ctx, c, namespace, config.Key)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return tlsConfig, nil
+}
+
+// getSecret reads the Secret namespace/name into secret. Only the Secrets
+// with the SecretLabel label are cached by the operator, a missing label is
+// reported as such.
+func getSecret(ctx context.Context, c client.Reader, namespace, name string, secret *corev1.Secret) error {
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("secret %v not found, it must have the %v=true label: %w",
+				name, monitoringv1alpha1.SecretLabel, err)
+		}
+		return fmt.Errorf("unable to get secret %v: %w", name, err)
+	}
+	return nil
+}
+
+// secretValue returns the value of the Secret key referenced by selector
+func secretValue(ctx context.Context, c client.Reader, namespace string, selector *corev1.SecretKeySelector) (string, error) {
+	var secret corev1.Secret
+	if err := getSecret(ctx, c, namespace, selector.Name, &secret); err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[selector.Key]
+	if !ok {
+		return "", fmt.Errorf("key %v not found in secret %v", selector.Key, selector.Name)
+	}
+	return string(value), nil
+}
+
+// basicAuthRoundTripper sets the basic authentication of the requests
+type basicAuthRoundTripper struct {
+	username, password string
+	next               http.RoundTripper
+}
+
+func (rt *basicAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(rt.username, rt.password)
+	return rt.next.RoundTrip(req)
+}
+
+// headerRoundTripper sets a header of the requests
+type headerRoundTripper struct {
+	name, value string
+	next        http.RoundTripper
+}
+
+func (rt *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(rt.name, rt.value)
+	return rt.next.RoundTrip(req)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpconfig
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+// key references a key of the credentials Secret
+func key(name string) *corev1.SecretKeySelector {
+	return &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "credentials"}, Key: name}
+}
+
+var _ = Describe("Client", func() {
+	var (
+		c             client.Client
+		server        *httptest.Server
+		authorization string
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+		}))
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		c = fake.NewClientBuilder().WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "credentials"},
+			Data: map[string][]byte{
+				"ca":       ca,
+				"username": []byte("operator"),
+				"password": []byte("secret"),
+				"token":    []byte("t0k3n"),
+			},
+		}).Build()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func(config *monitoringv1alpha1.HTTPConfig) error {
+		httpClient, err := Client(context.Background(), c, "monitoring", config)
+		if err != nil {
+			return err
+		}
+		resp, err := httpClient.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	It("should verify the server with the CA", func() {
+		Expect(get(&monitoringv1alpha1.HTTPConfig{})).NotTo(Succeed())
+		Expect(get(&monitoringv1alpha1.HTTPConfig{TLSConfig: &monitoringv1alpha1.TLSConfig{CA: key("ca")}})).To(Succeed())
+		Expect(get(&monitoringv1alpha1.HTTPConfig{TLSConfig: &monitoringv1alpha1.TLSConfig{InsecureSkipVerify: true}})).To(Succeed())
+		Expect(authorization).To(BeEmpty())
+	})
+
+	It("should authenticate the requests", func() {
+		tlsConfig := &monitoringv1alpha1.TLSConfig{CA: key("ca")}
+		Expect(get(&monitoringv1alpha1.HTTPConfig{
+			TLSConfig: tlsConfig,
+			BasicAuth: &monitoringv1alpha1.BasicAuth{Username: *key("username"), Password: *key("password")},
+		})).To(Succeed())
+		Expect(authorization).To(Equal("Basic b3BlcmF0b3I6c2VjcmV0"))
+
+		Expect(get(&monitoringv1alpha1.HTTPConfig{TLSConfig: tlsConfig, BearerTokenSecret: key("token")})).To(Succeed())
+		Expect(authorization).To(Equal("Bearer t0k3n"))
+	})
+
+	It("should authenticate the requests with OAuth2 client credentials", func() {
+		var form string
+		tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.ParseForm()).To(Succeed())
+			user, password, _ := r.BasicAuth()
+			form = fmt.Sprintf("%v:%v %v", user, password, r.PostForm.Encode())
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token":"access","token_type":"Bearer","expires_in":3600}`)
+		}))
+		defer tokens.Close()
+
+		Expect(get(&monitoringv1alpha1.HTTPConfig{
+			TLSConfig: &monitoringv1alpha1.TLSConfig{CA: key("ca")},
+			OAuth2: &monitoringv1alpha1.OAuth2{
+				ClientID:       *key("username"),
+				ClientSecret:   *key("password"),
+				TokenURL:       tokens.URL,
+				Scopes:         []string{"rules"},
+				EndpointParams: map[string]string{"audience": "prometheus"},
+			},
+		})).To(Succeed())
+		Expect(form).To(Equal("operator:secret audience=prometheus&grant_type=client_credentials&scope=rules"))
+		Expect(authorization).To(Equal("Bearer access"))
+	})
+
+	It("should reject invalid configurations", func() {
+		Expect(get(&monitoringv1alpha1.HTTPConfig{BearerTokenSecret: key("missing")})).
+			To(MatchError("key missing not found in secret credentials"))
+		Expect(get(&monitoringv1alpha1.HTTPConfig{
+			BasicAuth:         &monitoringv1alpha1.BasicAuth{Username: *key("username"), Password: *key("password")},
+			BearerTokenSecret: key("token"),
+		})).NotTo(Succeed())
+		Expect(get(&monitoringv1alpha1.HTTPConfig{
+			BearerTokenSecret: key("token"),
+			OAuth2:            &monitoringv1alpha1.OAuth2{ClientID: *key("username"), ClientSecret: *key("password")},
+		})).To(MatchError("bearerTokenSecret and oauth2 are mutually exclusive"))
+		Expect(get(&monitoringv1alpha1.HTTPConfig{TLSConfig: &monitoringv1alpha1.TLSConfig{Cert: key("ca")}})).
+			To(MatchError("tlsConfig cert and key must be set together"))
+		Expect(get(&monitoringv1alpha1.HTTPConfig{TLSConfig: &monitoringv1alpha1.TLSConfig{CA: key("token")}})).
+			NotTo(Succeed())
+	})
+})
+
+var _ = Describe("Clients", func() {
+	It("should rebuild the clients when their Secrets change", func() {
+		var authorization string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+		}))
+		defer server.Close()
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "credentials"},
+			Data:       map[string][]byte{"token": []byte("first")},
+		}
+		c := fake.NewClientBuilder().WithObjects(secret).Build()
+		clients := NewClients(c)
+		config := &monitoringv1alpha1.HTTPConfig{BearerTokenSecret: key("token")}
+
+		first, err := clients.Get(context.Background(), "target", "monitoring", config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clients.Get(context.Background(), "target", "monitoring", config)).To(BeIdenticalTo(first))
+
+		httpClient := &http.Client{Transport: Transport(clients, "target", "monitoring", config)}
+		get := func() string {
+			resp, err := httpClient.Get(server.URL)
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			ExpectWithOffset(1, resp.Body.Close()).To(Succeed())
+			return authorization
+		}
+		Expect(get()).To(Equal("Bearer first"))
+
+		secret.Data["token"] = []byte("rotated")
+		Expect(c.Update(context.Background(), secret)).To(Succeed())
+		Expect(get()).To(Equal("Bearer rotated"))
+		Expect(clients.Get(context.Background(), "target", "monitoring", config)).NotTo(BeIdenticalTo(first))
+
+		Expect(c.Delete(context.Background(), secret)).To(Succeed())
+		_, err = httpClient.Get(server.URL)
+		Expect(err).To(MatchError(ContainSubstring("secret credentials not found")))
+	})
+
+	It("should replace the client of an owner when its configuration changes", func() {
+		clients := NewClients(fake.NewClientBuilder().Build())
+		first, err := clients.Get(context.Background(), "target", "monitoring", &monitoringv1alpha1.HTTPConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.Timeout).To(Equal(DefaultTimeout))
+
+		config := &monitoringv1alpha1.HTTPConfig{Timeout: &metav1.Duration{Duration: time.Second}}
+		second, err := clients.Get(context.Background(), "target", "monitoring", config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second).NotTo(BeIdenticalTo(first))
+		Expect(second.Timeout).To(Equal(time.Second))
+		Expect(clients.clients).To(HaveLen(1))
+
+		clients.Forget("target")
+		Expect(clients.clients).To(BeEmpty())
+	})
+})
@@ -0,0 +1,192 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package merge merges the groups of several Rules sharing a merge key into
+// single groups, evaluated sequentially by Prometheus.
+package merge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/promql"
+)
+
+// Contribution is a group of a Rule merged into a Group
+type Contribution struct {
+	// Rule defining the group
+	Rule types.NamespacedName
+	// Group is the name of the group in the Rule
+	Group string
+	// Priority orders the contributions, lowest first
+	Priority int32
+	// Interval of the group
+	Interval string
+	// QueryOffset of the group
+	QueryOffset string
+	// Position is the index of the first rule of the contribution in the
+	// merged group
+	Position int
+	// Rules of the group
+	Rules []monitoringv1alpha1.RuleDefinition
+}
+
+// String returns the contribution as namespace/rule/group
+func (c *Contribution) String() string {
+	return c.Rule.String() + "/" + c.Group
+}
+
+// Group is a group merged from the groups of the Rules of a namespace
+// sharing a merge key
+type Group struct {
+	// Namespace of the merged Rules
+	Namespace string
+	// Name of the group, the merge key
+	Name string
+	// Interval of the group, the one of its first contribution
+	Interval string
+	// QueryOffset of the group, the one of its first contribution
+	QueryOffset string
+	// Contributions are the merged groups in evaluation order
+	Contributions []Contribution
+	// Conflict, when not empty, describes the contributions whose interval
+	// or query offset differs
+	Conflict string
+}
+
+// Rules returns the rules of g in evaluation order
+func (g *Group) Rules() []monitoringv1alpha1.RuleDefinition {
+	var rules []monitoringv1alpha1.RuleDefinition
+	for _, c := range g.Contributions {
+		rules = append(rules, c.Rules...)
+	}
+	return rules
+}
+
+// Contribution returns the contribution of the group of rule, nil if it is
+// not merged into g
+func (g *Group) Contribution(rule types.NamespacedName, group string) *Contribution {
+	for i := range g.Contributions {
+		if c := &g.Contributions[i]; c.Rule == rule && c.Group == group {
+			return c
+		}
+	}
+	return nil
+}
+
+// Groups merges the groups with a merge key of rules by namespace and key.
+// The result is sorted by namespace and name, each Group lists its
+// contributions by priority, then Rule and group name.
+func Groups(rules ...*monitoringv1alpha1.Rule) []Group {
+	type key struct{ namespace, name string }
+	merged := make(map[key]*Group)
+	for _, rule := range rules {
+		for _, group := range rule.Spec.Groups {
+			if group.MergeKey == "" {
+				continue
+			}
+			k := key{rule.Namespace, group.MergeKey}
+			g, ok := merged[k]
+			if !ok {
+				g = &Group{Namespace: rule.Namespace, Name: group.MergeKey}
+				merged[k] = g
+			}
+			g.Contributions = append(g.Contributions, Contribution{
+				Rule:        types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name},
+				Group:       group.Name,
+				Priority:    group.Priority,
+				Interval:    group.Interval,
+				QueryOffset: group.QueryOffset,
+				Rules:       group.Rules,
+			})
+		}
+	}
+
+	groups := make([]Group, 0, len(merged))
+	for _, g := range merged {
+		sort.SliceStable(g.Contributions, func(i, j int) bool {
+			a, b := &g.Contributions[i], &g.Contributions[j]
+			if a.Priority != b.Priority {
+				return a.Priority < b.Priority
+			}
+			if a.Rule != b.Rule {
+				return a.Rule.String() < b.Rule.String()
+			}
+			return a.Group < b.Group
+		})
+		position := 0
+		for i := range g.Contributions {
+			g.Contributions[i].Position = position
+			position += len(g.Contributions[i].Rules)
+		}
+		g.Interval = g.Contributions[0].Interval
+		g.QueryOffset = g.Contributions[0].QueryOffset
+		g.Conflict = intervalConflict(g.Contributions)
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Namespace != groups[j].Namespace {
+			return groups[i].Namespace < groups[j].Namespace
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// intervalConflict describes the contributions evaluated at an interval, or
+// with a query offset, different from the first one, durations being
+// compared once parsed
+func intervalConflict(contributions []Contribution) string {
+	reference := contributions[0]
+	var conflicts []string
+	for _, c := range contributions[1:] {
+		if !sameInterval(reference.Interval, c.Interval) {
+			conflicts = append(conflicts, fmt.Sprintf("%v has interval %q", c.String(), c.Interval))
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Sprintf("%v has interval %q but %v", reference.String(), reference.Interval,
+			strings.Join(conflicts, ", "))
+	}
+	for _, c := range contributions[1:] {
+		if !sameInterval(reference.QueryOffset, c.QueryOffset) {
+			conflicts = append(conflicts, fmt.Sprintf("%v has query offset %q", c.String(), c.QueryOffset))
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Sprintf("%v has query offset %q but %v", reference.String(), reference.QueryOffset,
+			strings.Join(conflicts, ", "))
+	}
+	return ""
+}
+
+// sameInterval reports whether intervals a and b are equal, an empty interval
+// being only equal to another empty interval
+func sameInterval(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if a == "" || b == "" {
+		return false
+	}
+	da, errA := promql.ParseDuration(a)
+	db, errB := promql.ParseDuration(b)
+	return errA == nil && errB == nil && da == db
+}
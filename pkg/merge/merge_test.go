@@ -0,0 +1,120 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package merge
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+func newRule(namespace, name string, groups ...monitoringv1alpha1.RuleGroup) *monitoringv1alpha1.Rule {
+	return &monitoringv1alpha1.Rule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       monitoringv1alpha1.RuleSpec{Groups: groups},
+	}
+}
+
+func newGroup(name, key string, priority int32, interval string, records ...string) monitoringv1alpha1.RuleGroup {
+	group := monitoringv1alpha1.RuleGroup{Name: name, MergeKey: key, Priority: priority, Interval: interval}
+	for _, record := range records {
+		group.Rules = append(group.Rules, monitoringv1alpha1.RuleDefinition{Record: record, Expr: "up"})
+	}
+	return group
+}
+
+func records(g Group) []string {
+	var names []string
+	for _, r := range g.Rules() {
+		names = append(names, r.Record)
+	}
+	return names
+}
+
+var _ = Describe("Groups", func() {
+	It("should ignore groups without merge key", func() {
+		Expect(Groups(newRule("default", "a", newGroup("g", "", 0, "", "a")))).To(BeEmpty())
+	})
+
+	It("should merge groups by priority, rule and group name", func() {
+		groups := Groups(
+			newRule("default", "b",
+				newGroup("b1", "shared", 0, "1m", "b1"),
+				newGroup("b2", "shared", -1, "60s", "b2"),
+			),
+			newRule("default", "a",
+				newGroup("a2", "shared", 0, "1m", "a2", "a2bis"),
+				newGroup("a1", "shared", 0, "1m", "a1"),
+				newGroup("own", "", 0, "", "own"),
+			),
+		)
+		Expect(groups).To(HaveLen(1))
+		g := groups[0]
+		Expect(g.Namespace).To(Equal("default"))
+		Expect(g.Name).To(Equal("shared"))
+		Expect(g.Interval).To(Equal("60s"))
+		Expect(g.Conflict).To(BeEmpty())
+		Expect(records(g)).To(Equal([]string{"b2", "a1", "a2", "a2bis", "b1"}))
+
+		c := g.Contribution(types.NamespacedName{Namespace: "default", Name: "a"}, "a2")
+		Expect(c).NotTo(BeNil())
+		Expect(c.Position).To(Equal(2))
+		Expect(c.String()).To(Equal("default/a/a2"))
+		Expect(g.Contribution(types.NamespacedName{Namespace: "default", Name: "a"}, "own")).To(BeNil())
+	})
+
+	It("should merge groups by namespace", func() {
+		groups := Groups(
+			newRule("b", "rule", newGroup("g", "shared", 0, "", "b")),
+			newRule("a", "rule", newGroup("g", "shared", 0, "", "a")),
+		)
+		Expect(groups).To(HaveLen(2))
+		Expect(groups[0].Namespace).To(Equal("a"))
+		Expect(records(groups[0])).To(Equal([]string{"a"}))
+		Expect(groups[1].Namespace).To(Equal("b"))
+		Expect(records(groups[1])).To(Equal([]string{"b"}))
+	})
+
+	It("should report conflicting intervals", func() {
+		groups := Groups(
+			newRule("default", "a", newGroup("g", "shared", 0, "1m", "a")),
+			newRule("default", "b", newGroup("g", "shared", 0, "", "b")),
+			newRule("default", "c", newGroup("g", "shared", 0, "30s", "c")),
+		)
+		Expect(groups).To(HaveLen(1))
+		Expect(groups[0].Conflict).To(Equal(`default/a/g has interval "1m" but ` +
+			`default/b/g has interval "", default/c/g has interval "30s"`))
+	})
+
+	It("should report conflicting query offsets", func() {
+		a := newGroup("g", "shared", 0, "1m", "a")
+		a.QueryOffset = "30s"
+		b := newGroup("g", "shared", 0, "60s", "b")
+		b.QueryOffset = "30000ms"
+		c := newGroup("g", "shared", 0, "1m", "c")
+		groups := Groups(newRule("default", "a", a), newRule("default", "b", b))
+		Expect(groups).To(HaveLen(1))
+		Expect(groups[0].QueryOffset).To(Equal("30s"))
+		Expect(groups[0].Conflict).To(BeEmpty())
+
+		groups = Groups(newRule("default", "a", a), newRule("default", "c", c))
+		Expect(groups[0].Conflict).To(Equal(`default/a/g has query offset "30s" but default/c/g has query offset ""`))
+	})
+})
@@ -0,0 +1,243 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics defines the Prometheus metrics of the operator, registered
+// on the controller-runtime registry served by the manager metrics endpoint.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+const namespace = "prometheus_rules_operator"
+
+// RulesMetric is the name of the gauge of the Rules by state reported by
+// RuleCollector
+const RulesMetric = namespace + "_rules"
+
+// TargetRulesNotLoadedMetric is the name of the gauge of the Rules not loaded
+// by each PrometheusTarget reported by RuleCollector
+const TargetRulesNotLoadedMetric = namespace + "_target_rules_not_loaded"
+
+const (
+	// TargetReloadFailuresMetric is the name of the TargetReloadFailures
+	// counter
+	TargetReloadFailuresMetric = namespace + "_target_reload_failures_total"
+	// TargetReloadSuccessfulMetric is the name of the TargetReloadSuccessful
+	// gauge
+	TargetReloadSuccessfulMetric = namespace + "_target_last_reload_successful"
+)
+
+const (
+	// StateOK is the state of Rules without problem
+	StateOK = "OK"
+	// StateUnknown is the state of Rules not reconciled yet, or not
+	// selected by the operator
+	StateUnknown = "Unknown"
+)
+
+// problemConditions are the conditions reporting a problem of a Rule when
+// true, by decreasing severity
+var problemConditions = []string{
+	monitoringv1alpha1.ConditionInvalid,
+	monitoringv1alpha1.ConditionConflict,
+	monitoringv1alpha1.ConditionDependencyCycle,
+	monitoringv1alpha1.ConditionMissingDependency,
+	monitoringv1alpha1.ConditionQuotaExceeded,
+	monitoringv1alpha1.ConditionMergeConflict,
+	monitoringv1alpha1.ConditionCardinalityBudgetExceeded,
+	monitoringv1alpha1.ConditionStaleMetrics,
+}
+
+// ValidationFailures counts the Rules rejected by the webhook or reported
+// invalid by the controller, by reason
+var ValidationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "validation_failures_total",
+	Help:      "Number of Rules rejected on admission or found invalid, by reason.",
+}, []string{"reason"})
+
+// DriftCorrections counts the objects owned by the operator restored after
+// being modified or deleted outside of it, by kind
+var DriftCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "drift_corrections_total",
+	Help:      "Number of objects owned by the operator restored after being changed outside of it, by kind.",
+}, []string{"kind"})
+
+// TargetGroups is the number of rule groups each PrometheusTarget is
+// expected to load, by target namespace/name
+var TargetGroups = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "target_groups",
+	Help:      "Number of rule groups a PrometheusTarget is expected to load.",
+}, []string{"target"})
+
+// TargetEvaluations is the number of rule evaluations per second each
+// PrometheusTarget is expected to run, by target namespace/name. The
+// operator doesn't schedule the evaluations, Prometheus spreads them over
+// the interval of each group.
+var TargetEvaluations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "target_expected_evaluations_per_second",
+	Help:      "Number of rule evaluations per second a PrometheusTarget is expected to run, from the interval of its groups.",
+}, []string{"target"})
+
+// RenderDuration is the time taken to render the rule files of each output,
+// by output: prometheustarget/namespace/name or default
+var RenderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "render_duration_seconds",
+	Help:      "Time taken to render the rule files of an output.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+}, []string{"output"})
+
+// OutputBytes is the size of the rule file written to each ConfigMap of the
+// outputs, by ConfigMap namespace/name
+var OutputBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "output_bytes",
+	Help:      "Size in bytes of the rule file of a ConfigMap of an output.",
+}, []string{"configmap"})
+
+// TargetReloadAttempts counts the reloads of its configuration observed on
+// each PrometheusTarget, successful or not, by target namespace/name
+var TargetReloadAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "target_reload_attempts_total",
+	Help:      "Number of configuration reloads observed on a PrometheusTarget, successful or not.",
+}, []string{"target"})
+
+// TargetReloadFailures counts the failed reloads of its configuration
+// observed on each PrometheusTarget, by target namespace/name
+var TargetReloadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "target_reload_failures_total",
+	Help:      "Number of failed configuration reloads observed on a PrometheusTarget.",
+}, []string{"target"})
+
+// TargetReloadSuccessful is 1 when the last reload of its configuration by
+// each PrometheusTarget succeeded, by target namespace/name
+var TargetReloadSuccessful = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "target_last_reload_successful",
+	Help:      "Whether the last configuration reload of a PrometheusTarget succeeded.",
+}, []string{"target"})
+
+// RuleLoadDuration is the time between a change of the generation of a Rule
+// and its rules being loaded by each PrometheusTarget selecting it, by target
+// namespace/name
+var RuleLoadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "rule_load_duration_seconds",
+	Help:      "Time between a change of a Rule and its rules being loaded by a PrometheusTarget.",
+	Buckets:   []float64{5, 15, 30, 60, 120, 300, 600, 1200, 3600},
+}, []string{"target"})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(ValidationFailures, DriftCorrections, TargetGroups, TargetEvaluations,
+		RenderDuration, OutputBytes, TargetReloadAttempts, TargetReloadFailures, TargetReloadSuccessful,
+		RuleLoadDuration)
+}
+
+// DeleteTarget deletes the metrics of the PrometheusTarget target, as
+// namespace/name
+func DeleteTarget(target string) {
+	TargetGroups.DeleteLabelValues(target)
+	TargetEvaluations.DeleteLabelValues(target)
+	RenderDuration.DeleteLabelValues("prometheustarget/" + target)
+	TargetReloadAttempts.DeleteLabelValues(target)
+	TargetReloadFailures.DeleteLabelValues(target)
+	TargetReloadSuccessful.DeleteLabelValues(target)
+	RuleLoadDuration.DeleteLabelValues(target)
+}
+
+// RuleState returns the type of the most severe true problem condition of
+// rule, StateOK without problem or StateUnknown without conditions
+func RuleState(rule *monitoringv1alpha1.Rule) string {
+	if len(rule.Status.Conditions) == 0 {
+		return StateUnknown
+	}
+	for _, condition := range problemConditions {
+		if meta.IsStatusConditionTrue(rule.Status.Conditions, condition) {
+			return condition
+		}
+	}
+	return StateOK
+}
+
+var rulesDesc = prometheus.NewDesc(RulesMetric,
+	"Number of Rules by namespace and state, the most severe problem reported by their conditions.",
+	[]string{"namespace", "state"}, nil)
+
+var targetRulesNotLoadedDesc = prometheus.NewDesc(TargetRulesNotLoadedMetric,
+	"Number of Rules selected by a PrometheusTarget whose rules it has not loaded, from their status.",
+	[]string{"target"}, nil)
+
+// RuleCollector is a prometheus.Collector reporting the number of Rules by
+// namespace and state, and the number of Rules not loaded by each
+// PrometheusTarget, listed with Reader at each scrape
+type RuleCollector struct {
+	Reader client.Reader
+}
+
+// Describe implements prometheus.Collector
+func (c *RuleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rulesDesc
+	ch <- targetRulesNotLoadedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *RuleCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var rules monitoringv1alpha1.RuleList
+	if err := c.Reader.List(ctx, &rules); err != nil {
+		ch <- prometheus.NewInvalidMetric(rulesDesc, err)
+		ch <- prometheus.NewInvalidMetric(targetRulesNotLoadedDesc, err)
+		return
+	}
+
+	type key struct{ namespace, state string }
+	counts := make(map[key]int)
+	notLoaded := make(map[string]int)
+	for i := range rules.Items {
+		rule := &rules.Items[i]
+		counts[key{rule.Namespace, RuleState(rule)}]++
+		for _, target := range rule.Status.Targets {
+			// targets are reported even when they loaded every Rule
+			count := notLoaded[target.Target]
+			if !target.Loaded {
+				count++
+			}
+			notLoaded[target.Target] = count
+		}
+	}
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(rulesDesc, prometheus.GaugeValue, float64(count), k.namespace, k.state)
+	}
+	for target, count := range notLoaded {
+		ch <- prometheus.MustNewConstMetric(targetRulesNotLoadedDesc, prometheus.GaugeValue, float64(count), target)
+	}
+}
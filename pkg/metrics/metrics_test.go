@@ -0,0 +1,144 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+// listReader is a client.Reader listing fixed Rules
+type listReader struct {
+	client.Reader
+	rules []monitoringv1alpha1.Rule
+	err   error
+}
+
+func (r listReader) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	if r.err != nil {
+		return r.err
+	}
+	list.(*monitoringv1alpha1.RuleList).Items = r.rules
+	return nil
+}
+
+func ruleWith(namespace string, conditions ...metav1.Condition) monitoringv1alpha1.Rule {
+	return monitoringv1alpha1.Rule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "rule"},
+		Status:     monitoringv1alpha1.RuleStatus{Conditions: conditions},
+	}
+}
+
+func condition(conditionType string, status metav1.ConditionStatus) metav1.Condition {
+	return metav1.Condition{Type: conditionType, Status: status, Reason: "Test"}
+}
+
+var _ = Describe("Metrics", func() {
+	table.DescribeTable("RuleState",
+		func(rule monitoringv1alpha1.Rule, state string) {
+			Expect(RuleState(&rule)).To(Equal(state))
+		},
+		table.Entry("without conditions", ruleWith("default"), StateUnknown),
+		table.Entry("without problem", ruleWith("default",
+			condition(monitoringv1alpha1.ConditionInvalid, metav1.ConditionFalse),
+			condition(monitoringv1alpha1.ConditionConflict, metav1.ConditionFalse),
+		), StateOK),
+		table.Entry("with a problem", ruleWith("default",
+			condition(monitoringv1alpha1.ConditionInvalid, metav1.ConditionFalse),
+			condition(monitoringv1alpha1.ConditionStaleMetrics, metav1.ConditionTrue),
+		), monitoringv1alpha1.ConditionStaleMetrics),
+		table.Entry("with several problems", ruleWith("default",
+			condition(monitoringv1alpha1.ConditionStaleMetrics, metav1.ConditionTrue),
+			condition(monitoringv1alpha1.ConditionConflict, metav1.ConditionTrue),
+		), monitoringv1alpha1.ConditionConflict),
+	)
+
+	Context("RuleCollector", func() {
+		gather := func(reader client.Reader) (map[string]float64, error) {
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(&RuleCollector{Reader: reader})
+			families, err := registry.Gather()
+			if err != nil {
+				return nil, err
+			}
+			values := make(map[string]float64)
+			for _, family := range families {
+				for _, m := range family.GetMetric() {
+					labels := make(map[string]string)
+					for _, label := range m.GetLabel() {
+						labels[label.GetName()] = label.GetValue()
+					}
+					if family.GetName() == TargetRulesNotLoadedMetric {
+						values["notLoaded/"+labels["target"]] = m.GetGauge().GetValue()
+						continue
+					}
+					values[labels["namespace"]+"/"+labels["state"]] = m.GetGauge().GetValue()
+				}
+			}
+			return values, nil
+		}
+
+		It("should count the Rules by namespace and state", func() {
+			values, err := gather(listReader{rules: []monitoringv1alpha1.Rule{
+				ruleWith("a"),
+				ruleWith("a", condition(monitoringv1alpha1.ConditionInvalid, metav1.ConditionFalse)),
+				ruleWith("a", condition(monitoringv1alpha1.ConditionInvalid, metav1.ConditionFalse)),
+				ruleWith("b", condition(monitoringv1alpha1.ConditionInvalid, metav1.ConditionTrue)),
+			}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values).To(Equal(map[string]float64{
+				"a/" + StateUnknown: 1,
+				"a/" + StateOK:      2,
+				"b/" + monitoringv1alpha1.ConditionInvalid: 1,
+			}))
+		})
+
+		It("should count the Rules not loaded by each target", func() {
+			loaded := ruleWith("a")
+			loaded.Status.Targets = []monitoringv1alpha1.TargetStatus{
+				{Target: "monitoring/main", Rendered: true, Loaded: true},
+				{Target: "monitoring/other", Rendered: true, Loaded: true},
+			}
+			pending := ruleWith("b")
+			pending.Status.Targets = []monitoringv1alpha1.TargetStatus{
+				{Target: "monitoring/main", Rendered: true},
+			}
+			values, err := gather(listReader{rules: []monitoringv1alpha1.Rule{loaded, pending}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values).To(Equal(map[string]float64{
+				"a/" + StateUnknown:          1,
+				"b/" + StateUnknown:          1,
+				"notLoaded/monitoring/main":  1,
+				"notLoaded/monitoring/other": 0,
+			}))
+		})
+
+		It("should report an error when Rules can't be listed", func() {
+			_, err := gather(listReader{err: errors.New("unavailable")})
+			Expect(err).To(MatchError(ContainSubstring("unavailable")))
+		})
+	})
+})
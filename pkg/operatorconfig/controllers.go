@@ -0,0 +1,135 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operatorconfig
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+)
+
+// The defaults of the controllers are the ones of controller-runtime
+const (
+	// DefaultMaxConcurrentReconciles is the default number of objects each
+	// controller reconciles concurrently
+	DefaultMaxConcurrentReconciles = 1
+
+	// DefaultRateLimiterBaseDelay is the default delay of the first retry of
+	// a failing object
+	DefaultRateLimiterBaseDelay = 5 * time.Millisecond
+
+	// DefaultRateLimiterMaxDelay is the default maximum delay between the
+	// retries of a failing object
+	DefaultRateLimiterMaxDelay = 1000 * time.Second
+
+	// DefaultRateLimiterQPS and DefaultRateLimiterBurst are the default
+	// token bucket of the requests of each controller
+	DefaultRateLimiterQPS   = 10
+	DefaultRateLimiterBurst = 100
+
+	// DefaultClientQPS and DefaultClientBurst are the default rate limits of
+	// the Kubernetes API client
+	DefaultClientQPS   = 20
+	DefaultClientBurst = 30
+)
+
+// Controllers are the concurrency and rate limiting settings of the
+// controllers resulting from a ControllersConfig
+type Controllers struct {
+	// MaxConcurrentReconciles is the number of objects each controller
+	// reconciles concurrently
+	MaxConcurrentReconciles int
+	// BaseDelay and MaxDelay bound the exponential backoff of failing objects
+	BaseDelay, MaxDelay time.Duration
+	// QPS and Burst are the token bucket of the requests of each controller
+	QPS, Burst int
+	// ClientQPS and ClientBurst are the rate limits of the Kubernetes API
+	// client
+	ClientQPS, ClientBurst int
+}
+
+// NewControllers returns the Controllers settings configured by config
+func NewControllers(config *configv1alpha1.ControllersConfig) (*Controllers, error) {
+	c := Controllers{
+		MaxConcurrentReconciles: DefaultMaxConcurrentReconciles,
+		BaseDelay:               DefaultRateLimiterBaseDelay,
+		MaxDelay:                DefaultRateLimiterMaxDelay,
+		QPS:                     DefaultRateLimiterQPS,
+		Burst:                   DefaultRateLimiterBurst,
+		ClientQPS:               DefaultClientQPS,
+		ClientBurst:             DefaultClientBurst,
+	}
+	positive := []struct {
+		name  string
+		value *int
+		to    *int
+	}{
+		{"maxConcurrentReconciles", config.MaxConcurrentReconciles, &c.MaxConcurrentReconciles},
+		{"rateLimiter qps", config.RateLimiter.QPS, &c.QPS},
+		{"rateLimiter burst", config.RateLimiter.Burst, &c.Burst},
+		{"client qps", config.Client.QPS, &c.ClientQPS},
+		{"client burst", config.Client.Burst, &c.ClientBurst},
+	}
+	for _, p := range positive {
+		if p.value == nil {
+			continue
+		}
+		if *p.value <= 0 {
+			return nil, fmt.Errorf("invalid controllers %v %v, must be positive", p.name, *p.value)
+		}
+		*p.to = *p.value
+	}
+	if d := config.RateLimiter.BaseDelay; d != nil {
+		if d.Duration <= 0 {
+			return nil, fmt.Errorf("invalid controllers rateLimiter baseDelay %v, must be positive", d.Duration)
+		}
+		c.BaseDelay = d.Duration
+	}
+	if d := config.RateLimiter.MaxDelay; d != nil {
+		c.MaxDelay = d.Duration
+	}
+	if c.MaxDelay < c.BaseDelay {
+		return nil, fmt.Errorf("invalid controllers rateLimiter maxDelay %v, must not be less than baseDelay %v",
+			c.MaxDelay, c.BaseDelay)
+	}
+	return &c, nil
+}
+
+// Options returns the options of a controller. Each controller needs its
+// own options, their rate limiter tracks the requests of the controller.
+func (c *Controllers) Options() controller.Options {
+	return controller.Options{
+		MaxConcurrentReconciles: c.MaxConcurrentReconciles,
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(c.BaseDelay, c.MaxDelay),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(c.QPS), c.Burst)},
+		),
+	}
+}
+
+// ConfigureClient applies the rate limits of the Kubernetes API client to
+// config
+func (c *Controllers) ConfigureClient(config *rest.Config) {
+	config.QPS = float32(c.ClientQPS)
+	config.Burst = c.ClientBurst
+}
@@ -0,0 +1,360 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operatorconfig
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+)
+
+const configV1 = `apiVersion: config.cyrilix.fr/v1alpha1
+kind: OperatorConfig
+metrics:
+  bindAddress: 127.0.0.1:8080
+rules:
+  selector:
+    matchLabels:
+      prometheus: main
+  defaultInterval: 30s
+  prometheus:
+    url: http://prometheus:9090
+    checkInterval: 5m
+    cardinalityBudget: 1000
+    namespaceCardinalityBudgets:
+      team-a: 10
+    refuseOverBudget: true
+  validation:
+    strict: true
+`
+
+const configV2 = `apiVersion: config.cyrilix.fr/v1alpha1
+kind: OperatorConfig
+rules:
+  defaultInterval: 2m
+`
+
+// writeFile replaces the file at path at once, as ConfigMap volumes do
+func writeFile(path, content string) {
+	tmp := path + ".tmp"
+	ExpectWithOffset(1, ioutil.WriteFile(tmp, []byte(content), 0644)).To(Succeed())
+	ExpectWithOffset(1, os.Rename(tmp, path)).To(Succeed())
+}
+
+var _ = Describe("Settings", func() {
+	It("should apply defaults", func() {
+		settings, err := New(&configv1alpha1.RulesConfig{}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(settings.Selector.Empty()).To(BeTrue())
+		Expect(settings.NamespaceSelector.Empty()).To(BeTrue())
+		Expect(settings.DefaultInterval).To(Equal(DefaultEvaluationInterval))
+		Expect(settings.CheckInterval).To(Equal(DefaultCheckInterval))
+		Expect(settings.Prometheus).To(BeNil())
+		Expect(settings.Strict).To(BeFalse())
+	})
+
+	It("should reject invalid configurations", func() {
+		_, err := New(&configv1alpha1.RulesConfig{DefaultInterval: "often"}, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = New(&configv1alpha1.RulesConfig{Selector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "a", Operator: "Unknown"}},
+		}}, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = New(&configv1alpha1.RulesConfig{Prometheus: configv1alpha1.PrometheusConfig{
+			CheckInterval: &metav1.Duration{},
+		}}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should validate the output and the injected labels", func() {
+		_, err := New(&configv1alpha1.RulesConfig{Output: &configv1alpha1.OutputConfig{
+			Output: monitoringv1alpha1.Output{ConfigMap: monitoringv1alpha1.ConfigMapOutput{Name: "rules"}},
+		}}, nil)
+		Expect(err).To(MatchError("output requires a namespace and a configMap name"))
+		_, err = New(&configv1alpha1.RulesConfig{Labels: map[string]string{"cluster-name": "main"}}, nil)
+		Expect(err).To(MatchError(`invalid label name "cluster-name"`))
+		_, err = New(&configv1alpha1.RulesConfig{NamespaceLabel: "1namespace"}, nil)
+		Expect(err).To(MatchError(`invalid namespaceLabel "1namespace"`))
+	})
+
+	It("should inject labels into every rule", func() {
+		rule := &monitoringv1alpha1.Rule{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "api"},
+			Spec: monitoringv1alpha1.RuleSpec{Groups: []monitoringv1alpha1.RuleGroup{{
+				Name: "api",
+				Rules: []monitoringv1alpha1.RuleDefinition{
+					{Record: "job:up:sum", Expr: "sum by (job) (up)"},
+					{Alert: "Down", Expr: "up == 0", Labels: map[string]string{"cluster": "other", "severity": "page"}},
+				},
+			}}},
+		}
+		settings, err := New(&configv1alpha1.RulesConfig{}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(settings.Inject(rule)).To(BeIdenticalTo(rule))
+
+		settings, err = New(&configv1alpha1.RulesConfig{
+			Labels:         map[string]string{"cluster": "main"},
+			NamespaceLabel: "namespace",
+		}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		injected := settings.Inject(rule)
+		Expect(injected.Spec.Groups[0].Rules[0].Labels).To(Equal(map[string]string{
+			"cluster": "main", "namespace": "team-a",
+		}))
+		Expect(injected.Spec.Groups[0].Rules[1].Labels).To(Equal(map[string]string{
+			"cluster": "main", "namespace": "team-a", "severity": "page",
+		}))
+		Expect(rule.Spec.Groups[0].Rules[0].Labels).To(BeNil())
+		Expect(rule.Spec.Groups[0].Rules[1].Labels).To(HaveKeyWithValue("cluster", "other"))
+	})
+
+	It("should default the key of the Alertmanager configuration", func() {
+		settings, err := New(&configv1alpha1.RulesConfig{Alertmanager: configv1alpha1.AlertmanagerConfig{
+			ConfigSecret: &configv1alpha1.SecretKeyReference{Namespace: "monitoring", Name: "alertmanager"},
+		}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(settings.AlertmanagerConfigSecret).To(Equal(&configv1alpha1.SecretKeyReference{
+			Namespace: "monitoring", Name: "alertmanager", Key: DefaultAlertmanagerConfigKey,
+		}))
+	})
+
+	It("should check the generated Alertmanager configuration Secret", func() {
+		base := &configv1alpha1.SecretKeyReference{Namespace: "monitoring", Name: "alertmanager"}
+		_, err := New(&configv1alpha1.RulesConfig{Alertmanager: configv1alpha1.AlertmanagerConfig{
+			GeneratedConfigSecret: &configv1alpha1.SecretKeyReference{Namespace: "monitoring", Name: "generated"},
+		}}, nil)
+		Expect(err).To(MatchError("alertmanager generatedConfigSecret requires a configSecret"))
+
+		_, err = New(&configv1alpha1.RulesConfig{Alertmanager: configv1alpha1.AlertmanagerConfig{
+			ConfigSecret:          base,
+			GeneratedConfigSecret: &configv1alpha1.SecretKeyReference{Namespace: "monitoring", Name: "alertmanager", Key: "other"},
+		}}, nil)
+		Expect(err).To(MatchError("alertmanager generatedConfigSecret must not be the configSecret"))
+
+		settings, err := New(&configv1alpha1.RulesConfig{Alertmanager: configv1alpha1.AlertmanagerConfig{
+			ConfigSecret:          base,
+			GeneratedConfigSecret: &configv1alpha1.SecretKeyReference{Namespace: "monitoring", Name: "generated"},
+		}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(settings.AlertmanagerGeneratedConfigSecret).To(Equal(&configv1alpha1.SecretKeyReference{
+			Namespace: "monitoring", Name: "generated", Key: DefaultAlertmanagerConfigKey,
+		}))
+	})
+
+	It("should default the self monitoring settings", func() {
+		settings, err := New(&configv1alpha1.RulesConfig{}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(settings.SelfMonitoring).To(BeNil())
+
+		_, err = New(&configv1alpha1.RulesConfig{SelfMonitoring: configv1alpha1.SelfMonitoringConfig{
+			Enabled: true,
+		}}, nil)
+		Expect(err).To(MatchError("selfMonitoring requires a namespace"))
+
+		settings, err = New(&configv1alpha1.RulesConfig{SelfMonitoring: configv1alpha1.SelfMonitoringConfig{
+			Enabled:   true,
+			Namespace: "monitoring",
+		}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(settings.SelfMonitoring).To(Equal(&SelfMonitoring{
+			Namespace:      "monitoring",
+			Name:           DefaultSelfMonitoringName,
+			Job:            DefaultSelfMonitoringJob,
+			For:            DefaultSelfMonitoringFor,
+			WebhookLatency: DefaultWebhookLatency,
+		}))
+
+		negative := -1
+		_, err = New(&configv1alpha1.RulesConfig{SelfMonitoring: configv1alpha1.SelfMonitoringConfig{
+			Enabled:        true,
+			Namespace:      "monitoring",
+			NotLoadedRules: &negative,
+		}}, nil)
+		Expect(err).To(MatchError("invalid selfMonitoring notLoadedRules -1, must not be negative"))
+	})
+
+	It("should record the secrets of the HTTP configurations", func() {
+		token := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "prometheus"}, Key: "token"}
+		config := &configv1alpha1.RulesConfig{Prometheus: configv1alpha1.PrometheusConfig{
+			URL: "http://prometheus:9090",
+			HTTPConfig: &configv1alpha1.HTTPClientConfig{
+				HTTPConfig: monitoringv1alpha1.HTTPConfig{BearerTokenSecret: token},
+			},
+		}}
+		_, err := New(config, httpconfig.NewClients(nil))
+		Expect(err).To(MatchError("invalid prometheus httpConfig: namespace of the secrets is required"))
+
+		config.Prometheus.HTTPConfig.Namespace = "monitoring"
+		_, err = New(config, nil)
+		Expect(err).To(HaveOccurred())
+		settings, err := New(config, httpconfig.NewClients(nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(settings.References("monitoring", "prometheus")).To(BeTrue())
+		Expect(settings.References("default", "prometheus")).To(BeFalse())
+
+		config.Prometheus.HTTPConfig.OAuth2 = &monitoringv1alpha1.OAuth2{ClientID: *token, ClientSecret: *token}
+		_, err = New(config, httpconfig.NewClients(nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should select rules by label", func() {
+		settings, err := New(&configv1alpha1.RulesConfig{Selector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"prometheus": "main"},
+		}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		rule := &monitoringv1alpha1.Rule{}
+		Expect(settings.Selects(context.Background(), nil, rule)).To(BeFalse())
+		rule.Labels = map[string]string{"prometheus": "main"}
+		Expect(settings.Selects(context.Background(), nil, rule)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Controllers", func() {
+	It("should apply defaults", func() {
+		c, err := NewControllers(&configv1alpha1.ControllersConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c).To(Equal(&Controllers{
+			MaxConcurrentReconciles: DefaultMaxConcurrentReconciles,
+			BaseDelay:               DefaultRateLimiterBaseDelay,
+			MaxDelay:                DefaultRateLimiterMaxDelay,
+			QPS:                     DefaultRateLimiterQPS,
+			Burst:                   DefaultRateLimiterBurst,
+			ClientQPS:               DefaultClientQPS,
+			ClientBurst:             DefaultClientBurst,
+		}))
+	})
+
+	It("should reject invalid configurations", func() {
+		zero := 0
+		_, err := NewControllers(&configv1alpha1.ControllersConfig{MaxConcurrentReconciles: &zero})
+		Expect(err).To(HaveOccurred())
+		_, err = NewControllers(&configv1alpha1.ControllersConfig{Client: configv1alpha1.ClientConfig{QPS: &zero}})
+		Expect(err).To(HaveOccurred())
+		_, err = NewControllers(&configv1alpha1.ControllersConfig{RateLimiter: configv1alpha1.RateLimiterConfig{
+			BaseDelay: &metav1.Duration{Duration: time.Minute},
+			MaxDelay:  &metav1.Duration{Duration: time.Second},
+		}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should configure the controllers and the client", func() {
+		workers, qps := 4, 50
+		c, err := NewControllers(&configv1alpha1.ControllersConfig{
+			MaxConcurrentReconciles: &workers,
+			RateLimiter: configv1alpha1.RateLimiterConfig{
+				BaseDelay: &metav1.Duration{Duration: time.Second},
+				MaxDelay:  &metav1.Duration{Duration: time.Minute},
+			},
+			Client: configv1alpha1.ClientConfig{QPS: &qps},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		options := c.Options()
+		Expect(options.MaxConcurrentReconciles).To(Equal(4))
+		Expect(options.RateLimiter.When("rule")).To(Equal(time.Second))
+		Expect(options.RateLimiter.When("rule")).To(Equal(2 * time.Second))
+		Expect(c.Options().RateLimiter.When("rule")).To(Equal(time.Second))
+
+		config := rest.Config{}
+		c.ConfigureClient(&config)
+		Expect(config.QPS).To(Equal(float32(50)))
+		Expect(config.Burst).To(Equal(DefaultClientBurst))
+	})
+})
+
+var _ = Describe("Watcher", func() {
+	var (
+		dir    string
+		path   string
+		scheme *runtime.Scheme
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "operatorconfig")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "config.yaml")
+		writeFile(path, configV1)
+
+		scheme = runtime.NewScheme()
+		Expect(configv1alpha1.AddToScheme(scheme)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should load the configuration file", func() {
+		var cfg configv1alpha1.OperatorConfig
+		Expect(Load(path, scheme, &cfg)).To(Succeed())
+		Expect(cfg.Metrics.BindAddress).To(Equal("127.0.0.1:8080"))
+
+		settings, err := New(&cfg.Rules, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(settings.DefaultInterval).To(Equal(30 * time.Second))
+		Expect(settings.Prometheus.Address()).To(Equal("http://prometheus:9090"))
+		Expect(settings.CheckInterval).To(Equal(5 * time.Minute))
+		Expect(settings.CardinalityBudget.For("team-a")).To(Equal(10))
+		Expect(settings.CardinalityBudget.For("team-b")).To(Equal(1000))
+		Expect(settings.CardinalityBudget.Refuse).To(BeTrue())
+		Expect(settings.Strict).To(BeTrue())
+	})
+
+	It("should reload the settings when the file changes", func() {
+		store := NewStore(nil)
+		changes := store.Subscribe()
+		watcher := &Watcher{
+			Path:   path,
+			Scheme: scheme,
+			Store:  store,
+			Override: func(cfg *configv1alpha1.OperatorConfig) {
+				cfg.Rules.Validation.Strict = true
+			},
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- watcher.Start(ctx) }()
+		defer func() {
+			cancel()
+			Expect(<-done).To(Succeed())
+		}()
+
+		// let the watcher read the initial content
+		Consistently(changes, "100ms").ShouldNot(Receive())
+		writeFile(path, "invalid")
+		Consistently(changes, "100ms").ShouldNot(Receive())
+		Expect(store.Get()).To(BeNil())
+
+		writeFile(path, configV2)
+		Eventually(changes).Should(Receive())
+		Expect(store.Get().DefaultInterval).To(Equal(2 * time.Minute))
+		Expect(store.Get().Strict).To(BeTrue())
+	})
+})
@@ -0,0 +1,437 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package operatorconfig turns the operator configuration file into the
+// settings used by the controllers and the webhooks, and reloads them when
+// the file changes.
+package operatorconfig
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/alertmanager"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/prometheus"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/promql"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/validation"
+)
+
+const (
+	// DefaultEvaluationInterval is the Prometheus default global evaluation
+	// interval
+	DefaultEvaluationInterval = time.Minute
+
+	// DefaultCheckInterval is the default period at which Rules are checked
+	// against Prometheus
+	DefaultCheckInterval = 10 * time.Minute
+
+	// DefaultAlertmanagerConfigKey is the default key of the Secret holding
+	// the Alertmanager configuration
+	DefaultAlertmanagerConfigKey = "alertmanager.yaml"
+
+	// DefaultSelfMonitoringName is the default name of the Rule alerting on
+	// the operator
+	DefaultSelfMonitoringName = "prometheus-rules-operator"
+
+	// DefaultSelfMonitoringJob is the default job label of the operator
+	// metrics, the name of the metrics Service scraped by the ServiceMonitor
+	DefaultSelfMonitoringJob = "prometheus-rules-operator-controller-manager-metrics-service"
+
+	// DefaultSelfMonitoringFor is the default time a threshold must be
+	// exceeded before alerting on the operator
+	DefaultSelfMonitoringFor = 10 * time.Minute
+
+	// DefaultWebhookLatency is the default 99th percentile of the admission
+	// latency above which the webhooks are considered slow
+	DefaultWebhookLatency = time.Second
+)
+
+// CardinalityBudget limits the number of series the recording rules of a
+// namespace may produce
+type CardinalityBudget struct {
+	// Default is the budget of namespaces without a specific one, 0 means
+	// unlimited
+	Default int
+	// Namespaces are the budgets of specific namespaces
+	Namespaces map[string]int
+	// Refuse leaves the Rules exceeding the budget of their namespace out of
+	// the rendered rule files
+	Refuse bool
+}
+
+// For returns the budget of namespace, 0 meaning unlimited
+func (b CardinalityBudget) For(namespace string) int {
+	if budget, ok := b.Namespaces[namespace]; ok {
+		return budget
+	}
+	return b.Default
+}
+
+// SelfMonitoring configures the Rule alerting on the operator itself
+type SelfMonitoring struct {
+	// Namespace and Name of the Rule
+	Namespace, Name string
+	// Labels of the Rule
+	Labels map[string]string
+	// Job is the job label of the operator metrics
+	Job string
+	// For is how long a threshold must be exceeded before alerting
+	For time.Duration
+	// ReconcileErrors is the number of reconcile errors of a controller
+	// tolerated over 5 minutes
+	ReconcileErrors int
+	// ProblemRules is the number of Rules of a namespace tolerated with a
+	// problem
+	ProblemRules int
+	// WebhookLatency is the 99th percentile of the admission latency above
+	// which the webhooks are slow
+	WebhookLatency time.Duration
+	// ReloadFailures is the number of failed reloads of a PrometheusTarget
+	// tolerated over 1 hour
+	ReloadFailures int
+	// NotLoadedRules is the number of Rules tolerated without being loaded
+	// by a PrometheusTarget
+	NotLoadedRules int
+}
+
+// Settings are the operator settings resulting from a RulesConfig
+type Settings struct {
+	// Selector selects the Rules handled by the operator by label
+	Selector labels.Selector
+	// NamespaceSelector selects the namespaces of the Rules handled by the
+	// operator
+	NamespaceSelector labels.Selector
+	// DefaultInterval is the evaluation interval of groups without one
+	DefaultInterval time.Duration
+	// Output, when set, is the output every Rule is rendered to
+	Output *configv1alpha1.OutputConfig
+	// Labels are injected into every rendered rule
+	Labels map[string]string
+	// NamespaceLabel, when set, is injected into every rendered rule with
+	// the namespace of its Rule as value
+	NamespaceLabel string
+	// Prometheus, when set, is queried to check Rules
+	Prometheus *prometheus.Client
+	// CheckInterval is the period at which Rules are checked against
+	// Prometheus
+	CheckInterval time.Duration
+	// CardinalityBudget limits the series recording rules may produce
+	CardinalityBudget CardinalityBudget
+	// Strict rejects invalid Rules on admission
+	Strict bool
+	// RefuseConflicts leaves the Rules with a Conflict condition out of the
+	// rendered rule files
+	RefuseConflicts bool
+	// EnforceQuotas leaves the Rules with a QuotaExceeded condition out of
+	// the rendered rule files
+	EnforceQuotas bool
+	// AlertmanagerConfigSecret, when set, references the Alertmanager
+	// configuration used to preview the routing of alerts
+	AlertmanagerConfigSecret *configv1alpha1.SecretKeyReference
+	// AlertmanagerGeneratedConfigSecret, when set, references the Secret
+	// the Alertmanager configuration is written to with the InhibitRules
+	AlertmanagerGeneratedConfigSecret *configv1alpha1.SecretKeyReference
+	// Alertmanager, when set, is the server Silences are created in
+	Alertmanager *alertmanager.Client
+	// ExpiredSilenceRetention, when set, is how long expired Silences are
+	// kept before being deleted
+	ExpiredSilenceRetention *time.Duration
+	// SelfMonitoring, when set, configures the Rule alerting on the operator
+	SelfMonitoring *SelfMonitoring
+	// Secrets are the Secrets referenced by the HTTP configurations of
+	// Prometheus and Alertmanager
+	Secrets []types.NamespacedName
+}
+
+// New returns the Settings configured by config. The clients of Prometheus
+// and Alertmanager read the Secrets of their HTTP configuration from clients,
+// which may be nil when none is configured.
+func New(config *configv1alpha1.RulesConfig, clients *httpconfig.Clients) (*Settings, error) {
+	settings := Settings{
+		DefaultInterval: DefaultEvaluationInterval,
+		CheckInterval:   DefaultCheckInterval,
+		CardinalityBudget: CardinalityBudget{
+			Namespaces: config.Prometheus.NamespaceCardinalityBudgets,
+			Refuse:     config.Prometheus.RefuseOverBudget,
+		},
+		Strict:          config.Validation.Strict,
+		RefuseConflicts: config.Validation.RefuseConflicts,
+		EnforceQuotas:   config.Validation.EnforceQuotas,
+		Labels:          config.Labels,
+		NamespaceLabel:  config.NamespaceLabel,
+	}
+
+	var err error
+	if settings.Selector, err = selector(config.Selector); err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+	if settings.NamespaceSelector, err = selector(config.NamespaceSelector); err != nil {
+		return nil, fmt.Errorf("invalid namespaceSelector: %w", err)
+	}
+	if config.DefaultInterval != "" {
+		if settings.DefaultInterval, err = promql.ParseDuration(config.DefaultInterval); err != nil {
+			return nil, fmt.Errorf("invalid defaultInterval: %w", err)
+		}
+	}
+	if output := config.Output; output != nil {
+		if output.Namespace == "" || output.ConfigMap.Name == "" {
+			return nil, fmt.Errorf("output requires a namespace and a configMap name")
+		}
+		if shards := output.ConfigMap.Shards; shards != nil && *shards < 1 {
+			return nil, fmt.Errorf("invalid output configMap shards %v, must be positive", *shards)
+		}
+		settings.Output = output.DeepCopy()
+	}
+	for name := range settings.Labels {
+		if !validation.IsLabelName(name) {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	if settings.NamespaceLabel != "" && !validation.IsLabelName(settings.NamespaceLabel) {
+		return nil, fmt.Errorf("invalid namespaceLabel %q", settings.NamespaceLabel)
+	}
+
+	if config.Prometheus.URL != "" {
+		httpClient, err := settings.httpClient(clients, "config/prometheus", config.Prometheus.HTTPConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prometheus httpConfig: %w", err)
+		}
+		if settings.Prometheus, err = prometheus.NewClient(config.Prometheus.URL, httpClient); err != nil {
+			return nil, err
+		}
+	}
+	if interval := config.Prometheus.CheckInterval; interval != nil {
+		if interval.Duration <= 0 {
+			return nil, fmt.Errorf("invalid prometheus checkInterval %v, must be positive", interval.Duration)
+		}
+		settings.CheckInterval = interval.Duration
+	}
+	if budget := config.Prometheus.CardinalityBudget; budget != nil {
+		settings.CardinalityBudget.Default = *budget
+	}
+	for namespace, budget := range settings.CardinalityBudget.Namespaces {
+		if budget < 0 {
+			return nil, fmt.Errorf("invalid cardinality budget %v for namespace %v", budget, namespace)
+		}
+	}
+	if settings.CardinalityBudget.Default < 0 {
+		return nil, fmt.Errorf("invalid cardinality budget %v", settings.CardinalityBudget.Default)
+	}
+	if ref := config.Alertmanager.ConfigSecret; ref != nil {
+		if ref.Namespace == "" || ref.Name == "" {
+			return nil, fmt.Errorf("alertmanager configSecret requires a namespace and a name")
+		}
+		settings.AlertmanagerConfigSecret = ref.DeepCopy()
+		if settings.AlertmanagerConfigSecret.Key == "" {
+			settings.AlertmanagerConfigSecret.Key = DefaultAlertmanagerConfigKey
+		}
+	}
+	if ref := config.Alertmanager.GeneratedConfigSecret; ref != nil {
+		if settings.AlertmanagerConfigSecret == nil {
+			return nil, fmt.Errorf("alertmanager generatedConfigSecret requires a configSecret")
+		}
+		if ref.Namespace == "" || ref.Name == "" {
+			return nil, fmt.Errorf("alertmanager generatedConfigSecret requires a namespace and a name")
+		}
+		if ref.Namespace == settings.AlertmanagerConfigSecret.Namespace && ref.Name == settings.AlertmanagerConfigSecret.Name {
+			return nil, fmt.Errorf("alertmanager generatedConfigSecret must not be the configSecret")
+		}
+		settings.AlertmanagerGeneratedConfigSecret = ref.DeepCopy()
+		if settings.AlertmanagerGeneratedConfigSecret.Key == "" {
+			settings.AlertmanagerGeneratedConfigSecret.Key = DefaultAlertmanagerConfigKey
+		}
+	}
+	if config.Alertmanager.URL != "" {
+		httpClient, err := settings.httpClient(clients, "config/alertmanager", config.Alertmanager.HTTPConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alertmanager httpConfig: %w", err)
+		}
+		if settings.Alertmanager, err = alertmanager.NewClient(config.Alertmanager.URL, httpClient); err != nil {
+			return nil, err
+		}
+	}
+	if retention := config.Alertmanager.ExpiredSilenceRetention; retention != nil {
+		if retention.Duration < 0 {
+			return nil, fmt.Errorf("invalid alertmanager expiredSilenceRetention %v, must not be negative", retention.Duration)
+		}
+		settings.ExpiredSilenceRetention = &retention.Duration
+	}
+	if config.SelfMonitoring.Enabled {
+		if settings.SelfMonitoring, err = selfMonitoring(&config.SelfMonitoring); err != nil {
+			return nil, err
+		}
+	}
+	return &settings, nil
+}
+
+// httpClient returns the http.Client configured by config, nil for the
+// default one, and records the Secrets it references. The client is cached
+// in clients for owner, the section of the configuration.
+func (s *Settings) httpClient(clients *httpconfig.Clients, owner string, config *configv1alpha1.HTTPClientConfig) (*http.Client, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if err := httpconfig.Validate(&config.HTTPConfig); err != nil {
+		return nil, err
+	}
+	names := httpconfig.SecretNames(&config.HTTPConfig)
+	if len(names) == 0 {
+		// nothing to read nor to rotate
+		return httpconfig.Client(context.Background(), nil, config.Namespace, &config.HTTPConfig)
+	}
+	if config.Namespace == "" {
+		return nil, fmt.Errorf("namespace of the secrets is required")
+	}
+	if clients == nil {
+		return nil, fmt.Errorf("secrets can't be read")
+	}
+	for _, name := range names {
+		s.Secrets = append(s.Secrets, types.NamespacedName{Namespace: config.Namespace, Name: name})
+	}
+	return &http.Client{
+		Transport: httpconfig.Transport(clients, owner, config.Namespace, &config.HTTPConfig),
+		Timeout:   httpconfig.Timeout(&config.HTTPConfig),
+	}, nil
+}
+
+// References reports whether the Secret namespace/name is read by the
+// Prometheus or Alertmanager clients
+func (s *Settings) References(namespace, name string) bool {
+	for _, secret := range s.Secrets {
+		if secret.Namespace == namespace && secret.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selfMonitoring returns the SelfMonitoring settings configured by config
+func selfMonitoring(config *configv1alpha1.SelfMonitoringConfig) (*SelfMonitoring, error) {
+	if config.Namespace == "" {
+		return nil, fmt.Errorf("selfMonitoring requires a namespace")
+	}
+	s := SelfMonitoring{
+		Namespace:      config.Namespace,
+		Name:           config.Name,
+		Labels:         config.Labels,
+		Job:            config.Job,
+		For:            DefaultSelfMonitoringFor,
+		WebhookLatency: DefaultWebhookLatency,
+	}
+	if s.Name == "" {
+		s.Name = DefaultSelfMonitoringName
+	}
+	if s.Job == "" {
+		s.Job = DefaultSelfMonitoringJob
+	}
+	if d := config.For; d != nil {
+		if d.Duration < 0 {
+			return nil, fmt.Errorf("invalid selfMonitoring for %v, must not be negative", d.Duration)
+		}
+		s.For = d.Duration
+	}
+	if n := config.ReconcileErrors; n != nil {
+		if *n < 0 {
+			return nil, fmt.Errorf("invalid selfMonitoring reconcileErrors %v, must not be negative", *n)
+		}
+		s.ReconcileErrors = *n
+	}
+	if n := config.ProblemRules; n != nil {
+		if *n < 0 {
+			return nil, fmt.Errorf("invalid selfMonitoring problemRules %v, must not be negative", *n)
+		}
+		s.ProblemRules = *n
+	}
+	if n := config.ReloadFailures; n != nil {
+		if *n < 0 {
+			return nil, fmt.Errorf("invalid selfMonitoring reloadFailures %v, must not be negative", *n)
+		}
+		s.ReloadFailures = *n
+	}
+	if n := config.NotLoadedRules; n != nil {
+		if *n < 0 {
+			return nil, fmt.Errorf("invalid selfMonitoring notLoadedRules %v, must not be negative", *n)
+		}
+		s.NotLoadedRules = *n
+	}
+	if d := config.WebhookLatency; d != nil {
+		if d.Duration <= 0 {
+			return nil, fmt.Errorf("invalid selfMonitoring webhookLatency %v, must be positive", d.Duration)
+		}
+		s.WebhookLatency = d.Duration
+	}
+	return &s, nil
+}
+
+// selector returns the labels.Selector of s, matching everything when unset
+func selector(s *metav1.LabelSelector) (labels.Selector, error) {
+	if s == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(s)
+}
+
+// Selects reports whether rule is handled by the operator, reading its
+// namespace labels with c when a namespace selector is set.
+func (s *Settings) Selects(ctx context.Context, c client.Reader, rule *monitoringv1alpha1.Rule) (bool, error) {
+	if !s.Selector.Matches(labels.Set(rule.Labels)) {
+		return false, nil
+	}
+	if s.NamespaceSelector.Empty() {
+		return true, nil
+	}
+	var namespace corev1.Namespace
+	if err := c.Get(ctx, types.NamespacedName{Name: rule.Namespace}, &namespace); err != nil {
+		return false, fmt.Errorf("unable to get namespace %v: %w", rule.Namespace, err)
+	}
+	return s.NamespaceSelector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// Inject returns rule with the labels of the settings injected into each of
+// its rules, rule itself when there are none
+func (s *Settings) Inject(rule *monitoringv1alpha1.Rule) *monitoringv1alpha1.Rule {
+	if len(s.Labels) == 0 && s.NamespaceLabel == "" {
+		return rule
+	}
+	injected := rule.DeepCopy()
+	for i := range injected.Spec.Groups {
+		group := &injected.Spec.Groups[i]
+		for j := range group.Rules {
+			def := &group.Rules[j]
+			if def.Labels == nil {
+				def.Labels = make(map[string]string, len(s.Labels)+1)
+			}
+			for name, value := range s.Labels {
+				def.Labels[name] = value
+			}
+			if s.NamespaceLabel != "" {
+				def.Labels[s.NamespaceLabel] = rule.Namespace
+			}
+		}
+	}
+	return injected
+}
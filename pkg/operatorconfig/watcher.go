@@ -0,0 +1,173 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operatorconfig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+)
+
+var log = logf.Log.WithName("operatorconfig")
+
+// Store holds the current Settings and notifies its subscribers when they are
+// replaced. It is safe for concurrent use.
+type Store struct {
+	mu          sync.RWMutex
+	settings    *Settings
+	subscribers []chan event.GenericEvent
+}
+
+// NewStore returns a Store holding settings
+func NewStore(settings *Settings) *Store {
+	return &Store{settings: settings}
+}
+
+// Get returns the current Settings, which must not be modified
+func (s *Store) Get() *Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings
+}
+
+// Set replaces the current Settings and notifies the subscribers
+func (s *Store) Set(settings *Settings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings = settings
+	for _, ch := range s.subscribers {
+		// a pending notification already covers this change
+		select {
+		case ch <- event.GenericEvent{}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving an event without object whenever the
+// Settings are replaced, suitable for a source.Channel
+func (s *Store) Subscribe() <-chan event.GenericEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan event.GenericEvent, 1)
+	s.subscribers = append(s.subscribers, ch)
+	return ch
+}
+
+// Load decodes the configuration file at path into cfg
+func Load(path string, scheme *runtime.Scheme, cfg *configv1alpha1.OperatorConfig) error {
+	loader := config.File().AtPath(path).OfKind(cfg)
+	if err := loader.InjectScheme(scheme); err != nil {
+		return err
+	}
+	if _, err := loader.Complete(); err != nil {
+		return fmt.Errorf("unable to load %v: %w", path, err)
+	}
+	return nil
+}
+
+// Watcher is a manager.Runnable reloading the rules settings of the
+// configuration file into Store whenever the file changes. Invalid
+// configurations are logged and ignored.
+type Watcher struct {
+	Path   string
+	Scheme *runtime.Scheme
+	Store  *Store
+	// Override, when set, is applied to each loaded configuration so that
+	// command line flags take precedence over the file
+	Override func(*configv1alpha1.OperatorConfig)
+	// Clients reads the Secrets of the HTTP configurations
+	Clients *httpconfig.Clients
+
+	content []byte
+}
+
+// Start implements manager.Runnable
+func (w *Watcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// ConfigMap volumes update files by swapping a symlink in their
+	// directory, so watch the directory rather than the file
+	if err := watcher.Add(filepath.Dir(w.Path)); err != nil {
+		return fmt.Errorf("unable to watch %v: %w", w.Path, err)
+	}
+	if w.content, err = ioutil.ReadFile(w.Path); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-watcher.Events:
+			if err := w.reload(); err != nil {
+				log.Error(err, "unable to reload configuration", "path", w.Path)
+			}
+		case err := <-watcher.Errors:
+			log.Error(err, "unable to watch configuration", "path", w.Path)
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, webhooks of
+// every replica use the settings
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// reload replaces the settings of Store when the content of the file changed
+func (w *Watcher) reload() error {
+	content, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(content, w.content) {
+		return nil
+	}
+
+	var cfg configv1alpha1.OperatorConfig
+	if err := Load(w.Path, w.Scheme, &cfg); err != nil {
+		return err
+	}
+	if w.Override != nil {
+		w.Override(&cfg)
+	}
+	settings, err := New(&cfg.Rules, w.Clients)
+	if err != nil {
+		return err
+	}
+	w.content = content
+	w.Store.Set(settings)
+	log.Info("configuration reloaded, controller manager settings require a restart", "path", w.Path)
+	return nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package output renders Rules into the rule files of an output, spread
+// across the ConfigMaps of its shards.
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+)
+
+const (
+	// Key is the key of the rule file in the ConfigMaps
+	Key = "rules.yaml"
+
+	// OutputLabel is set on the ConfigMaps and Secrets written by the
+	// operator, to the kind of output they belong to
+	OutputLabel = "monitoring.cyrilix.fr/output"
+
+	// HashAnnotation is set on the ConfigMaps and Secrets written by the
+	// operator to the hash of the file written, revealing the changes made
+	// outside of the operator
+	HashAnnotation = "monitoring.cyrilix.fr/content-hash"
+
+	// TargetOutput is the value of OutputLabel for the outputs of
+	// PrometheusTargets
+	TargetOutput = "prometheustarget"
+
+	// DefaultOutput is the value of OutputLabel for the output of the
+	// operator settings
+	DefaultOutput = "default"
+
+	// AlertmanagerOutput is the value of OutputLabel for the Secret of the
+	// Alertmanager configuration written with the InhibitRules
+	AlertmanagerOutput = "alertmanager"
+
+	// MaxBytes is the maximum size of a rule file, leaving room for the
+	// metadata of the ConfigMap below its 1MiB limit
+	MaxBytes = 1000 * 1000
+)
+
+// Shards returns the number of shards of config
+func Shards(config *monitoringv1alpha1.ConfigMapOutput) int32 {
+	if config.Shards == nil || *config.Shards < 1 {
+		return 1
+	}
+	return *config.Shards
+}
+
+// Shard returns the shard the Rules of namespace are written to, among
+// shards
+func Shard(namespace string, shards int32) int32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(namespace))
+	return int32(h.Sum32() % uint32(shards))
+}
+
+// ConfigMapName returns the name of the ConfigMap of shard
+func ConfigMapName(config *monitoringv1alpha1.ConfigMapOutput, shard int32) string {
+	return fmt.Sprintf("%v-%d", config.Name, shard)
+}
+
+// Hash returns the value of HashAnnotation for the rule file data
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// File is the rule file of a shard
+type File struct {
+	// Name of the ConfigMap of the shard
+	Name string
+	// Groups written to the file
+	Groups []render.Group
+	// Data is the content of the file
+	Data []byte
+}
+
+// Files returns the rule file of each shard of config defining rules, with
+// externalLabels added to every rule that doesn't set them
+func Files(config *monitoringv1alpha1.ConfigMapOutput, externalLabels map[string]string, rules ...*monitoringv1alpha1.Rule) ([]File, error) {
+	shards := Shards(config)
+	byShard := make([][]*monitoringv1alpha1.Rule, shards)
+	for _, rule := range rules {
+		shard := Shard(rule.Namespace, shards)
+		byShard[shard] = append(byShard[shard], rule)
+	}
+	files := make([]File, 0, shards)
+	for shard, rules := range byShard {
+		file := File{
+			Name:   ConfigMapName(config, int32(shard)),
+			Groups: render.WithExternalLabels(render.Groups(rules...), externalLabels),
+		}
+		var err error
+		if file.Data, err = render.Marshal(file.Groups); err != nil {
+			return nil, fmt.Errorf("unable to render %v: %w", file.Name, err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Locks serializes the renders of each ConfigMap of the outputs between the
+// workers of the controllers and the full sync. It is safe for concurrent
+// use.
+type Locks struct {
+	mu    sync.Mutex
+	locks map[types.NamespacedName]*lock
+}
+
+// lock is the mutex of a ConfigMap, held or waited for by users
+type lock struct {
+	sync.Mutex
+	users int
+}
+
+// NewLocks returns Locks without any ConfigMap locked
+func NewLocks() *Locks {
+	return &Locks{locks: map[types.NamespacedName]*lock{}}
+}
+
+// Lock locks the ConfigMap key and returns the function unlocking it
+func (l *Locks) Lock(key types.NamespacedName) (unlock func()) {
+	l.mu.Lock()
+	k, ok := l.locks[key]
+	if !ok {
+		k = &lock{}
+		l.locks[key] = k
+	}
+	k.users++
+	l.mu.Unlock()
+
+	k.Lock()
+	return func() {
+		k.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		k.users--
+		if k.users == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/render"
+)
+
+func newRule(namespace, name string) *monitoringv1alpha1.Rule {
+	return &monitoringv1alpha1.Rule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: monitoringv1alpha1.RuleSpec{Groups: []monitoringv1alpha1.RuleGroup{{
+			Name:  "group",
+			Rules: []monitoringv1alpha1.RuleDefinition{{Alert: "Down", Expr: "up == 0"}},
+		}}},
+	}
+}
+
+var _ = Describe("Files", func() {
+	It("should write the Rules of a namespace to the same shard", func() {
+		shards := int32(4)
+		config := &monitoringv1alpha1.ConfigMapOutput{Name: "rules", Shards: &shards}
+		files, err := Files(config, nil, newRule("a", "first"), newRule("a", "second"), newRule("b", "first"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(4))
+
+		groups := make(map[string]string)
+		for _, file := range files {
+			Expect(file.Name).To(MatchRegexp(`^rules-[0-3]$`))
+			for _, group := range file.Groups {
+				groups[group.Name] = file.Name
+			}
+		}
+		Expect(groups).To(HaveLen(3))
+		Expect(groups["a/first/group"]).To(Equal(ConfigMapName(config, Shard("a", shards))))
+		Expect(groups["a/second/group"]).To(Equal(groups["a/first/group"]))
+		Expect(groups["b/first/group"]).To(Equal(ConfigMapName(config, Shard("b", shards))))
+	})
+
+	It("should render empty shards and external labels", func() {
+		files, err := Files(&monitoringv1alpha1.ConfigMapOutput{Name: "rules"},
+			map[string]string{"cluster": "main"}, newRule("a", "first"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		Expect(files[0].Name).To(Equal("rules-0"))
+		file, err := render.Unmarshal(files[0].Data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Groups).To(Equal(files[0].Groups))
+		Expect(file.Groups[0].Rules[0].Labels).To(Equal(map[string]string{"cluster": "main"}))
+
+		shards := int32(2)
+		files, err = Files(&monitoringv1alpha1.ConfigMapOutput{Name: "rules", Shards: &shards}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+		Expect(string(files[1].Data)).To(Equal("groups: []\n"))
+	})
+})
+
+var _ = Describe("Locks", func() {
+	It("should serialize the users of a ConfigMap and forget it once released", func() {
+		locks := NewLocks()
+		key := types.NamespacedName{Namespace: "monitoring", Name: "rules-0"}
+		var wg sync.WaitGroup
+		counter := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				unlock := locks.Lock(key)
+				defer unlock()
+				value := counter
+				time.Sleep(time.Millisecond)
+				counter = value + 1
+			}()
+		}
+		wg.Wait()
+		Expect(counter).To(Equal(20))
+		Expect(locks.locks).To(BeEmpty())
+	})
+
+	It("should not serialize different ConfigMaps", func() {
+		locks := NewLocks()
+		unlock := locks.Lock(types.NamespacedName{Namespace: "monitoring", Name: "rules-0"})
+		defer unlock()
+		done := make(chan struct{})
+		go func() {
+			locks.Lock(types.NamespacedName{Namespace: "monitoring", Name: "rules-1"})()
+			close(done)
+		}()
+		Eventually(done).Should(BeClosed())
+	})
+})
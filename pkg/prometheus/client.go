@@ -0,0 +1,266 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package prometheus provides a minimal client for the Prometheus HTTP API,
+// see https://prometheus.io/docs/prometheus/latest/querying/api/
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/cyrilix/prometheus-rules-operator/pkg/httpconfig"
+)
+
+// Client queries the HTTP API of a Prometheus server
+type Client struct {
+	address    *url.URL
+	httpClient *http.Client
+}
+
+// NewClient returns a Client for the Prometheus server listening at address,
+// using httpClient or a client with httpconfig.DefaultTimeout when nil
+func NewClient(address string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Prometheus address %q: %w", address, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Prometheus address %q: scheme must be http or https", address)
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: httpconfig.DefaultTimeout}
+	}
+	return &Client{address: u, httpClient: httpClient}, nil
+}
+
+// Address returns the address of the Prometheus server
+func (c *Client) Address() string {
+	return c.address.String()
+}
+
+// apiResponse is the envelope of every Prometheus API response
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// APIError is returned when Prometheus fails to process a request, for
+// example because of an invalid query
+type APIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%v (status %v): %v", e.Type, e.StatusCode, e.Message)
+}
+
+// Series is a time series returned by a range query
+type Series struct {
+	Metric map[string]string `json:"metric"`
+	Points []Point           `json:"values"`
+}
+
+// Point is a sample of a Series
+type Point struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// UnmarshalJSON decodes a [<unix time>, "<value>"] sample
+func (p *Point) UnmarshalJSON(b []byte) error {
+	var raw [2]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	ts, ok := raw[0].(float64)
+	if !ok {
+		return fmt.Errorf("invalid sample timestamp %v", raw[0])
+	}
+	value, ok := raw[1].(string)
+	if !ok {
+		return fmt.Errorf("invalid sample value %v", raw[1])
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid sample value %q: %w", value, err)
+	}
+	sec, frac := math.Modf(ts)
+	p.Timestamp = time.Unix(int64(sec), int64(math.Round(frac*1e3))*int64(time.Millisecond)).UTC()
+	p.Value = v
+	return nil
+}
+
+// Sample is a series value returned by an instant query
+type Sample struct {
+	Metric map[string]string `json:"metric"`
+	Point  Point             `json:"value"`
+}
+
+// Query evaluates query at ts and returns the resulting instant vector
+func (c *Client) Query(ctx context.Context, query string, ts time.Time) ([]Sample, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", formatTime(ts))
+
+	var data struct {
+		ResultType string   `json:"resultType"`
+		Result     []Sample `json:"result"`
+	}
+	if err := c.get(ctx, "/api/v1/query", params, &data); err != nil {
+		return nil, err
+	}
+	if data.ResultType != "vector" {
+		return nil, fmt.Errorf("unexpected %v result for instant query", data.ResultType)
+	}
+	return data.Result, nil
+}
+
+// QueryRange evaluates query over [start, end] at each step and returns the
+// resulting series
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]Series, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", formatTime(start))
+	params.Set("end", formatTime(end))
+	params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+
+	var data struct {
+		ResultType string   `json:"resultType"`
+		Result     []Series `json:"result"`
+	}
+	if err := c.get(ctx, "/api/v1/query_range", params, &data); err != nil {
+		return nil, err
+	}
+	if data.ResultType != "matrix" {
+		return nil, fmt.Errorf("unexpected %v result for range query", data.ResultType)
+	}
+	return data.Result, nil
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+}
+
+// LabelValues returns the values of label, restricted to the series matching
+// one of the matches selectors when given
+func (c *Client) LabelValues(ctx context.Context, label string, matches ...string) ([]string, error) {
+	params := url.Values{}
+	for _, m := range matches {
+		params.Add("match[]", m)
+	}
+	var values []string
+	if err := c.get(ctx, "/api/v1/label/"+url.PathEscape(label)+"/values", params, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// RuleGroup is a group of rules loaded by Prometheus
+type RuleGroup struct {
+	Name  string       `json:"name"`
+	File  string       `json:"file"`
+	Rules []LoadedRule `json:"rules"`
+}
+
+// LoadedRule is a recording or alerting rule loaded by Prometheus, Name is
+// the recorded metric or the alert name
+type LoadedRule struct {
+	Name   string            `json:"name"`
+	Query  string            `json:"query"`
+	Labels map[string]string `json:"labels"`
+	Type   string            `json:"type"`
+}
+
+// Rules returns the rule groups loaded by Prometheus
+func (c *Client) Rules(ctx context.Context) ([]RuleGroup, error) {
+	var data struct {
+		Groups []RuleGroup `json:"groups"`
+	}
+	if err := c.get(ctx, "/api/v1/rules", nil, &data); err != nil {
+		return nil, err
+	}
+	return data.Groups, nil
+}
+
+// RuntimeInfo is the runtime information of Prometheus about the reloads of
+// its configuration
+type RuntimeInfo struct {
+	// ReloadConfigSuccess is false when the last reload failed
+	ReloadConfigSuccess bool `json:"reloadConfigSuccess"`
+	// LastConfigTime is when the configuration was last loaded successfully
+	LastConfigTime time.Time `json:"lastConfigTime"`
+}
+
+// RuntimeInfo returns the runtime information of Prometheus
+func (c *Client) RuntimeInfo(ctx context.Context) (*RuntimeInfo, error) {
+	var info RuntimeInfo
+	if err := c.get(ctx, "/api/v1/status/runtimeinfo", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// get calls the API endpoint and decodes the data of the response into data
+func (c *Client) get(ctx context.Context, endpoint string, params url.Values, data interface{}) error {
+	u := *c.address
+	u.Path = path.Join(u.Path, endpoint)
+	u.RawQuery = params.Encode()
+
+	if c.httpClient.Timeout == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, httpconfig.DefaultTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("unable to build request for %v: %w", endpoint, err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to call %v: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response of %v: %w", endpoint, err)
+	}
+	var apiResp apiResponse
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return fmt.Errorf("unexpected response of %v with status %v: %w", endpoint, resp.StatusCode, err)
+	}
+	if apiResp.Status != "success" {
+		return fmt.Errorf("%v failed: %w", endpoint,
+			&APIError{StatusCode: resp.StatusCode, Type: apiResp.ErrorType, Message: apiResp.Error})
+	}
+	if err := json.Unmarshal(apiResp.Data, data); err != nil {
+		return fmt.Errorf("unable to decode data of %v: %w", endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cyrilix/prometheus-rules-operator/pkg/prometheus/fake"
+)
+
+var _ = Describe("Client", func() {
+	var server *fake.Prometheus
+	var client *Client
+
+	BeforeEach(func() {
+		server = fake.NewPrometheus("up", "http_requests_total", "job:http_requests:rate5m")
+		var err error
+		client, err = NewClient(server.URL, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should reject invalid addresses", func() {
+		_, err := NewClient("localhost:9090", nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("LabelValues", func() {
+		It("should list all metric names", func() {
+			names, err := client.LabelValues(context.Background(), "__name__")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names).To(Equal([]string{"http_requests_total", "job:http_requests:rate5m", "up"}))
+		})
+
+		It("should restrict values to matching series", func() {
+			names, err := client.LabelValues(context.Background(), "__name__", `{__name__=~"up|down"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names).To(Equal([]string{"up"}))
+		})
+
+		It("should report API errors", func() {
+			_, err := client.LabelValues(context.Background(), "__name__", `up`)
+			var apiErr *APIError
+			Expect(errors.As(err, &apiErr)).To(BeTrue())
+			Expect(apiErr.Type).To(Equal("bad_data"))
+		})
+
+		It("should report unexpected responses", func() {
+			broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "bad gateway", http.StatusBadGateway)
+			}))
+			defer broken.Close()
+			c, err := NewClient(broken.URL, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.LabelValues(context.Background(), "__name__")
+			Expect(err).To(MatchError(ContainSubstring("502")))
+		})
+	})
+
+	Context("Query", func() {
+		now := time.Unix(1600000000, 0).UTC()
+
+		It("should return the current value of each series", func() {
+			server.SetResult(`count(up)`, fake.Series{
+				Metric: map[string]string{},
+				Points: []fake.Point{
+					{Timestamp: now.Add(-time.Minute), Value: 12},
+					{Timestamp: now.Add(time.Minute), Value: 13},
+				},
+			}, fake.Series{
+				Metric: map[string]string{"job": "stale"},
+				Points: []fake.Point{{Timestamp: now.Add(-time.Hour), Value: 1}},
+			})
+
+			samples, err := client.Query(context.Background(), `count(up)`, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(samples).To(Equal([]Sample{{
+				Metric: map[string]string{},
+				Point:  Point{Timestamp: now, Value: 12},
+			}}))
+		})
+	})
+
+	Context("QueryRange", func() {
+		now := time.Unix(1600000000, 0).UTC()
+
+		It("should return the series of the query", func() {
+			server.SetResult(`up == 0`, fake.Series{
+				Metric: map[string]string{"job": "api"},
+				Points: []fake.Point{
+					{Timestamp: now.Add(-2 * time.Hour), Value: 0},
+					{Timestamp: now.Add(-time.Minute), Value: 0},
+					{Timestamp: now, Value: 0.5},
+				},
+			})
+
+			series, err := client.QueryRange(context.Background(), `up == 0`, now.Add(-time.Hour), now, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(series).To(Equal([]Series{{
+				Metric: map[string]string{"job": "api"},
+				Points: []Point{
+					{Timestamp: now.Add(-time.Minute), Value: 0},
+					{Timestamp: now, Value: 0.5},
+				},
+			}}))
+		})
+
+		It("should report invalid queries", func() {
+			_, err := client.QueryRange(context.Background(), `up ==`, now.Add(-time.Hour), now, time.Minute)
+			var apiErr *APIError
+			Expect(errors.As(err, &apiErr)).To(BeTrue())
+		})
+	})
+
+	Context("Rules", func() {
+		It("should return the loaded rule groups", func() {
+			groups, err := client.Rules(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(groups).To(BeEmpty())
+
+			server.SetRuleGroups(fake.RuleGroup{
+				Name: "default/http/http",
+				File: "/etc/prometheus/rules/default.yaml",
+				Rules: []fake.Rule{
+					{Name: "job:http_requests:rate5m", Query: "sum by(job) (rate(http_requests_total[5m]))", Type: "recording"},
+					{Name: "HighErrorRate", Query: "job:http_errors:rate5m > 0.05", Labels: map[string]string{"severity": "page"}, Type: "alerting"},
+				},
+			})
+			groups, err = client.Rules(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(groups).To(Equal([]RuleGroup{{
+				Name: "default/http/http",
+				File: "/etc/prometheus/rules/default.yaml",
+				Rules: []LoadedRule{
+					{Name: "job:http_requests:rate5m", Query: "sum by(job) (rate(http_requests_total[5m]))", Type: "recording"},
+					{Name: "HighErrorRate", Query: "job:http_errors:rate5m > 0.05", Labels: map[string]string{"severity": "page"}, Type: "alerting"},
+				},
+			}}))
+		})
+	})
+
+	Context("RuntimeInfo", func() {
+		It("should report the reloads of the configuration", func() {
+			info, err := client.RuntimeInfo(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ReloadConfigSuccess).To(BeTrue())
+			loaded := info.LastConfigTime
+			Expect(loaded).NotTo(BeZero())
+
+			server.SetReload(false)
+			info, err = client.RuntimeInfo(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ReloadConfigSuccess).To(BeFalse())
+			Expect(info.LastConfigTime).To(BeTemporally("==", loaded))
+
+			server.SetReload(true)
+			info, err = client.RuntimeInfo(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ReloadConfigSuccess).To(BeTrue())
+			Expect(info.LastConfigTime).To(BeTemporally(">=", loaded))
+		})
+	})
+})
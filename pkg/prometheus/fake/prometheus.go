@@ -0,0 +1,312 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake provides an in-memory Prometheus server implementing the
+// subset of the HTTP API used by the operator, for tests.
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Prometheus is a fake Prometheus server. It must be closed once done.
+type Prometheus struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	metrics  map[string]struct{}
+	results  map[string][]Series
+	groups   []RuleGroup
+	runtime  RuntimeInfo
+	requests map[string]int
+}
+
+// RuntimeInfo is the runtime information about the reloads of the
+// configuration reported by the fake server
+type RuntimeInfo struct {
+	ReloadConfigSuccess bool      `json:"reloadConfigSuccess"`
+	LastConfigTime      time.Time `json:"lastConfigTime"`
+}
+
+// RuleGroup is a group of rules loaded by the fake server
+type RuleGroup struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule is a rule loaded by the fake server, Type is recording or alerting
+type Rule struct {
+	Name   string            `json:"name"`
+	Query  string            `json:"query"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Type   string            `json:"type"`
+}
+
+// Series is a time series returned by the fake server for a query
+type Series struct {
+	Metric map[string]string
+	Points []Point
+}
+
+// Point is a sample of a Series
+type Point struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// NewPrometheus starts a fake Prometheus server exposing the given metrics
+func NewPrometheus(metrics ...string) *Prometheus {
+	p := &Prometheus{
+		results:  make(map[string][]Series),
+		requests: make(map[string]int),
+		runtime:  RuntimeInfo{ReloadConfigSuccess: true, LastConfigTime: time.Now()},
+	}
+	p.SetMetrics(metrics...)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/label/__name__/values", p.handleNames)
+	mux.HandleFunc("/api/v1/query", p.handleQuery)
+	mux.HandleFunc("/api/v1/query_range", p.handleQueryRange)
+	mux.HandleFunc("/api/v1/rules", p.handleRules)
+	mux.HandleFunc("/api/v1/status/runtimeinfo", p.handleRuntimeInfo)
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.requests[r.URL.Path]++
+		p.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	return p
+}
+
+// Requests returns the number of requests the server received for path,
+// like /api/v1/rules
+func (p *Prometheus) Requests(path string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests[path]
+}
+
+// SetMetrics replaces the metric names known by the server
+func (p *Prometheus) SetMetrics(metrics ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metrics = make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		p.metrics[m] = struct{}{}
+	}
+}
+
+// SetResult sets the series returned when evaluating query. Queries without
+// result fail with a bad_data error.
+func (p *Prometheus) SetResult(query string, series ...Series) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results[query] = series
+}
+
+// SetRuleGroups replaces the rule groups loaded by the server
+func (p *Prometheus) SetRuleGroups(groups ...RuleGroup) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.groups = groups
+}
+
+func (p *Prometheus) handleRules(w http.ResponseWriter, _ *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	groups := p.groups
+	if groups == nil {
+		groups = []RuleGroup{}
+	}
+	writeData(w, map[string]interface{}{"groups": groups})
+}
+
+// SetReload records a reload of the configuration, successful or not. A
+// successful reload updates the last configuration time.
+func (p *Prometheus) SetReload(success bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runtime.ReloadConfigSuccess = success
+	if success {
+		p.runtime.LastConfigTime = time.Now()
+	}
+}
+
+func (p *Prometheus) handleRuntimeInfo(w http.ResponseWriter, _ *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	writeData(w, p.runtime)
+}
+
+// lookbackDelta is how far back a sample is considered current by an
+// instant query
+const lookbackDelta = 5 * time.Minute
+
+func (p *Prometheus) handleQuery(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("query")
+	ts, err := parseTime(r.FormValue("time"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_data", "invalid time parameter")
+		return
+	}
+
+	p.mu.Lock()
+	series, ok := p.results[query]
+	p.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, "bad_data", "unknown query "+query)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(series))
+	for _, s := range series {
+		var latest *Point
+		for i, point := range s.Points {
+			if point.Timestamp.After(ts) || ts.Sub(point.Timestamp) > lookbackDelta {
+				continue
+			}
+			if latest == nil || point.Timestamp.After(latest.Timestamp) {
+				latest = &s.Points[i]
+			}
+		}
+		if latest == nil {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"metric": s.Metric,
+			"value": [2]interface{}{
+				float64(ts.UnixNano()) / 1e9,
+				strconv.FormatFloat(latest.Value, 'f', -1, 64),
+			},
+		})
+	}
+	writeData(w, map[string]interface{}{"resultType": "vector", "result": result})
+}
+
+func (p *Prometheus) handleQueryRange(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("query")
+	start, errStart := parseTime(r.FormValue("start"))
+	end, errEnd := parseTime(r.FormValue("end"))
+	if errStart != nil || errEnd != nil || r.FormValue("step") == "" {
+		writeError(w, http.StatusBadRequest, "bad_data", "invalid start, end or step parameter")
+		return
+	}
+
+	p.mu.Lock()
+	series, ok := p.results[query]
+	p.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, "bad_data", "unknown query "+query)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(series))
+	for _, s := range series {
+		values := make([][2]interface{}, 0, len(s.Points))
+		for _, point := range s.Points {
+			if point.Timestamp.Before(start) || point.Timestamp.After(end) {
+				continue
+			}
+			values = append(values, [2]interface{}{
+				float64(point.Timestamp.UnixNano()) / 1e9,
+				strconv.FormatFloat(point.Value, 'f', -1, 64),
+			})
+		}
+		if len(values) == 0 {
+			continue
+		}
+		result = append(result, map[string]interface{}{"metric": s.Metric, "values": values})
+	}
+	writeData(w, map[string]interface{}{"resultType": "matrix", "result": result})
+}
+
+func parseTime(s string) (time.Time, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(f*1e9)), nil
+}
+
+// nameMatcher extracts the __name__ matcher of a series selector
+var nameMatcher = regexp.MustCompile(`__name__\s*(=~|=)\s*"([^"]*)"`)
+
+func (p *Prometheus) handleNames(w http.ResponseWriter, r *http.Request) {
+	var filters []*regexp.Regexp
+	for _, match := range r.URL.Query()["match[]"] {
+		m := nameMatcher.FindStringSubmatch(match)
+		if m == nil {
+			writeError(w, http.StatusBadRequest, "bad_data", "unsupported selector "+match)
+			return
+		}
+		expr := regexp.QuoteMeta(m[2])
+		if m[1] == "=~" {
+			expr = m[2]
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_data", err.Error())
+			return
+		}
+		filters = append(filters, re)
+	}
+
+	p.mu.Lock()
+	names := make([]string, 0, len(p.metrics))
+	for name := range p.metrics {
+		if matchesAny(filters, name) {
+			names = append(names, name)
+		}
+	}
+	p.mu.Unlock()
+	sort.Strings(names)
+	writeData(w, names)
+}
+
+func matchesAny(filters []*regexp.Regexp, value string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if f.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": data})
+}
+
+func writeError(w http.ResponseWriter, code int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "error",
+		"errorType": errorType,
+		"error":     message,
+	})
+}
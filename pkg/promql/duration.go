@@ -0,0 +1,76 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var durationRE = regexp.MustCompile(`^(([0-9]+)y)?(([0-9]+)w)?(([0-9]+)d)?(([0-9]+)h)?(([0-9]+)m)?(([0-9]+)s)?(([0-9]+)ms)?$`)
+
+// durationUnits are the units of the capture groups of durationRE
+var durationUnits = []time.Duration{
+	365 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour,
+	time.Hour, time.Minute, time.Second, time.Millisecond,
+}
+
+// ParseDuration parses a duration in the Prometheus format, like 1d or 5m30s,
+// see https://prometheus.io/docs/prometheus/latest/querying/basics/#time-durations
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "0" {
+		return 0, nil
+	}
+	m := durationRE.FindStringSubmatch(s)
+	if s == "" || m == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var d time.Duration
+	for i, unit := range durationUnits {
+		value := m[2*i+2]
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// durationUnitNames are the names of durationUnits
+var durationUnitNames = []string{"y", "w", "d", "h", "m", "s", "ms"}
+
+// FormatDuration formats d in the Prometheus format, like 1h30m, truncated to
+// the millisecond
+func FormatDuration(d time.Duration) string {
+	if d < time.Millisecond {
+		return "0s"
+	}
+	var s string
+	for i, unit := range durationUnits {
+		if n := d / unit; n > 0 {
+			s += strconv.FormatInt(int64(n), 10) + durationUnitNames[i]
+			d -= n * unit
+		}
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package promql
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseDuration", func() {
+	table.DescribeTable("parses Prometheus durations",
+		func(s string, expected time.Duration) {
+			d, err := ParseDuration(s)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d).To(Equal(expected))
+		},
+		table.Entry("zero", "0", time.Duration(0)),
+		table.Entry("minutes", "5m", 5*time.Minute),
+		table.Entry("days", "1d", 24*time.Hour),
+		table.Entry("compound", "1w2d3h4m5s6ms",
+			9*24*time.Hour+3*time.Hour+4*time.Minute+5*time.Second+6*time.Millisecond),
+	)
+
+	table.DescribeTable("rejects invalid durations",
+		func(s string) {
+			_, err := ParseDuration(s)
+			Expect(err).To(HaveOccurred())
+		},
+		table.Entry("empty", ""),
+		table.Entry("no unit", "5"),
+		table.Entry("wrong order", "5m1h"),
+		table.Entry("fractional", "1.5h"),
+	)
+})
+
+var _ = Describe("FormatDuration", func() {
+	table.DescribeTable("formats Prometheus durations",
+		func(d time.Duration, expected string) {
+			Expect(FormatDuration(d)).To(Equal(expected))
+			parsed, err := ParseDuration(expected)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed).To(Equal(d.Truncate(time.Millisecond)))
+		},
+		table.Entry("zero", time.Duration(0), "0s"),
+		table.Entry("minutes", 5*time.Minute, "5m"),
+		table.Entry("compound", 9*24*time.Hour+90*time.Minute+500*time.Millisecond, "1w2d1h30m500ms"),
+		table.Entry("truncated", 1500*time.Microsecond, "1ms"),
+	)
+})
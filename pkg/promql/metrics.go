@@ -0,0 +1,308 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package promql provides lightweight helpers to inspect PromQL expressions
+// without depending on the Prometheus query engine.
+package promql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// keywords are identifiers that are never metric names. Like the other
+// keyword sets, they are lower case and matched case-insensitively, as
+// PromQL does.
+var keywords = map[string]struct{}{
+	"and": {}, "or": {}, "unless": {}, "atan2": {},
+	"bool": {}, "offset": {}, "inf": {}, "nan": {},
+	"group_left": {}, "group_right": {},
+}
+
+// labelListKeywords are followed by a parenthesized list of label names
+var labelListKeywords = map[string]struct{}{
+	"by": {}, "without": {}, "on": {}, "ignoring": {},
+	"group_left": {}, "group_right": {},
+}
+
+// aggregations may be followed by a by/without clause before their arguments
+var aggregations = map[string]struct{}{
+	"sum": {}, "min": {}, "max": {}, "avg": {}, "group": {}, "stddev": {},
+	"stdvar": {}, "count": {}, "count_values": {}, "bottomk": {}, "topk": {},
+	"quantile": {},
+}
+
+// MetricNames returns the sorted, deduplicated metric names selected by expr,
+// either as plain vector selectors or through a __name__ equality matcher.
+func MetricNames(expr string) ([]string, error) {
+	l := lexer{input: expr}
+	set := make(map[string]struct{})
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		switch tok.kind {
+		case tokenEOF:
+			names := make([]string, 0, len(set))
+			for name := range set {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return names, nil
+		case tokenMatchers:
+			if name := nameMatcher(tok.value); name != "" {
+				set[name] = struct{}{}
+			}
+		case tokenIdentifier:
+			next := l.peek()
+			word := strings.ToLower(tok.value)
+			if _, ok := labelListKeywords[word]; ok {
+				if next == '(' {
+					if err := l.skipGroup('(', ')'); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			if _, ok := keywords[word]; ok {
+				continue
+			}
+			if next == '(' {
+				// function call or aggregation
+				continue
+			}
+			if _, ok := aggregations[word]; ok && l.peekIdentifierIn(labelListKeywords) {
+				continue
+			}
+			set[tok.value] = struct{}{}
+		}
+	}
+}
+
+// nameMatcher returns the value of a __name__="..." matcher in the label
+// matchers of a selector, without the surrounding braces
+func nameMatcher(matchers string) string {
+	l := lexer{input: matchers}
+	for {
+		tok, err := l.next()
+		if err != nil || tok.kind == tokenEOF {
+			return ""
+		}
+		if tok.kind != tokenIdentifier || tok.value != "__name__" {
+			continue
+		}
+		l.skipSpaces()
+		if !strings.HasPrefix(l.input[l.pos:], "=") || strings.HasPrefix(l.input[l.pos:], "=~") {
+			return ""
+		}
+		l.pos++
+		value, err := l.next()
+		if err != nil || value.kind != tokenString {
+			return ""
+		}
+		return value.value
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenMatchers
+	tokenOther
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
+
+func (l *lexer) skipSpaces() {
+	for l.pos < len(l.input) {
+		switch c := l.input[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next non blank character, or 0 at the end of input
+func (l *lexer) peek() byte {
+	l.skipSpaces()
+	if l.pos >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos]
+}
+
+// peekIdentifierIn reports whether the next token is an identifier of set,
+// ignoring case
+func (l *lexer) peekIdentifierIn(set map[string]struct{}) bool {
+	saved := l.pos
+	defer func() { l.pos = saved }()
+	tok, err := l.next()
+	if err != nil || tok.kind != tokenIdentifier {
+		return false
+	}
+	_, ok := set[strings.ToLower(tok.value)]
+	return ok
+}
+
+// skipGroup skips a balanced open...close group starting at the next token
+func (l *lexer) skipGroup(open, close byte) error {
+	start := l.pos
+	depth := 0
+	for l.pos < len(l.input) {
+		switch c := l.input[l.pos]; c {
+		case '"', '\'', '`':
+			if _, err := l.readString(); err != nil {
+				return err
+			}
+			continue
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				l.pos++
+				return nil
+			}
+		}
+		l.pos++
+	}
+	return fmt.Errorf("unclosed %q at position %d", open, start)
+}
+
+func (l *lexer) readString() (string, error) {
+	start := l.pos
+	quote := l.input[l.pos]
+	var b strings.Builder
+	for l.pos++; l.pos < len(l.input); l.pos++ {
+		c := l.input[l.pos]
+		switch {
+		case c == '\\' && quote != '`' && l.pos+1 < len(l.input):
+			l.pos++
+			b.WriteByte(l.input[l.pos])
+		case c == quote:
+			l.pos++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string at position %d", start)
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpaces()
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEOF}, nil
+	}
+	start := l.pos
+	switch c := l.input[l.pos]; {
+	case c == '"' || c == '\'' || c == '`':
+		s, err := l.readString()
+		if err != nil {
+			return token{}, err
+		}
+		return token{kind: tokenString, value: s}, nil
+	case c == '{':
+		if err := l.skipGroup('{', '}'); err != nil {
+			return token{}, err
+		}
+		return token{kind: tokenMatchers, value: l.input[start+1 : l.pos-1]}, nil
+	case c == '[':
+		if err := l.skipGroup('[', ']'); err != nil {
+			return token{}, err
+		}
+		return token{kind: tokenOther}, nil
+	case isIdentifierStart(c):
+		for l.pos < len(l.input) && isIdentifierChar(l.input[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenIdentifier, value: l.input[start:l.pos]}, nil
+	case (c >= '0' && c <= '9') || c == '.':
+		// numbers and durations, including exponents like 1e-3
+		for l.pos < len(l.input) {
+			d := l.input[l.pos]
+			if isIdentifierChar(d) || d == '.' {
+				l.pos++
+				continue
+			}
+			if (d == '+' || d == '-') && (l.input[l.pos-1] == 'e' || l.input[l.pos-1] == 'E') {
+				l.pos++
+				continue
+			}
+			break
+		}
+		return token{kind: tokenOther, value: l.input[start:l.pos]}, nil
+	default:
+		l.pos++
+		return token{kind: tokenOther, value: l.input[start:l.pos]}, nil
+	}
+}
+
+// Check reports the lexical errors of expr: unterminated strings, unbalanced
+// brackets and empty expressions. It doesn't check the PromQL grammar.
+func Check(expr string) error {
+	l := lexer{input: expr}
+	depth, empty := 0, true
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tok.kind == tokenEOF && empty:
+			return fmt.Errorf("empty expression")
+		case tok.kind == tokenEOF && depth > 0:
+			return fmt.Errorf("unclosed '('")
+		case tok.kind == tokenEOF:
+			return nil
+		case tok.value == "(":
+			depth++
+		case tok.value == ")":
+			if depth == 0 {
+				return fmt.Errorf("unexpected ')' at position %d", l.pos-1)
+			}
+			depth--
+		}
+		empty = false
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package promql
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MetricNames", func() {
+	table.DescribeTable("extracts selected metric names",
+		func(expr string, expected []string) {
+			names, err := MetricNames(expr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names).To(Equal(expected))
+		},
+		table.Entry("plain selector", `up`, []string{"up"}),
+		table.Entry("selector with matchers", `up{job="api", instance=~".+:9090"} == 0`, []string{"up"}),
+		table.Entry("range vector in function", `rate(http_requests_total[5m])`, []string{"http_requests_total"}),
+		table.Entry("aggregation with leading clause",
+			`sum by (job, code) (rate(http_requests_total{code=~"5.."}[5m])) / job:http_requests:rate5m`,
+			[]string{"http_requests_total", "job:http_requests:rate5m"}),
+		table.Entry("aggregation with trailing clause", `max(node_load1) without (cpu)`, []string{"node_load1"}),
+		table.Entry("vector matching",
+			`node_filesystem_avail_bytes * on(instance) group_left(nodename) node_uname_info`,
+			[]string{"node_filesystem_avail_bytes", "node_uname_info"}),
+		table.Entry("name matcher", `{__name__="up", job="api"}`, []string{"up"}),
+		table.Entry("regexp name matcher is ignored", `{__name__=~"up|down"}`, []string{}),
+		table.Entry("offset, subquery and numbers",
+			`avg_over_time(a:b:c[1h:5m] offset 1d) > 1e-3 and bool b`,
+			[]string{"a:b:c", "b"}),
+		table.Entry("strings and comments",
+			"label_replace(up, \"dst\", \"$1\", \"src\", \"(.*)\") # not_a_metric",
+			[]string{"up"}),
+		table.Entry("aggregation name used as a metric", `count > 1`, []string{"count"}),
+		table.Entry("upper case keywords",
+			`SUM BY (job) (up) > Inf AND ON (job) NaN < BOOL down UNLESS IGNORING (a) GROUP_LEFT (b) c`,
+			[]string{"c", "down", "up"}),
+		table.Entry("duplicates", `up + up`, []string{"up"}),
+	)
+
+	It("should fail on unterminated expressions", func() {
+		_, err := MetricNames(`up{job="api"`)
+		Expect(err).To(HaveOccurred())
+		_, err = MetricNames(`label_replace(up, "dst)`)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Check", func() {
+	It("should accept well formed expressions", func() {
+		Expect(Check(`sum by (job) (rate(http_requests_total{code=~"5.."}[5m])) > 0`)).To(Succeed())
+	})
+
+	table.DescribeTable("rejects malformed expressions",
+		func(expr string) {
+			Expect(Check(expr)).NotTo(Succeed())
+		},
+		table.Entry("empty", ` # comment only`),
+		table.Entry("unclosed parenthesis", `sum(rate(up[5m])`),
+		table.Entry("unexpected parenthesis", `up)`),
+		table.Entry("unclosed matchers", `up{job="api"`),
+	)
+})
@@ -0,0 +1,88 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package quota computes the usage of Rules and checks it against the limits
+// of RuleQuotas. It is shared by the validating webhook and the controllers.
+package quota
+
+import (
+	"fmt"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/promql"
+)
+
+// Usage returns the number of groups and rules defined by rules
+func Usage(rules ...*monitoringv1alpha1.Rule) monitoringv1alpha1.RuleQuotaUsage {
+	var usage monitoringv1alpha1.RuleQuotaUsage
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		usage.Groups += int32(len(rule.Spec.Groups))
+		for _, group := range rule.Spec.Groups {
+			usage.Rules += int32(len(group.Rules))
+		}
+	}
+	return usage
+}
+
+// Exceeded returns a description of each limit of q exceeded by usage and
+// growing compared to previous, so that changes that don't increase an
+// already exceeded usage are still allowed.
+func Exceeded(q *monitoringv1alpha1.RuleQuota, previous, usage monitoringv1alpha1.RuleQuotaUsage) []string {
+	var exceeded []string
+	if limit := q.Spec.MaxGroups; limit != nil && usage.Groups > *limit && usage.Groups > previous.Groups {
+		exceeded = append(exceeded, fmt.Sprintf("%v groups exceed the limit of %v set by RuleQuota %v",
+			usage.Groups, *limit, q.Name))
+	}
+	if limit := q.Spec.MaxRules; limit != nil && usage.Rules > *limit && usage.Rules > previous.Rules {
+		exceeded = append(exceeded, fmt.Sprintf("%v rules exceed the limit of %v set by RuleQuota %v",
+			usage.Rules, *limit, q.Name))
+	}
+	return exceeded
+}
+
+// IntervalViolations returns a description of each group of rule evaluated
+// more often than allowed by q. An invalid minimum interval is reported as a
+// violation, so that the quota fails closed without failing the admission of
+// the Rules of the namespace.
+func IntervalViolations(q *monitoringv1alpha1.RuleQuota, rule *monitoringv1alpha1.Rule) []string {
+	if q.Spec.MinInterval == "" {
+		return nil
+	}
+	minInterval, err := promql.ParseDuration(q.Spec.MinInterval)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid minInterval of RuleQuota %v: %v", q.Name, err)}
+	}
+
+	var violations []string
+	for _, group := range rule.Spec.Groups {
+		if group.Interval == "" {
+			continue
+		}
+		interval, err := promql.ParseDuration(group.Interval)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("group %q: %v", group.Name, err))
+			continue
+		}
+		if interval < minInterval {
+			violations = append(violations, fmt.Sprintf("group %q interval %v is below the minimum of %v set by RuleQuota %v",
+				group.Name, group.Interval, q.Spec.MinInterval, q.Name))
+		}
+	}
+	return violations
+}
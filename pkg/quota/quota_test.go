@@ -0,0 +1,83 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+func newRule(intervals ...string) *monitoringv1alpha1.Rule {
+	rule := &monitoringv1alpha1.Rule{ObjectMeta: metav1.ObjectMeta{Name: "rule", Namespace: "ns"}}
+	for _, interval := range intervals {
+		rule.Spec.Groups = append(rule.Spec.Groups, monitoringv1alpha1.RuleGroup{
+			Name:     "group-" + interval,
+			Interval: interval,
+			Rules: []monitoringv1alpha1.RuleDefinition{
+				{Record: "job:up:sum", Expr: "sum by (job) (up)"},
+				{Alert: "Down", Expr: "up == 0"},
+			},
+		})
+	}
+	return rule
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+var _ = Describe("Quota", func() {
+	q := &monitoringv1alpha1.RuleQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "ns"},
+		Spec: monitoringv1alpha1.RuleQuotaSpec{
+			MaxGroups:   int32Ptr(2),
+			MaxRules:    int32Ptr(3),
+			MinInterval: "30s",
+		},
+	}
+
+	It("should count groups and rules", func() {
+		Expect(Usage(newRule("1m"), nil, newRule("1m", "2m"))).To(Equal(
+			monitoringv1alpha1.RuleQuotaUsage{Groups: 3, Rules: 6}))
+	})
+
+	It("should report exceeded limits", func() {
+		exceeded := Exceeded(q, monitoringv1alpha1.RuleQuotaUsage{}, monitoringv1alpha1.RuleQuotaUsage{Groups: 3, Rules: 3})
+		Expect(exceeded).To(ConsistOf(ContainSubstring("3 groups exceed the limit of 2")))
+	})
+
+	It("should allow usage that doesn't grow", func() {
+		usage := monitoringv1alpha1.RuleQuotaUsage{Groups: 3, Rules: 6}
+		Expect(Exceeded(q, usage, usage)).To(BeEmpty())
+		Expect(Exceeded(q, usage, monitoringv1alpha1.RuleQuotaUsage{Groups: 3, Rules: 7})).To(
+			ConsistOf(ContainSubstring("7 rules")))
+	})
+
+	It("should report groups evaluated too often", func() {
+		Expect(IntervalViolations(q, newRule("10s", "1m", ""))).To(ConsistOf(ContainSubstring(`group "group-10s" interval 10s is below the minimum of 30s`)))
+	})
+
+	It("should report invalid quotas as violations", func() {
+		invalid := q.DeepCopy()
+		invalid.Spec.MinInterval = "soon"
+		Expect(IntervalViolations(invalid, newRule("1m"))).To(
+			ConsistOf(ContainSubstring("invalid minInterval of RuleQuota quota")))
+	})
+})
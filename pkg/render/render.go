@@ -0,0 +1,160 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render renders Rules into a Prometheus rule file, see
+// https://prometheus.io/docs/prometheus/latest/configuration/recording_rules/
+package render
+
+import (
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/merge"
+)
+
+// File is a Prometheus rule file, its fields are rendered in the order of
+// the Prometheus documentation
+type File struct {
+	Groups []Group `yaml:"groups"`
+}
+
+// Group is a group of a Prometheus rule file
+type Group struct {
+	Name        string `yaml:"name"`
+	Interval    string `yaml:"interval,omitempty"`
+	QueryOffset string `yaml:"query_offset,omitempty"`
+	Rules       []Rule `yaml:"rules"`
+}
+
+// Rule is a recording or alerting rule of a Prometheus rule file
+type Rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// fileRules converts definitions to the rules of a rule file
+func fileRules(definitions []monitoringv1alpha1.RuleDefinition) []Rule {
+	rules := make([]Rule, 0, len(definitions))
+	for _, def := range definitions {
+		rules = append(rules, Rule{
+			Record:      def.Record,
+			Alert:       def.Alert,
+			Expr:        def.Expr,
+			For:         def.For,
+			Labels:      def.Labels,
+			Annotations: def.Annotations,
+		})
+	}
+	return rules
+}
+
+// GroupName returns the name of the rendered group of a Rule, unique in the
+// rule file
+func GroupName(namespace, rule, group string) string {
+	return namespace + "/" + rule + "/" + group
+}
+
+// MergedGroupName returns the name of the rendered group merged from the
+// groups of a namespace sharing key
+func MergedGroupName(namespace, key string) string {
+	return namespace + "/" + key
+}
+
+// Groups returns the groups of the rule file defining rules, sorted by name.
+// Groups with a merge key are merged, see merge.Groups.
+func Groups(rules ...*monitoringv1alpha1.Rule) []Group {
+	var groups []Group
+	for _, rule := range rules {
+		for _, group := range rule.Spec.Groups {
+			if group.MergeKey != "" {
+				continue
+			}
+			groups = append(groups, Group{
+				Name:        GroupName(rule.Namespace, rule.Name, group.Name),
+				Interval:    group.Interval,
+				QueryOffset: group.QueryOffset,
+				Rules:       fileRules(group.Rules),
+			})
+		}
+	}
+	for _, merged := range merge.Groups(rules...) {
+		groups = append(groups, Group{
+			Name:        MergedGroupName(merged.Namespace, merged.Name),
+			Interval:    merged.Interval,
+			QueryOffset: merged.QueryOffset,
+			Rules:       fileRules(merged.Rules()),
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// WithExternalLabels returns a copy of groups where labels are added to
+// every rule that doesn't set them
+func WithExternalLabels(groups []Group, labels map[string]string) []Group {
+	if len(labels) == 0 {
+		return groups
+	}
+	result := make([]Group, 0, len(groups))
+	for _, group := range groups {
+		rules := make([]Rule, 0, len(group.Rules))
+		for _, rule := range group.Rules {
+			merged := make(map[string]string, len(labels)+len(rule.Labels))
+			for name, value := range labels {
+				merged[name] = value
+			}
+			for name, value := range rule.Labels {
+				merged[name] = value
+			}
+			rule.Labels = merged
+			rules = append(rules, rule)
+		}
+		group.Rules = rules
+		result = append(result, group)
+	}
+	return result
+}
+
+// Render returns the Prometheus rule file defining rules
+func Render(rules ...*monitoringv1alpha1.Rule) ([]byte, error) {
+	return Marshal(Groups(rules...))
+}
+
+// Marshal returns the Prometheus rule file holding groups
+func Marshal(groups []Group) ([]byte, error) {
+	file := File{Groups: groups}
+	if file.Groups == nil {
+		file.Groups = []Group{}
+	}
+	return yaml.Marshal(&file)
+}
+
+// Unmarshal parses a Prometheus rule file, refusing unknown fields
+func Unmarshal(data []byte) (*File, error) {
+	var file File
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
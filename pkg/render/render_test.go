@@ -0,0 +1,102 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+var _ = Describe("Render", func() {
+	It("should render an empty file", func() {
+		Expect(Render()).To(MatchYAML("groups: []"))
+	})
+
+	It("should render the groups of the Rules", func() {
+		rules := []*monitoringv1alpha1.Rule{
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "api"},
+				Spec: monitoringv1alpha1.RuleSpec{Groups: []monitoringv1alpha1.RuleGroup{{
+					Name:     "shared-part",
+					MergeKey: "shared",
+					Priority: 1,
+					Rules: []monitoringv1alpha1.RuleDefinition{
+						{Alert: "Down", Expr: "job:up:sum == 0", For: "5m",
+							Labels:      map[string]string{"severity": "critical"},
+							Annotations: map[string]string{"summary": "down"}},
+					},
+				}}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "web"},
+				Spec: monitoringv1alpha1.RuleSpec{Groups: []monitoringv1alpha1.RuleGroup{{
+					Name:        "web",
+					Interval:    "30s",
+					QueryOffset: "1m",
+					Rules: []monitoringv1alpha1.RuleDefinition{
+						{Record: "job:http_requests:rate5m", Expr: "sum by (job) (rate(http_requests_total[5m]))"},
+					},
+				}}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "base"},
+				Spec: monitoringv1alpha1.RuleSpec{Groups: []monitoringv1alpha1.RuleGroup{{
+					Name:     "base-part",
+					MergeKey: "shared",
+					Rules: []monitoringv1alpha1.RuleDefinition{
+						{Record: "job:up:sum", Expr: "sum by (job) (up)"},
+					},
+				}}},
+			},
+		}
+		Expect(Render(rules...)).To(MatchYAML(`
+groups:
+- name: team-a/web/web
+  interval: 30s
+  query_offset: 1m
+  rules:
+  - record: job:http_requests:rate5m
+    expr: sum by (job) (rate(http_requests_total[5m]))
+- name: team-b/shared
+  rules:
+  - record: job:up:sum
+    expr: sum by (job) (up)
+  - alert: Down
+    expr: job:up:sum == 0
+    for: 5m
+    labels:
+      severity: critical
+    annotations:
+      summary: down
+`))
+	})
+
+	It("should add the external labels to the rules", func() {
+		groups := []Group{{Name: "g", Rules: []Rule{
+			{Record: "job:up:sum", Expr: "sum by (job) (up)"},
+			{Alert: "Down", Expr: "up == 0", Labels: map[string]string{"cluster": "local", "severity": "page"}},
+		}}}
+		Expect(WithExternalLabels(groups, map[string]string{"cluster": "prod"})).To(Equal([]Group{{Name: "g", Rules: []Rule{
+			{Record: "job:up:sum", Expr: "sum by (job) (up)", Labels: map[string]string{"cluster": "prod"}},
+			{Alert: "Down", Expr: "up == 0", Labels: map[string]string{"cluster": "local", "severity": "page"}},
+		}}}))
+		Expect(groups[0].Rules[0].Labels).To(BeNil())
+	})
+})
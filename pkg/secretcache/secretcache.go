@@ -0,0 +1,181 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secretcache builds the cache of the manager so that only the
+// Secrets labeled for the operator are listed and watched, instead of every
+// Secret of the cluster.
+package secretcache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultResync is the resync period of the Secrets when the options of the
+// manager don't set one, like the default cache
+const defaultResync = 10 * time.Hour
+
+var secretKind = corev1.SchemeGroupVersion.WithKind("Secret")
+
+// New returns a cache.NewCacheFunc building the default cache of the
+// manager, except for the Secrets: only the Secrets matching selector are
+// listed and watched.
+func New(selector labels.Selector) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		delegate, err := cache.New(config, opts)
+		if err != nil {
+			return nil, err
+		}
+		c, err := corev1client.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build the Secrets client: %w", err)
+		}
+		resync := defaultResync
+		if opts.Resync != nil {
+			resync = *opts.Resync
+		}
+		return newCache(delegate, listWatch(c, opts.Namespace, selector), resync), nil
+	}
+}
+
+// listWatch lists and watches the Secrets of namespace, every namespace when
+// empty, matching selector
+func listWatch(c corev1client.SecretsGetter, namespace string, selector labels.Selector) toolscache.ListerWatcher {
+	return &toolscache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = selector.String()
+			return c.Secrets(namespace).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = selector.String()
+			return c.Secrets(namespace).Watch(context.Background(), options)
+		},
+	}
+}
+
+// secretCache reads the Secrets from its own informer and the other objects
+// from the default cache
+type secretCache struct {
+	cache.Cache
+	informer toolscache.SharedIndexInformer
+}
+
+func newCache(delegate cache.Cache, lw toolscache.ListerWatcher, resync time.Duration) *secretCache {
+	informer := toolscache.NewSharedIndexInformer(lw, &corev1.Secret{}, resync, toolscache.Indexers{
+		toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc,
+	})
+	return &secretCache{Cache: delegate, informer: informer}
+}
+
+// Get implements client.Reader
+func (c *secretCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return c.Cache.Get(ctx, key, obj)
+	}
+	item, exists, err := c.informer.GetIndexer().GetByKey(key.String())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apierrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+	}
+	item.(*corev1.Secret).DeepCopyInto(secret)
+	secret.SetGroupVersionKind(secretKind)
+	return nil
+}
+
+// List implements client.Reader. Like the default cache, it ignores the
+// limit of the options.
+func (c *secretCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	secrets, ok := list.(*corev1.SecretList)
+	if !ok {
+		return c.Cache.List(ctx, list, opts...)
+	}
+	var options client.ListOptions
+	options.ApplyOptions(opts)
+	if options.FieldSelector != nil && !options.FieldSelector.Empty() {
+		return fmt.Errorf("field selectors are not supported on the cached Secrets")
+	}
+
+	var items []interface{}
+	if options.Namespace != "" {
+		var err error
+		if items, err = c.informer.GetIndexer().ByIndex(toolscache.NamespaceIndex, options.Namespace); err != nil {
+			return err
+		}
+	} else {
+		items = c.informer.GetIndexer().List()
+	}
+	secrets.Items = make([]corev1.Secret, 0, len(items))
+	for _, item := range items {
+		secret := item.(*corev1.Secret)
+		if options.LabelSelector != nil && !options.LabelSelector.Matches(labels.Set(secret.Labels)) {
+			continue
+		}
+		secrets.Items = append(secrets.Items, *secret.DeepCopy())
+	}
+	return nil
+}
+
+// GetInformer implements cache.Informers
+func (c *secretCache) GetInformer(ctx context.Context, obj client.Object) (cache.Informer, error) {
+	if _, ok := obj.(*corev1.Secret); ok {
+		return c.informer, nil
+	}
+	return c.Cache.GetInformer(ctx, obj)
+}
+
+// GetInformerForKind implements cache.Informers
+func (c *secretCache) GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (cache.Informer, error) {
+	if gvk == secretKind {
+		return c.informer, nil
+	}
+	return c.Cache.GetInformerForKind(ctx, gvk)
+}
+
+// Start implements cache.Informers
+func (c *secretCache) Start(ctx context.Context) error {
+	go c.informer.Run(ctx.Done())
+	return c.Cache.Start(ctx)
+}
+
+// WaitForCacheSync implements cache.Informers
+func (c *secretCache) WaitForCacheSync(ctx context.Context) bool {
+	return toolscache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) && c.Cache.WaitForCacheSync(ctx)
+}
+
+// IndexField implements client.FieldIndexer
+func (c *secretCache) IndexField(ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	if _, ok := obj.(*corev1.Secret); ok {
+		return fmt.Errorf("field indexes are not supported on the cached Secrets")
+	}
+	return c.Cache.IndexField(ctx, obj, field, extractValue)
+}
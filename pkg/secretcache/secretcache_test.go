@@ -0,0 +1,116 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secretcache
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/typed/core/v1/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newSecret(namespace, name, team string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{"watched": "true", "team": team},
+		},
+		Data: map[string][]byte{"token": []byte(name)},
+	}
+}
+
+var _ = Describe("Secret cache", func() {
+	var (
+		c        *secretCache
+		watcher  *watch.FakeWatcher
+		selector labels.Selector
+		cancel   context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		watcher = watch.NewFake()
+		f := &clienttesting.Fake{}
+		f.AddReactor("list", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			selector = action.(clienttesting.ListAction).GetListRestrictions().Labels
+			return true, &corev1.SecretList{Items: []corev1.Secret{
+				*newSecret("team-a", "prometheus", "a"),
+				*newSecret("team-b", "prometheus", "b"),
+			}}, nil
+		})
+		f.AddWatchReactor("secrets", func(clienttesting.Action) (bool, watch.Interface, error) {
+			return true, watcher, nil
+		})
+
+		c = newCache(nil, listWatch(&fake.FakeCoreV1{Fake: f}, "",
+			labels.SelectorFromSet(labels.Set{"watched": "true"})), time.Hour)
+		go c.informer.Run(ctx.Done())
+		Eventually(c.informer.HasSynced).Should(BeTrue())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should only list and watch the selected Secrets", func() {
+		Expect(selector.String()).To(Equal("watched=true"))
+	})
+
+	It("should read the Secrets from the informer", func() {
+		ctx := context.Background()
+		var secret corev1.Secret
+		Expect(c.Get(ctx, types.NamespacedName{Namespace: "team-a", Name: "prometheus"}, &secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("token", []byte("prometheus")))
+		Expect(secret.Kind).To(Equal("Secret"))
+
+		err := c.Get(ctx, types.NamespacedName{Namespace: "team-a", Name: "alertmanager"}, &secret)
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		watcher.Add(newSecret("team-a", "alertmanager", "a"))
+		Eventually(func() error {
+			return c.Get(ctx, types.NamespacedName{Namespace: "team-a", Name: "alertmanager"}, &secret)
+		}).Should(Succeed())
+	})
+
+	It("should list the Secrets by namespace and labels", func() {
+		ctx := context.Background()
+		var secrets corev1.SecretList
+		Expect(c.List(ctx, &secrets)).To(Succeed())
+		Expect(secrets.Items).To(HaveLen(2))
+
+		Expect(c.List(ctx, &secrets, client.InNamespace("team-b"))).To(Succeed())
+		Expect(secrets.Items).To(HaveLen(1))
+		Expect(secrets.Items[0].Namespace).To(Equal("team-b"))
+
+		Expect(c.List(ctx, &secrets, client.MatchingLabels{"team": "a"})).To(Succeed())
+		Expect(secrets.Items).To(HaveLen(1))
+		Expect(secrets.Items[0].Namespace).To(Equal("team-a"))
+	})
+})
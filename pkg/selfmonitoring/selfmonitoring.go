@@ -0,0 +1,303 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package selfmonitoring defines the Rule alerting on the metrics of the
+// operator itself and keeps it installed in the cluster.
+package selfmonitoring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/promql"
+)
+
+var log = logf.Log.WithName("selfmonitoring")
+
+const (
+	// ManagedByLabel is set on the Rule installed by the operator
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "prometheus-rules-operator"
+
+	// groupName is the name of the group of the Rule
+	groupName = "prometheus-rules-operator"
+
+	// retryPeriod is the delay before installing the Rule again after a
+	// failure
+	retryPeriod = 30 * time.Second
+
+	// resyncPeriod is the period at which the Rule is installed again, to
+	// restore it when it was changed or deleted outside of the operator
+	resyncPeriod = 5 * time.Minute
+)
+
+// Rule returns the Rule alerting on the operator according to settings
+func Rule(settings *operatorconfig.SelfMonitoring) *monitoringv1alpha1.Rule {
+	rule := &monitoringv1alpha1.Rule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: settings.Namespace, Name: settings.Name},
+	}
+	rule.Labels = labels(settings)
+	rule.Spec = spec(settings)
+	return rule
+}
+
+// labels returns the labels of the Rule: the labels configured by settings
+// and ManagedByLabel
+func labels(settings *operatorconfig.SelfMonitoring) map[string]string {
+	labels := make(map[string]string, len(settings.Labels)+1)
+	for name, value := range settings.Labels {
+		labels[name] = value
+	}
+	labels[ManagedByLabel] = managedBy
+	return labels
+}
+
+// spec returns the alerts on the operator metrics scraped with the job of
+// settings
+func spec(settings *operatorconfig.SelfMonitoring) monitoringv1alpha1.RuleSpec {
+	job := strconv.Quote(settings.Job)
+	labels := map[string]string{"severity": "warning"}
+	pending := promql.FormatDuration(settings.For)
+	return monitoringv1alpha1.RuleSpec{Groups: []monitoringv1alpha1.RuleGroup{{
+		Name: groupName,
+		Rules: []monitoringv1alpha1.RuleDefinition{
+			{
+				Alert:  "PrometheusRulesOperatorDown",
+				Expr:   fmt.Sprintf(`absent(up{job=%v} == 1)`, job),
+				For:    pending,
+				Labels: labels,
+				Annotations: map[string]string{
+					"summary": "The operator metrics can't be scraped",
+				},
+			},
+			{
+				Alert: "PrometheusRulesOperatorReconcileErrors",
+				Expr: fmt.Sprintf(`sum by (controller) (increase(controller_runtime_reconcile_errors_total{job=%v}[5m])) > %d`,
+					job, settings.ReconcileErrors),
+				For:    pending,
+				Labels: labels,
+				Annotations: map[string]string{
+					"summary":     "The {{ $labels.controller }} controller fails to reconcile",
+					"description": "{{ $value }} reconcile errors in the last 5 minutes.",
+				},
+			},
+			{
+				Alert: "PrometheusRulesOperatorProblemRules",
+				Expr: fmt.Sprintf(`sum by (namespace, state) (%v{job=%v, state!~"%v|%v"}) > %d`,
+					metrics.RulesMetric, job, metrics.StateOK, metrics.StateUnknown, settings.ProblemRules),
+				For:    pending,
+				Labels: labels,
+				Annotations: map[string]string{
+					"summary":     "Rules of namespace {{ $labels.namespace }} report {{ $labels.state }}",
+					"description": "{{ $value }} Rules have the {{ $labels.state }} condition.",
+				},
+			},
+			{
+				Alert: "PrometheusRulesOperatorWebhookSlow",
+				Expr: fmt.Sprintf(`histogram_quantile(0.99, sum by (webhook, le) (rate(controller_runtime_webhook_latency_seconds_bucket{job=%v}[5m]))) > %v`,
+					job, strconv.FormatFloat(settings.WebhookLatency.Seconds(), 'f', -1, 64)),
+				For:    pending,
+				Labels: labels,
+				Annotations: map[string]string{
+					"summary":     "The {{ $labels.webhook }} webhook is slow",
+					"description": "99% of the admission requests are answered in {{ $value }}s.",
+				},
+			},
+			{
+				Alert: "PrometheusRulesOperatorTargetReloadFailures",
+				Expr: fmt.Sprintf(`sum by (target) (increase(%v{job=%v}[1h])) > %d or min by (target) (%v{job=%v}) == 0`,
+					metrics.TargetReloadFailuresMetric, job, settings.ReloadFailures, metrics.TargetReloadSuccessfulMetric, job),
+				For:    pending,
+				Labels: labels,
+				Annotations: map[string]string{
+					"summary":     "The PrometheusTarget {{ $labels.target }} fails to reload its configuration",
+					"description": "The rule files written by the operator may not be loaded.",
+				},
+			},
+			{
+				Alert: "PrometheusRulesOperatorRulesNotLoaded",
+				Expr: fmt.Sprintf(`max by (target) (%v{job=%v}) > %d`,
+					metrics.TargetRulesNotLoadedMetric, job, settings.NotLoadedRules),
+				For:    pending,
+				Labels: labels,
+				Annotations: map[string]string{
+					"summary":     "Rules are not loaded by the PrometheusTarget {{ $labels.target }}",
+					"description": "{{ $value }} Rules selected by the target are not loaded, the targets of their status tell why.",
+				},
+			},
+		},
+	}}}
+}
+
+// Installer is a manager.Runnable creating the Rule alerting on the operator
+// and updating it whenever the settings of Store are reloaded. The Rule is
+// deleted when the self monitoring is disabled or moved by a reload. Changes
+// made to the Rule outside of the operator are periodically reverted.
+//
+// The Installer runs on the elected leader. Its initial sync deletes the
+// Rules left by previous runs before installing the Rule.
+type Installer struct {
+	Client   client.Client
+	Store    *operatorconfig.Store
+	Recorder record.EventRecorder
+
+	// installed is the Rule installed last and applied the settings it was
+	// installed with
+	installed *types.NamespacedName
+	applied   *operatorconfig.SelfMonitoring
+
+	// synced is set once the initial sync completed, it is accessed
+	// atomically
+	synced int32
+}
+
+// Start implements manager.Runnable
+func (i *Installer) Start(ctx context.Context) error {
+	changes := i.Store.Subscribe()
+	for {
+		wait := resyncPeriod
+		if err := i.sync(ctx, i.Store.Get().SelfMonitoring); err != nil {
+			log.Error(err, "unable to install the self monitoring rule")
+			wait = retryPeriod
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changes:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// sync installs the Rule configured by settings, deleting the orphaned Rules
+// first until the initial sync completed
+func (i *Installer) sync(ctx context.Context, settings *operatorconfig.SelfMonitoring) error {
+	if !i.Synced() {
+		if err := i.collect(ctx, settings); err != nil {
+			return err
+		}
+	}
+	if err := i.install(ctx, settings); err != nil {
+		return err
+	}
+	if !i.Synced() {
+		log.Info("initial sync of the self monitoring rule completed")
+		atomic.StoreInt32(&i.synced, 1)
+	}
+	return nil
+}
+
+// collect deletes the Rules installed by the operator that settings don't
+// configure, like a Rule renamed while the operator was down
+func (i *Installer) collect(ctx context.Context, settings *operatorconfig.SelfMonitoring) error {
+	var rules monitoringv1alpha1.RuleList
+	if err := i.Client.List(ctx, &rules, client.MatchingLabels{ManagedByLabel: managedBy}); err != nil {
+		return fmt.Errorf("unable to list self monitoring rules: %w", err)
+	}
+	for idx := range rules.Items {
+		rule := &rules.Items[idx]
+		if settings != nil && rule.Namespace == settings.Namespace && rule.Name == settings.Name {
+			continue
+		}
+		if err := i.Client.Delete(ctx, rule); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete orphaned rule %v/%v: %w", rule.Namespace, rule.Name, err)
+		}
+		log.Info("orphaned self monitoring rule deleted", "rule", client.ObjectKeyFromObject(rule))
+	}
+	return nil
+}
+
+// Synced reports whether the initial sync completed
+func (i *Installer) Synced() bool {
+	return atomic.LoadInt32(&i.synced) != 0
+}
+
+// Check is a healthz.Checker failing until the initial sync completed
+func (i *Installer) Check(*http.Request) error {
+	if !i.Synced() {
+		return errors.New("initial sync of the self monitoring rule not completed")
+	}
+	return nil
+}
+
+// install creates or updates the Rule configured by settings, deleting the
+// Rule installed before under another name
+func (i *Installer) install(ctx context.Context, settings *operatorconfig.SelfMonitoring) error {
+	var key *types.NamespacedName
+	if settings != nil {
+		key = &types.NamespacedName{Namespace: settings.Namespace, Name: settings.Name}
+	}
+	if i.installed != nil && (key == nil || *key != *i.installed) {
+		previous := &monitoringv1alpha1.Rule{
+			ObjectMeta: metav1.ObjectMeta{Namespace: i.installed.Namespace, Name: i.installed.Name},
+		}
+		if err := i.Client.Delete(ctx, previous); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete rule %v: %w", i.installed, err)
+		}
+		log.Info("self monitoring rule deleted", "rule", i.installed)
+		i.installed = nil
+		i.applied = nil
+	}
+	if settings == nil {
+		return nil
+	}
+
+	rule := &monitoringv1alpha1.Rule{
+		ObjectMeta: metav1.ObjectMeta{Namespace: settings.Namespace, Name: settings.Name},
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, i.Client, rule, func() error {
+		// labels removed from the settings, or added outside of the
+		// operator, are dropped
+		rule.Labels = labels(settings)
+		rule.Spec = spec(settings)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to install rule %v: %w", key, err)
+	}
+	switch {
+	case result == controllerutil.OperationResultNone:
+	case i.installed != nil && reflect.DeepEqual(settings, i.applied):
+		// the settings didn't change since the Rule was installed
+		log.Info("self monitoring rule changed outside of the operator, restored", "rule", key, "operation", result)
+		i.Recorder.Eventf(rule, corev1.EventTypeWarning, "DriftCorrected",
+			"rule was changed outside of the operator and restored (%v)", result)
+		metrics.DriftCorrections.WithLabelValues("Rule").Inc()
+	default:
+		log.Info("self monitoring rule installed", "rule", key, "operation", result)
+	}
+	i.installed = key
+	i.applied = settings
+	return nil
+}
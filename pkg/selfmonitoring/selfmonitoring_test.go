@@ -0,0 +1,183 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selfmonitoring
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/validation"
+)
+
+var _ = Describe("Rule", func() {
+	settings := &operatorconfig.SelfMonitoring{
+		Namespace:       "monitoring",
+		Name:            "operator",
+		Labels:          map[string]string{"prometheus": "main"},
+		Job:             "operator-metrics",
+		For:             15 * time.Minute,
+		ReconcileErrors: 3,
+		ProblemRules:    1,
+		WebhookLatency:  500 * time.Millisecond,
+		ReloadFailures:  2,
+		NotLoadedRules:  5,
+	}
+
+	It("should be valid", func() {
+		rule := Rule(settings)
+		Expect(rule.Namespace).To(Equal("monitoring"))
+		Expect(rule.Name).To(Equal("operator"))
+		Expect(rule.Labels).To(Equal(map[string]string{
+			"prometheus":   "main",
+			ManagedByLabel: managedBy,
+		}))
+		Expect(validation.Validate(rule)).To(BeEmpty())
+	})
+
+	It("should apply the thresholds", func() {
+		rules := Rule(settings).Spec.Groups[0].Rules
+		exprs := make(map[string]string, len(rules))
+		for _, r := range rules {
+			Expect(r.For).To(Equal("15m"))
+			exprs[r.Alert] = r.Expr
+		}
+		Expect(exprs).To(Equal(map[string]string{
+			"PrometheusRulesOperatorDown": `absent(up{job="operator-metrics"} == 1)`,
+			"PrometheusRulesOperatorReconcileErrors": `sum by (controller) ` +
+				`(increase(controller_runtime_reconcile_errors_total{job="operator-metrics"}[5m])) > 3`,
+			"PrometheusRulesOperatorProblemRules": `sum by (namespace, state) ` +
+				`(prometheus_rules_operator_rules{job="operator-metrics", state!~"OK|Unknown"}) > 1`,
+			"PrometheusRulesOperatorWebhookSlow": `histogram_quantile(0.99, sum by (webhook, le) ` +
+				`(rate(controller_runtime_webhook_latency_seconds_bucket{job="operator-metrics"}[5m]))) > 0.5`,
+			"PrometheusRulesOperatorTargetReloadFailures": `sum by (target) ` +
+				`(increase(prometheus_rules_operator_target_reload_failures_total{job="operator-metrics"}[1h])) > 2 ` +
+				`or min by (target) (prometheus_rules_operator_target_last_reload_successful{job="operator-metrics"}) == 0`,
+			"PrometheusRulesOperatorRulesNotLoaded": `max by (target) ` +
+				`(prometheus_rules_operator_target_rules_not_loaded{job="operator-metrics"}) > 5`,
+		}))
+	})
+})
+
+var _ = Describe("Installer", func() {
+	var (
+		c         client.Client
+		recorder  *record.FakeRecorder
+		installer *Installer
+		settings  *operatorconfig.SelfMonitoring
+		key       = types.NamespacedName{Namespace: "monitoring", Name: "operator"}
+	)
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(monitoringv1alpha1.AddToScheme(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().WithScheme(scheme).Build()
+		recorder = record.NewFakeRecorder(10)
+		installer = &Installer{Client: c, Recorder: recorder}
+		settings = &operatorconfig.SelfMonitoring{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+			Job:       "operator-metrics",
+			For:       operatorconfig.DefaultSelfMonitoringFor,
+		}
+	})
+
+	It("should restore the rule changed outside of the operator", func() {
+		ctx := context.Background()
+		corrections := testutil.ToFloat64(metrics.DriftCorrections.WithLabelValues("Rule"))
+		Expect(installer.install(ctx, settings)).To(Succeed())
+		Expect(installer.install(ctx, settings)).To(Succeed())
+		Expect(recorder.Events).To(BeEmpty())
+
+		var rule monitoringv1alpha1.Rule
+		Expect(c.Get(ctx, key, &rule)).To(Succeed())
+		rule.Spec.Groups[0].Rules = rule.Spec.Groups[0].Rules[:1]
+		Expect(c.Update(ctx, &rule)).To(Succeed())
+
+		Expect(installer.install(ctx, settings)).To(Succeed())
+		Expect(c.Get(ctx, key, &rule)).To(Succeed())
+		Expect(rule.Spec).To(Equal(Rule(settings).Spec))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning DriftCorrected")))
+		Expect(testutil.ToFloat64(metrics.DriftCorrections.WithLabelValues("Rule"))).To(Equal(corrections + 1))
+
+		Expect(c.Delete(ctx, &rule)).To(Succeed())
+		Expect(installer.install(ctx, settings)).To(Succeed())
+		Expect(c.Get(ctx, key, &rule)).To(Succeed())
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning DriftCorrected")))
+		Expect(testutil.ToFloat64(metrics.DriftCorrections.WithLabelValues("Rule"))).To(Equal(corrections + 2))
+	})
+
+	It("should delete the orphaned rules on the initial sync", func() {
+		ctx := context.Background()
+		orphan := Rule(&operatorconfig.SelfMonitoring{Namespace: "monitoring", Name: "renamed"})
+		user := Rule(&operatorconfig.SelfMonitoring{Namespace: "monitoring", Name: "user"})
+		delete(user.Labels, ManagedByLabel)
+		Expect(c.Create(ctx, orphan)).To(Succeed())
+		Expect(c.Create(ctx, user)).To(Succeed())
+
+		Expect(installer.Check(nil)).NotTo(Succeed())
+		Expect(installer.sync(ctx, settings)).To(Succeed())
+		Expect(installer.Check(nil)).To(Succeed())
+		var rules monitoringv1alpha1.RuleList
+		Expect(c.List(ctx, &rules)).To(Succeed())
+		names := make([]string, 0, len(rules.Items))
+		for _, rule := range rules.Items {
+			names = append(names, rule.Name)
+		}
+		Expect(names).To(ConsistOf("operator", "user"))
+	})
+
+	It("should only keep the labels of the settings", func() {
+		ctx := context.Background()
+		settings.Labels = map[string]string{"prometheus": "main", "team": "ops"}
+		Expect(installer.install(ctx, settings)).To(Succeed())
+		var rule monitoringv1alpha1.Rule
+		Expect(c.Get(ctx, key, &rule)).To(Succeed())
+		rule.Labels["added"] = "outside"
+		Expect(c.Update(ctx, &rule)).To(Succeed())
+
+		updated := *settings
+		updated.Labels = map[string]string{"prometheus": "main"}
+		Expect(installer.install(ctx, &updated)).To(Succeed())
+		var installed monitoringv1alpha1.Rule
+		Expect(c.Get(ctx, key, &installed)).To(Succeed())
+		Expect(installed.Labels).To(Equal(map[string]string{
+			"prometheus":   "main",
+			ManagedByLabel: "prometheus-rules-operator",
+		}))
+	})
+
+	It("should not report updates of the settings as drift", func() {
+		ctx := context.Background()
+		Expect(installer.install(ctx, settings)).To(Succeed())
+		updated := *settings
+		updated.For = time.Minute
+		Expect(installer.install(ctx, &updated)).To(Succeed())
+		Expect(recorder.Events).To(BeEmpty())
+	})
+})
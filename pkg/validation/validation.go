@@ -0,0 +1,128 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package validation checks the structure of Rules. It is shared by the
+// validating webhook, which rejects invalid Rules in strict mode, and the
+// Rule controller, which reports them.
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/promql"
+)
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// IsLabelName reports whether name is a valid Prometheus label name
+func IsLabelName(name string) bool {
+	return labelNameRE.MatchString(name)
+}
+
+// Validate returns a description of each problem of rule that Prometheus
+// would refuse to load. Expressions are only checked lexically, see
+// promql.Check, their grammar errors aren't detected.
+func Validate(rule *monitoringv1alpha1.Rule) []string {
+	var problems []string
+	groups := make(map[string]struct{}, len(rule.Spec.Groups))
+	mergeKeys := make(map[string]struct{})
+	for _, group := range rule.Spec.Groups {
+		if group.Name == "" {
+			problems = append(problems, "group without name")
+		} else if _, ok := groups[group.Name]; ok {
+			problems = append(problems, fmt.Sprintf("group %q is defined more than once", group.Name))
+		}
+		groups[group.Name] = struct{}{}
+		if group.MergeKey != "" {
+			if _, ok := mergeKeys[group.MergeKey]; ok {
+				problems = append(problems, fmt.Sprintf("group %q: merge key %q is used by another group",
+					group.Name, group.MergeKey))
+			}
+			mergeKeys[group.MergeKey] = struct{}{}
+		}
+
+		if group.Interval != "" {
+			if _, err := promql.ParseDuration(group.Interval); err != nil {
+				problems = append(problems, fmt.Sprintf("group %q: invalid interval: %v", group.Name, err))
+			}
+		}
+		if group.QueryOffset != "" {
+			if _, err := promql.ParseDuration(group.QueryOffset); err != nil {
+				problems = append(problems, fmt.Sprintf("group %q: invalid query offset: %v", group.Name, err))
+			}
+		}
+		for i, def := range group.Rules {
+			for _, problem := range validateDefinition(&def) {
+				problems = append(problems, fmt.Sprintf("group %q, rule %d: %v", group.Name, i, problem))
+			}
+		}
+	}
+	return problems
+}
+
+// validateDefinition returns the problems of a single recording or alerting
+// rule
+func validateDefinition(def *monitoringv1alpha1.RuleDefinition) []string {
+	var problems []string
+	switch {
+	case def.Record != "" && def.Alert != "":
+		problems = append(problems, "only one of record and alert may be set")
+	case def.Record == "" && def.Alert == "":
+		problems = append(problems, "one of record and alert must be set")
+	case def.Record != "" && !metricNameRE.MatchString(def.Record):
+		problems = append(problems, fmt.Sprintf("invalid record name %q", def.Record))
+	}
+	if def.Record != "" && def.For != "" {
+		problems = append(problems, "for is only allowed for alerts")
+	}
+	if def.Record != "" && len(def.Annotations) > 0 {
+		problems = append(problems, "annotations are only allowed for alerts")
+	}
+	if def.For != "" {
+		if _, err := promql.ParseDuration(def.For); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid for: %v", err))
+		}
+	}
+	if err := promql.Check(def.Expr); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid expr: %v", err))
+	}
+	for _, name := range sortedKeys(def.Labels) {
+		if !labelNameRE.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("invalid label name %q", name))
+		}
+	}
+	for _, name := range sortedKeys(def.Annotations) {
+		if !labelNameRE.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("invalid annotation name %q", name))
+		}
+	}
+	return problems
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+)
+
+func newRule(groups ...monitoringv1alpha1.RuleGroup) *monitoringv1alpha1.Rule {
+	return &monitoringv1alpha1.Rule{Spec: monitoringv1alpha1.RuleSpec{Groups: groups}}
+}
+
+var _ = Describe("Validate", func() {
+	It("should accept valid rules", func() {
+		Expect(Validate(newRule(monitoringv1alpha1.RuleGroup{
+			Name:     "api",
+			Interval: "30s",
+			Rules: []monitoringv1alpha1.RuleDefinition{
+				{Record: "job:up:sum", Expr: "sum by (job) (up)", Labels: map[string]string{"team": "api"}},
+				{Alert: "Down", Expr: "up == 0", For: "5m", Annotations: map[string]string{"summary": "down"}},
+			},
+		}))).To(BeEmpty())
+	})
+
+	It("should report invalid groups", func() {
+		Expect(Validate(newRule(
+			monitoringv1alpha1.RuleGroup{Name: "api", Interval: "often"},
+			monitoringv1alpha1.RuleGroup{Name: "api"},
+			monitoringv1alpha1.RuleGroup{Name: "late", QueryOffset: "later"},
+			monitoringv1alpha1.RuleGroup{},
+			monitoringv1alpha1.RuleGroup{Name: "web", MergeKey: "shared"},
+			monitoringv1alpha1.RuleGroup{Name: "db", MergeKey: "shared"},
+		))).To(Equal([]string{
+			`group "api": invalid interval: invalid duration "often"`,
+			`group "api" is defined more than once`,
+			`group "late": invalid query offset: invalid duration "later"`,
+			"group without name",
+			`group "db": merge key "shared" is used by another group`,
+		}))
+	})
+
+	It("should report invalid rules", func() {
+		Expect(Validate(newRule(monitoringv1alpha1.RuleGroup{
+			Name: "api",
+			Rules: []monitoringv1alpha1.RuleDefinition{
+				{Record: "job:up:sum", Alert: "Down", Expr: "up"},
+				{Expr: "up"},
+				{Record: "job-up", Expr: "up", For: "5m"},
+				{Alert: "Down", Expr: "sum(up", Labels: map[string]string{"a-b": "c"}},
+			},
+		}))).To(Equal([]string{
+			`group "api", rule 0: only one of record and alert may be set`,
+			`group "api", rule 1: one of record and alert must be set`,
+			`group "api", rule 2: invalid record name "job-up"`,
+			`group "api", rule 2: for is only allowed for alerts`,
+			`group "api", rule 3: invalid expr: unclosed '('`,
+			`group "api", rule 3: invalid label name "a-b"`,
+		}))
+	})
+})
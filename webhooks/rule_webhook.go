@@ -0,0 +1,129 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/metrics"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/quota"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/validation"
+)
+
+// log is for logging in this package.
+var rulelog = logf.Log.WithName("rule-webhook")
+
+// RuleValidatorPath is the path serving RuleValidator
+const RuleValidatorPath = "/validate-monitoring-cyrilix-fr-v1alpha1-rule"
+
+//+kubebuilder:webhook:path=/validate-monitoring-cyrilix-fr-v1alpha1-rule,mutating=false,failurePolicy=fail,sideEffects=None,groups=monitoring.cyrilix.fr,resources=rules,verbs=create;update,versions=v1alpha1,name=vrule.kb.io,admissionReviewVersions={v1,v1beta1}
+
+// RuleValidator rejects Rules exceeding the RuleQuotas of their namespace and,
+// when validation is strict, the invalid Rules selected by the operator
+type RuleValidator struct {
+	Client client.Client
+	// Config holds the operator settings
+	Config  *operatorconfig.Store
+	decoder *admission.Decoder
+}
+
+// Handle implements admission.Handler
+func (v *RuleValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var rule monitoringv1alpha1.Rule
+	if err := v.decoder.Decode(req, &rule); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	rulelog.V(1).Info("validate", "namespace", req.Namespace, "name", req.Name, "operation", req.Operation)
+
+	var previous *monitoringv1alpha1.Rule
+	if req.Operation == admissionv1.Update {
+		previous = &monitoringv1alpha1.Rule{}
+		if err := v.decoder.DecodeRaw(req.OldObject, previous); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	violations, err := v.quotaViolations(ctx, &rule, previous)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if len(violations) > 0 {
+		metrics.ValidationFailures.WithLabelValues("QuotaExceeded").Inc()
+	}
+	if settings := v.Config.Get(); settings.Strict {
+		selected, err := settings.Selects(ctx, v.Client, &rule)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if problems := validation.Validate(&rule); selected && len(problems) > 0 {
+			metrics.ValidationFailures.WithLabelValues("InvalidSpec").Inc()
+			violations = append(violations, problems...)
+		}
+	}
+	if len(violations) > 0 {
+		return admission.Denied(strings.Join(violations, "; "))
+	}
+	return admission.Allowed("")
+}
+
+// quotaViolations returns a description of the limits of the RuleQuotas of
+// the namespace of rule exceeded when replacing previous, if any, by rule
+func (v *RuleValidator) quotaViolations(ctx context.Context, rule, previous *monitoringv1alpha1.Rule) ([]string, error) {
+	var quotas monitoringv1alpha1.RuleQuotaList
+	if err := v.Client.List(ctx, &quotas, client.InNamespace(rule.Namespace)); err != nil {
+		return nil, err
+	}
+	if len(quotas.Items) == 0 {
+		return nil, nil
+	}
+
+	var rules monitoringv1alpha1.RuleList
+	if err := v.Client.List(ctx, &rules, client.InNamespace(rule.Namespace)); err != nil {
+		return nil, err
+	}
+	others := make([]*monitoringv1alpha1.Rule, 0, len(rules.Items))
+	for i := range rules.Items {
+		if rules.Items[i].Name != rule.Name {
+			others = append(others, &rules.Items[i])
+		}
+	}
+	before := quota.Usage(append(others, previous)...)
+	after := quota.Usage(append(others, rule)...)
+
+	var violations []string
+	for i := range quotas.Items {
+		q := &quotas.Items[i]
+		violations = append(violations, quota.Exceeded(q, before, after)...)
+		violations = append(violations, quota.IntervalViolations(q, rule)...)
+	}
+	return violations, nil
+}
+
+// InjectDecoder implements admission.DecoderInjector
+func (v *RuleValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
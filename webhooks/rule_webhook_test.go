@@ -0,0 +1,188 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	configv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/config/v1alpha1"
+	monitoringv1alpha1 "github.com/cyrilix/prometheus-rules-operator/api/v1alpha1"
+	"github.com/cyrilix/prometheus-rules-operator/pkg/operatorconfig"
+)
+
+func newRule(name string, intervals ...string) *monitoringv1alpha1.Rule {
+	rule := &monitoringv1alpha1.Rule{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+	for _, interval := range intervals {
+		rule.Spec.Groups = append(rule.Spec.Groups, monitoringv1alpha1.RuleGroup{
+			Name:     "group-" + interval,
+			Interval: interval,
+			Rules:    []monitoringv1alpha1.RuleDefinition{{Record: "job:up:sum", Expr: "sum by (job) (up)"}},
+		})
+	}
+	return rule
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func raw(rule *monitoringv1alpha1.Rule) runtime.RawExtension {
+	data, err := json.Marshal(rule)
+	Expect(err).NotTo(HaveOccurred())
+	return runtime.RawExtension{Raw: data}
+}
+
+func create(rule *monitoringv1alpha1.Rule) admission.Request {
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Namespace: rule.Namespace,
+		Name:      rule.Name,
+		Object:    raw(rule),
+	}}
+}
+
+func update(previous, rule *monitoringv1alpha1.Rule) admission.Request {
+	req := create(rule)
+	req.Operation = admissionv1.Update
+	req.OldObject = raw(previous)
+	return req
+}
+
+var _ = Describe("RuleValidator", func() {
+	var (
+		ctx       context.Context
+		scheme    *runtime.Scheme
+		config    configv1alpha1.RulesConfig
+		objects   []client.Object
+		validator func() *RuleValidator
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme = runtime.NewScheme()
+		Expect(monitoringv1alpha1.AddToScheme(scheme)).To(Succeed())
+		config = configv1alpha1.RulesConfig{}
+		objects = nil
+		validator = func() *RuleValidator {
+			settings, err := operatorconfig.New(&config, nil)
+			Expect(err).NotTo(HaveOccurred())
+			v := &RuleValidator{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+				Config: operatorconfig.NewStore(settings),
+			}
+			decoder, err := admission.NewDecoder(scheme)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v.InjectDecoder(decoder)).To(Succeed())
+			return v
+		}
+	})
+
+	It("should reject requests that can't be decoded", func() {
+		req := create(newRule("rule"))
+		req.Object.Raw = []byte("{")
+		resp := validator().Handle(ctx, req)
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusBadRequest))
+
+		req = update(newRule("rule"), newRule("rule"))
+		req.OldObject.Raw = []byte("{")
+		resp = validator().Handle(ctx, req)
+		Expect(resp.Allowed).To(BeFalse())
+		Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusBadRequest))
+	})
+
+	It("should allow Rules when the namespace has no RuleQuota", func() {
+		Expect(validator().Handle(ctx, create(newRule("rule", "1m", "1m", "1m"))).Allowed).To(BeTrue())
+	})
+
+	Context("with a RuleQuota", func() {
+		BeforeEach(func() {
+			objects = append(objects,
+				&monitoringv1alpha1.RuleQuota{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "quota"},
+					Spec:       monitoringv1alpha1.RuleQuotaSpec{MaxGroups: int32Ptr(2), MinInterval: "30s"},
+				},
+				newRule("existing", "1m", "1m"),
+			)
+		})
+
+		It("should deny creations exceeding the quota", func() {
+			resp := validator().Handle(ctx, create(newRule("rule", "1m")))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(string(resp.Result.Reason)).To(ContainSubstring("3 groups exceed the limit of 2 set by RuleQuota quota"))
+
+			resp = validator().Handle(ctx, create(newRule("rule", "10s")))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(string(resp.Result.Reason)).To(ContainSubstring(`group "group-10s" interval 10s is below the minimum of 30s`))
+		})
+
+		It("should only deny updates growing an exceeded usage", func() {
+			existing := newRule("existing", "1m", "1m")
+			Expect(validator().Handle(ctx, update(existing, newRule("existing", "2m", "2m"))).Allowed).To(BeTrue())
+
+			resp := validator().Handle(ctx, update(existing, newRule("existing", "1m", "1m", "1m")))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(string(resp.Result.Reason)).To(ContainSubstring("3 groups exceed the limit of 2"))
+		})
+
+		It("should deny Rules when the minimum interval of the quota is invalid", func() {
+			objects[0].(*monitoringv1alpha1.RuleQuota).Spec.MinInterval = "soon"
+			resp := validator().Handle(ctx, create(newRule("rule")))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusForbidden))
+			Expect(string(resp.Result.Reason)).To(ContainSubstring("invalid minInterval of RuleQuota quota"))
+		})
+	})
+
+	Context("with strict validation", func() {
+		var invalid *monitoringv1alpha1.Rule
+
+		BeforeEach(func() {
+			config.Validation.Strict = true
+			invalid = newRule("invalid", "1m")
+			invalid.Spec.Groups[0].Rules[0].Expr = "sum(up"
+		})
+
+		It("should deny invalid Rules", func() {
+			resp := validator().Handle(ctx, create(invalid))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Code).To(BeEquivalentTo(http.StatusForbidden))
+			Expect(validator().Handle(ctx, create(newRule("valid", "1m"))).Allowed).To(BeTrue())
+		})
+
+		It("should allow invalid Rules not selected by the operator", func() {
+			config.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "api"}}
+			Expect(validator().Handle(ctx, create(invalid)).Allowed).To(BeTrue())
+		})
+
+		It("should allow invalid Rules when validation is not strict", func() {
+			config.Validation.Strict = false
+			Expect(validator().Handle(ctx, create(invalid)).Allowed).To(BeTrue())
+		})
+	})
+})